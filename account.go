@@ -0,0 +1,38 @@
+package possum
+
+import "fmt"
+
+// Account is an AWS account that possum schedules resources in by assuming a role in that account
+type Account struct {
+	ID         string // The AWS account ID
+	Name       string // A human readable name for the account, used in notifications
+	RoleARN    string // The ARN of the role possum assumes in the account, if empty the lambda credentials are used
+	ExternalID string // The external ID that the role trust policy requires, optional
+}
+
+// String returns the name of the account, or the account ID if there is no name
+func (a *Account) String() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.ID
+}
+
+// checkAccountNames returns an error if two configured accounts go by the same name, the name of an account is what
+// tells it apart in notifications, approvals, the audit log and slack commands
+func checkAccountNames(accounts []*Account) error {
+	seen := make(map[string]*Account)
+	for _, account := range accounts {
+		names := []string{account.ID}
+		if account.Name != "" && account.Name != account.ID {
+			names = append(names, account.Name)
+		}
+		for _, name := range names {
+			if other, ok := seen[name]; ok {
+				return fmt.Errorf("accounts %s and %s are both known as '%s', account names have to be unique", other.ID, account.ID, name)
+			}
+			seen[name] = account
+		}
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package possum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// approvalItemPrefix is the prefix of the ids of the approval items in the config table, followed by the approval ID
+const approvalItemPrefix = "approval#"
+
+// approvalTimeout is how long an approval waits if the schedule of the resource doesn't start it again
+const approvalTimeout = 7 * 24 * time.Hour
+
+// Approval is a stop of a resource with the require approval tag. It waits until someone approves it, or until the
+// schedule would start the resource again.
+type Approval struct {
+	ID         string // account/region/type/resource
+	Account    string
+	Region     string
+	Type       string
+	Resource   string
+	Name       string
+	Schedule   string
+	Requested  time.Time
+	Expires    time.Time // the next start of the schedule, the approval is dropped then
+	ApprovedBy string    // empty while the approval is pending
+	ApprovedAt time.Time
+}
+
+// Approved returns true if someone approved the stop
+func (a *Approval) Approved() bool {
+	return a.ApprovedBy != ""
+}
+
+// ApprovalID returns the ID of the approval of a stop, e.g. prod/us-east-1/rds/reports-db
+func ApprovalID(account, region, resourceType, id string) string {
+	return ResourceKey(account, region, resourceType, id)
+}
+
+// ApprovalStore keeps the approvals in the config table, next to the config
+type ApprovalStore struct {
+	Client dynamodbiface.DynamoDBAPI
+	Table  string
+}
+
+// NewApprovalStore returns nil if there is no config table to keep the approvals in
+func NewApprovalStore(client dynamodbiface.DynamoDBAPI, table string) *ApprovalStore {
+	if table == "" {
+		return nil
+	}
+	return &ApprovalStore{Client: client, Table: table}
+}
+
+// Check holds the stops of resources that require approval. The first time a stop comes up it's stored as a pending
+// approval and returned as an ApprovalAction, so that the notifiers announce it. Later runs leave it out until it's
+// approved, and then keep the stop. Stops that can't be checked, e.g. without a store, aren't made.
+func (s *ApprovalStore) Check(ctx context.Context, account, region string, changes Changes, ts time.Time, schedules Schedules, tags *TagKeys) (Changes, error) {
+	tags = tags.resolved()
+	var result Changes
+	var firstErr error
+	for _, change := range changes {
+		if change.Action != StopAction || !tagEnabled(tagValue(change.tags, tags.RequireApproval)) {
+			result = append(result, change)
+			continue
+		}
+
+		id := ApprovalID(account, region, change.Type, aws.StringValue(change.ID))
+		keep, err := s.check(ctx, id, account, region, &change, ts, schedules)
+		if err != nil {
+			change.Action, change.Error = ApprovalAction, err.Error()
+			firstErr = firstError(firstErr, fmt.Errorf("approval %s: %w", id, err))
+		}
+		if keep || err != nil {
+			result = append(result, change)
+		}
+	}
+	return result, firstErr
+}
+
+// check returns true if the change should be made or announced, a stop that waits for approval becomes an
+// ApprovalAction that is due when the approval expires
+func (s *ApprovalStore) check(ctx context.Context, id, account, region string, change *Change, ts time.Time, schedules Schedules) (bool, error) {
+	if s == nil {
+		return false, errors.New("approvals are kept in the config table, set CONFIG_TABLE")
+	}
+
+	approval, err := s.Get(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if approval != nil && !ts.Before(approval.Expires) {
+		if err := s.delete(ctx, id); err != nil {
+			return false, err
+		}
+		approval = nil
+	}
+	if approval != nil {
+		return approval.Approved(), nil
+	}
+
+	approval = &Approval{
+		ID:        id,
+		Account:   account,
+		Region:    region,
+		Type:      change.Type,
+		Resource:  aws.StringValue(change.ID),
+		Name:      change.Name,
+		Schedule:  change.Schedule,
+		Requested: ts,
+		Expires:   ts.Add(approvalTimeout),
+	}
+	if schedule, err := schedules.Resolve(change.Schedule); err == nil {
+		if start, ok := schedule.NextStart(ts); ok {
+			approval.Expires = start
+		}
+	}
+	if err := s.put(ctx, approval, "attribute_not_exists(id)", nil); err != nil {
+		// another run asked for the approval at the same time, it announces it
+		if isConditionalCheckFailed(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	change.Action, change.Due = ApprovalAction, approval.Expires
+	LoggerFrom(ctx).WithChange(*change).Infof("the stop of '%s' waits for approval until %s", change.Name, approval.Expires.UTC().Format(time.RFC3339))
+	return true, nil
+}
+
+// Approve approves a pending stop, an approval that was approved before is returned as it is
+func (s *ApprovalStore) Approve(ctx context.Context, id, by string, ts time.Time) (*Approval, error) {
+	approval, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if approval == nil || !ts.Before(approval.Expires) {
+		return nil, fmt.Errorf("no pending approval '%s'", id)
+	}
+	if approval.Approved() {
+		return approval, nil
+	}
+
+	approval.ApprovedBy, approval.ApprovedAt = by, ts
+	requested := map[string]*dynamodb.AttributeValue{":requested": {S: aws.String(approval.Requested.UTC().Format(time.RFC3339))}}
+	if err := s.put(ctx, approval, "requested = :requested", requested); err != nil {
+		if isConditionalCheckFailed(err) {
+			return nil, fmt.Errorf("approval '%s' changed since it was read, try again", id)
+		}
+		return nil, err
+	}
+	return approval, nil
+}
+
+// Consume drops the approvals of the approved stops that were made, so that the next stop of the resource needs a new
+// approval, even when someone starts it again before the schedule does
+func (s *ApprovalStore) Consume(ctx context.Context, account, region string, changes Changes, tags *TagKeys) error {
+	tags = tags.resolved()
+	var firstErr error
+	for _, change := range changes {
+		if change.Action != StopAction || change.Error != "" || !tagEnabled(tagValue(change.tags, tags.RequireApproval)) {
+			continue
+		}
+		id := ApprovalID(account, region, change.Type, aws.StringValue(change.ID))
+		if err := s.delete(ctx, id); err != nil {
+			firstErr = firstError(firstErr, fmt.Errorf("approval %s: %w", id, err))
+		}
+	}
+	return firstErr
+}
+
+// Expire drops the approvals that weren't approved, or weren't used, before their schedule started the resource again
+func (s *ApprovalStore) Expire(ctx context.Context, ts time.Time) (int, error) {
+	approvals, err := s.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, approval := range approvals {
+		if ts.Before(approval.Expires) {
+			continue
+		}
+		if err := s.delete(ctx, approval.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Get returns the approval with the id, or nil if there is none
+func (s *ApprovalStore) Get(ctx context.Context, id string) (*Approval, error) {
+	res, err := s.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.Table),
+		Key:            map[string]*dynamodb.AttributeValue{"id": {S: aws.String(approvalItemPrefix + id)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Item) == 0 {
+		return nil, nil
+	}
+	return parseApprovalItem(res.Item)
+}
+
+// List returns every approval, the oldest first
+func (s *ApprovalStore) List(ctx context.Context) ([]*Approval, error) {
+	var approvals []*Approval
+	var parseErr error
+	err := s.Client.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:                 aws.String(s.Table),
+		ConsistentRead:            aws.Bool(true),
+		FilterExpression:          aws.String("begins_with(id, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":prefix": {S: aws.String(approvalItemPrefix)}},
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			approval, err := parseApprovalItem(item)
+			if err != nil {
+				parseErr = fmt.Errorf("item %s: %w", aws.StringValue(item["id"].S), err)
+				return false
+			}
+			approvals = append(approvals, approval)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].Requested.Before(approvals[j].Requested)
+	})
+	return approvals, nil
+}
+
+func (s *ApprovalStore) put(ctx context.Context, approval *Approval, condition string, values map[string]*dynamodb.AttributeValue) error {
+	input := &dynamodb.PutItemInput{
+		TableName:           aws.String(s.Table),
+		Item:                approvalItem(approval),
+		ConditionExpression: aws.String(condition),
+	}
+	if len(values) > 0 {
+		input.ExpressionAttributeValues = values
+	}
+	_, err := s.Client.PutItemWithContext(ctx, input)
+	return err
+}
+
+func (s *ApprovalStore) delete(ctx context.Context, id string) error {
+	_, err := s.Client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.Table),
+		Key:       map[string]*dynamodb.AttributeValue{"id": {S: aws.String(approvalItemPrefix + id)}},
+	})
+	return err
+}
+
+func approvalItem(approval *Approval) map[string]*dynamodb.AttributeValue {
+	str := func(s string) *dynamodb.AttributeValue {
+		if s == "" {
+			return &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+		}
+		return &dynamodb.AttributeValue{S: aws.String(s)}
+	}
+	timestamp := func(t time.Time) *dynamodb.AttributeValue {
+		if t.IsZero() {
+			return str("")
+		}
+		return str(t.UTC().Format(time.RFC3339))
+	}
+	return map[string]*dynamodb.AttributeValue{
+		"id":          str(approvalItemPrefix + approval.ID),
+		"account":     str(approval.Account),
+		"region":      str(approval.Region),
+		"type":        str(approval.Type),
+		"resource":    str(approval.Resource),
+		"name":        str(approval.Name),
+		"schedule":    str(approval.Schedule),
+		"requested":   timestamp(approval.Requested),
+		"expires":     timestamp(approval.Expires),
+		"approved_by": str(approval.ApprovedBy),
+		"approved_at": timestamp(approval.ApprovedAt),
+	}
+}
+
+func parseApprovalItem(item map[string]*dynamodb.AttributeValue) (*Approval, error) {
+	str := func(name string) string {
+		if attr, ok := item[name]; ok && attr.S != nil {
+			return *attr.S
+		}
+		return ""
+	}
+	var parseErr error
+	timestamp := func(name string) time.Time {
+		if str(name) == "" {
+			return time.Time{}
+		}
+		t, err := time.Parse(time.RFC3339, str(name))
+		if err != nil && parseErr == nil {
+			parseErr = err
+		}
+		return t
+	}
+	approval := &Approval{
+		ID:         strings.TrimPrefix(str("id"), approvalItemPrefix),
+		Account:    str("account"),
+		Region:     str("region"),
+		Type:       str("type"),
+		Resource:   str("resource"),
+		Name:       str("name"),
+		Schedule:   str("schedule"),
+		Requested:  timestamp("requested"),
+		Expires:    timestamp("expires"),
+		ApprovedBy: str("approved_by"),
+		ApprovedAt: timestamp("approved_at"),
+	}
+	return approval, parseErr
+}
+
+func isConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// tagValue returns the value of the tag, or nil if the resource doesn't have it
+func tagValue(tags map[string]string, key string) *string {
+	if value, ok := tags[key]; ok {
+		return &value
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package possum
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func (m *mockDynamoDBClient) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, options ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if !m.check(*input.Item["id"].S, input.ConditionExpression, input.ExpressionAttributeValues) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	return m.PutItem(input)
+}
+
+func (m *mockDynamoDBClient) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, options ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	delete(m.config, *input.Key["id"].S)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestApprovalStore_Check(t *testing.T) {
+	office := NewSchedule("OfficeHours")
+	p, _ := NewPeriod("08:00", "18:00", nil)
+	office.AddPeriod(time.Local.String(), p)
+	schedules := Schedules{office}
+
+	stop := func(id, requireApproval string) Change {
+		c := Change{ID: aws.String(id), Name: id, Action: StopAction, Type: DBInstanceResource, Schedule: office.Name, tags: map[string]string{}}
+		if requireApproval != "" {
+			c.tags[defaultTags.RequireApproval] = requireApproval
+		}
+		return c
+	}
+	changes := Changes{stop("reports", "true"), stop("small", ""), stop("medium", "false")}
+
+	client := &mockDynamoDBClient{config: make(map[string]map[string]*dynamodb.AttributeValue)}
+	store := NewApprovalStore(client, "config")
+	ctx := context.Background()
+	ts := newWeekday(time.Monday, 22, 0)
+	id := ApprovalID("prod", "us-east-1", DBInstanceResource, "reports")
+
+	// the first run asks for approval and announces it
+	result, err := store.Check(ctx, "prod", "us-east-1", changes, ts, schedules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 3 || result[0].Action != ApprovalAction || result[1].Action != StopAction || result[2].Action != StopAction {
+		t.Fatalf("expected the stop of reports to wait for approval, got %v", result)
+	}
+	if expected := newWeekday(time.Tuesday, 8, 0); !result[0].Due.Equal(expected) {
+		t.Errorf("expected the approval to expire at the next start %s, got %s", expected, result[0].Due)
+	}
+
+	// later runs leave the stop out until it's approved
+	result, err = store.Check(ctx, "prod", "us-east-1", changes, ts.Add(5*time.Minute), schedules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 || *result[0].ID != "small" {
+		t.Fatalf("expected the pending stop to be left out, got %v", result)
+	}
+
+	approval, err := store.Approve(ctx, id, "jane", ts.Add(10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approval.Approved() || approval.Resource != "reports" || approval.Account != "prod" {
+		t.Errorf("unexpected approval %+v", approval)
+	}
+
+	result, err = store.Check(ctx, "prod", "us-east-1", changes, ts.Add(15*time.Minute), schedules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 3 || result[0].Action != StopAction {
+		t.Fatalf("expected the approved stop to be made, got %v", result)
+	}
+
+	approvals, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approvals) != 1 || approvals[0].ID != id || approvals[0].ApprovedBy != "jane" || !approvals[0].Requested.Equal(ts) {
+		t.Fatalf("expected the approval to be stored, got %+v", approvals)
+	}
+
+	// a stop that failed keeps its approval for the next run
+	failed := append(Changes{}, result...)
+	failed[0].Error = "InvalidDBInstanceState"
+	if err := store.Consume(ctx, "prod", "us-east-1", failed, nil); err != nil {
+		t.Fatal(err)
+	}
+	if approval, err := store.Get(ctx, id); err != nil || approval == nil || !approval.Approved() {
+		t.Fatalf("expected a failed stop to keep its approval, got %+v %v", approval, err)
+	}
+
+	// the stop uses up the approval, stopping the resource again before the next start needs another one
+	if err := store.Consume(ctx, "prod", "us-east-1", result, nil); err != nil {
+		t.Fatal(err)
+	}
+	result, err = store.Check(ctx, "prod", "us-east-1", changes, ts.Add(time.Hour), schedules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 3 || result[0].Action != ApprovalAction {
+		t.Fatalf("expected a second stop in the same window to wait for approval again, got %v", result)
+	}
+
+	// the approval is dropped when the schedule starts the resource again
+	if n, err := store.Expire(ctx, newWeekday(time.Tuesday, 7, 55)); err != nil || n != 0 {
+		t.Errorf("expected nothing to expire before the next start, got %d %v", n, err)
+	}
+	if n, err := store.Expire(ctx, newWeekday(time.Tuesday, 8, 0)); err != nil || n != 1 {
+		t.Errorf("expected the approval to expire at the next start, got %d %v", n, err)
+	}
+	if _, err := store.Approve(ctx, id, "jane", newWeekday(time.Tuesday, 8, 5)); err == nil || !strings.Contains(err.Error(), "no pending approval") {
+		t.Errorf("expected an expired approval not to be found, got %v", err)
+	}
+}
+
+func TestApprovalStore_CheckWithoutStore(t *testing.T) {
+	changes := Changes{{ID: aws.String("reports"), Action: StopAction, tags: map[string]string{defaultTags.RequireApproval: "yes"}}}
+
+	// without a config table the stop is reported as failed rather than made
+	var store *ApprovalStore
+	result, err := store.Check(context.Background(), "prod", "us-east-1", changes, time.Now(), nil, nil)
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+	if len(result) != 1 || result[0].Action != ApprovalAction || !strings.Contains(result[0].Error, "CONFIG_TABLE") {
+		t.Errorf("expected the stop not to be made, got %v", result)
+	}
+}
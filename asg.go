@@ -6,23 +6,20 @@ import (
 	"strconv"
 	"time"
 
-	"log"
-
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 )
 
-const minSizeTag = "possum:min_size"
-
-func DoAutoScalingGroups(ctx context.Context, client autoscalingiface.AutoScalingAPI, ts time.Time, schedules Schedules) (Changes, error) {
-	groups, err := getAutoScalingGroups(ctx, client)
+func DoAutoScalingGroups(ctx context.Context, client autoscalingiface.AutoScalingAPI, ts time.Time, schedules Schedules, tags *TagKeys) (Changes, error) {
+	tags = tags.resolved()
+	groups, err := getAutoScalingGroups(ctx, client, tags)
 	if err != nil {
 		return nil, err
 	}
-	changes := getASGGroupChanges(groups, ts, schedules)
-	err = performASGChanges(client, changes)
-	return changes, err
+	changes := getASGGroupChanges(ctx, groups, ts, schedules, tags)
+	err = performASGChanges(client, changes, tags)
+	return changes, firstError(err, tagASGLastAction(ctx, client, changes, ts, tags), holdASGs(ctx, client, changes, tags))
 }
 
 type GroupSchedule struct {
@@ -30,13 +27,13 @@ type GroupSchedule struct {
 	schedule string
 }
 
-func getAutoScalingGroups(ctx context.Context, client autoscalingiface.AutoScalingAPI) ([]*GroupSchedule, error) {
+func getAutoScalingGroups(ctx context.Context, client autoscalingiface.AutoScalingAPI, tags *TagKeys) ([]*GroupSchedule, error) {
 
 	// first we need to get all autoscaling tag description that has the tag key in it, since DescribeAutoScalingGroups
 	// doesn't have a filter for tags
 	tagParams := &autoscaling.DescribeTagsInput{
 		Filters: []*autoscaling.Filter{
-			{Name: aws.String("key"), Values: []*string{aws.String(scheduleTag)}},
+			{Name: aws.String("key"), Values: []*string{aws.String(tags.Schedule)}},
 		},
 	}
 
@@ -55,7 +52,7 @@ func getAutoScalingGroups(ctx context.Context, client autoscalingiface.AutoScali
 		return nil, err
 	}
 
-	// no autoscaling group has been tagged with scheduleTag
+	// no autoscaling group has been tagged with a schedule
 	if len(groupNames) == 0 {
 		return nil, nil
 	}
@@ -80,12 +77,14 @@ func getAutoScalingGroups(ctx context.Context, client autoscalingiface.AutoScali
 	return result, err
 }
 
-func getASGGroupChanges(list []*GroupSchedule, ts time.Time, schedules Schedules) Changes {
+func getASGGroupChanges(ctx context.Context, list []*GroupSchedule, ts time.Time, schedules Schedules, tags *TagKeys) Changes {
 
 	var changes Changes
 
 	for _, a := range list {
 		group := a.resource
+		logger := LoggerFrom(ctx).With(LogResourceType, AutoScalingGroupResource, LogResourceID, *group.AutoScalingGroupName, LogSchedule, a.schedule)
+
 		// skip groups that are in a transitional state
 		if *group.DesiredCapacity != int64(len(group.Instances)) {
 			continue
@@ -96,14 +95,40 @@ func getASGGroupChanges(list []*GroupSchedule, ts time.Time, schedules Schedules
 			continue
 		}
 
-		effectiveSchedule := schedules.Find(a.schedule)
-		if effectiveSchedule == nil {
-			log.Printf("WARN could not find schedule %s for group %s", a.schedule, *getASGName(group))
+		// protected resources are never started or stopped
+		if tagEnabled(getASGTagValue(group.Tags, tags.Protected)) {
+			continue
+		}
+
+		// someone asked possum to leave this group alone for now
+		if isOverridden(getASGTagValue(group.Tags, tags.OverrideUntil), ts) {
+			continue
+		}
+
+		// the tag value is either the name of a schedule or a schedule expression
+		effectiveSchedule, err := schedules.Resolve(a.schedule)
+		if err != nil {
+			changes = append(changes, invalidSchedule(logger, *getASGName(group), group.AutoScalingGroupName, AutoScalingGroupResource, a.schedule, err, asgTagMap(group.Tags)))
 			continue
 		}
 
 		isRunning := len(group.Instances) != 0
 		act := effectiveSchedule.Action(ts, isRunning)
+		mode := resourceMode(logger, effectiveSchedule, tags.Mode, getASGTagValue(group.Tags, tags.Mode), *getASGName(group))
+		if !mode.Allows(act) {
+			act = NoopAction
+		}
+
+		var due time.Time
+		lastAction, lastActionAt := parseLastAction(getASGTagValue(group.Tags, tags.LastAction), getASGTagValue(group.Tags, tags.LastActionAt))
+		if until, ok := effectiveSchedule.ManualChange(ts, isRunning, lastAction, lastActionAt); ok && act != NoopAction {
+			act, due = HoldAction, until
+		} else if act == NoopAction && isRunning && mode.Allows(WarnAction) {
+			if stop, ok := effectiveSchedule.Warn(ts); ok {
+				act, due = WarnAction, stop
+			}
+		}
+
 		if act == NoopAction {
 			continue
 		}
@@ -112,34 +137,35 @@ func getASGGroupChanges(list []*GroupSchedule, ts time.Time, schedules Schedules
 			ID:             group.AutoScalingGroupName,
 			Name:           *getASGName(group),
 			Action:         act,
-			Type:           "asg",
-			minSize:        getASGTagInt64(group.Tags, minSizeTag, 1),
+			Type:           AutoScalingGroupResource,
+			Schedule:       effectiveSchedule.Name,
+			Mode:           mode,
+			Due:            due,
+			minSize:        getASGTagInt64(group.Tags, tags.MinSize, 1),
 			currentMinSize: *group.MinSize,
+			tags:           asgTagMap(group.Tags),
 		})
 	}
 	return changes
 }
 
-func performASGChanges(client autoscalingiface.AutoScalingAPI, changes []Change) error {
-	for _, change := range changes {
+func performASGChanges(client autoscalingiface.AutoScalingAPI, changes []Change, tags *TagKeys) error {
+	var firstErr error
+	for i, change := range changes {
+		var err error
 		switch change.Action {
 		case StartAction:
-			err := updateASGSize(client, change.ID, change.minSize)
-			if err != nil {
-				return err
-			}
+			err = updateASGSize(client, change.ID, change.minSize)
 		case StopAction:
-			err := updateASGSize(client, change.ID, 0)
-			if err != nil {
-				return err
-			}
-			// tag current min size so that the StartAction can reset the value to this value
-			if err := tagASGGroupSize(client, change.ID, change.currentMinSize); err != nil {
-				return err
+			err = updateASGSize(client, change.ID, 0)
+			if err == nil {
+				// tag current min size so that the StartAction can reset the value to this value
+				err = tagASGGroupSize(client, change.ID, tags.MinSize, change.currentMinSize)
 			}
 		}
+		firstErr = markFailed(changes, []int{i}, err, firstErr)
 	}
-	return nil
+	return firstErr
 }
 
 func updateASGSize(client autoscalingiface.AutoScalingAPI, name *string, minSize int64) error {
@@ -152,12 +178,12 @@ func updateASGSize(client autoscalingiface.AutoScalingAPI, name *string, minSize
 	return err
 }
 
-func tagASGGroupSize(client autoscalingiface.AutoScalingAPI, name *string, minSize int64) error {
+func tagASGGroupSize(client autoscalingiface.AutoScalingAPI, name *string, key string, minSize int64) error {
 	_, err := client.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
 		Tags: []*autoscaling.Tag{
 			{
 				ResourceId:        name,
-				Key:               aws.String(minSizeTag),
+				Key:               aws.String(key),
 				Value:             aws.String(fmt.Sprintf("%d", minSize)),
 				PropagateAtLaunch: aws.Bool(false),
 				ResourceType:      aws.String("auto-scaling-group"),
@@ -182,6 +208,15 @@ func getASGTagInt64(tags []*autoscaling.TagDescription, tagKey string, defaultVa
 
 }
 
+// asgTagMap returns the tags by key
+func asgTagMap(tags []*autoscaling.TagDescription) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		m[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return m
+}
+
 // helper to get a specific value out of autoscaling tag descriptions
 func getASGTagValue(tags []*autoscaling.TagDescription, keyName string) *string {
 	if tags == nil {
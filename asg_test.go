@@ -21,8 +21,8 @@ func TestGetAutoScalingGroups(t *testing.T) {
 		{AutoScalingGroupName: aws.String("group2")},
 	}
 	tagOutput := []*autoscaling.TagDescription{
-		{ResourceId: aws.String("group1"), Value: aws.String("OfficeHours"), Key: aws.String(scheduleTag)},
-		{ResourceId: aws.String("group2"), Value: aws.String("OfficeHours"), Key: aws.String(scheduleTag)},
+		{ResourceId: aws.String("group1"), Value: aws.String("OfficeHours"), Key: aws.String(defaultTags.Schedule)},
+		{ResourceId: aws.String("group2"), Value: aws.String("OfficeHours"), Key: aws.String(defaultTags.Schedule)},
 	}
 
 	client := &mockAutoscalingClient{
@@ -31,7 +31,7 @@ func TestGetAutoScalingGroups(t *testing.T) {
 	}
 	ctx := context.Background()
 
-	list, err := getAutoScalingGroups(ctx, client)
+	list, err := getAutoScalingGroups(ctx, client, defaultTags)
 
 	if err != nil {
 		t.Error(err)
@@ -123,7 +123,7 @@ func TestGetASGGroupChanges(t *testing.T) {
 		schedule.AddPeriod(time.Local.String(), test.period)
 		schedules := Schedules{schedule}
 
-		changes := getASGGroupChanges(list, chkTime, schedules)
+		changes := getASGGroupChanges(context.Background(), list, chkTime, schedules, defaultTags)
 
 		for _, change := range changes {
 			if change.Action != test.expected {
@@ -151,7 +151,7 @@ func TestPerformASGChanges(t *testing.T) {
 			{ID: aws.String("n"), Action: test.action, currentMinSize: test.currentlyRunning, minSize: test.expectedDesired},
 		}
 
-		err := performASGChanges(client, changes)
+		err := performASGChanges(client, changes, defaultTags)
 		if err != nil {
 			t.Error(err)
 		}
@@ -176,8 +176,8 @@ func TestPerformASGChanges(t *testing.T) {
 			}
 			tags := client.createOrUpdateTagsInput[0]
 			currentlyRunning := fmt.Sprintf("%d", test.currentlyRunning)
-			if *tags[0].Key != minSizeTag || *tags[0].Value != currentlyRunning {
-				t.Errorf("expected asg to be tagged with %s=%s, got %s=%s", minSizeTag, currentlyRunning, *tags[0].Key, *tags[0].Value)
+			if *tags[0].Key != defaultTags.MinSize || *tags[0].Value != currentlyRunning {
+				t.Errorf("expected asg to be tagged with %s=%s, got %s=%s", defaultTags.MinSize, currentlyRunning, *tags[0].Key, *tags[0].Value)
 			}
 
 		}
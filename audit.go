@@ -0,0 +1,281 @@
+package possum
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DefaultAuditTTLDays is how long audit records are kept for if the config doesn't say otherwise
+const DefaultAuditTTLDays = 90
+
+// AuditSuccess is the outcome of a change that was applied without an error
+const AuditSuccess = "success"
+
+// ScheduleActor is the actor of the changes that possum applies on schedule
+const ScheduleActor = "schedule"
+
+// DynamoDB accepts up to 25 items in a batch write
+const auditBatchSize = 25
+
+// audit records are sorted by this fixed width time format, so that the string order is the time order
+const auditTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// AuditConfig configures the audit log of the changes possum applies
+type AuditConfig struct {
+	Table   string // the dynamodb table to write to, possum doesn't keep an audit log if empty
+	TTLDays int    // how many days the records are kept for, defaults to DefaultAuditTTLDays
+}
+
+// AuditRecord is an applied change as it's stored in the audit table, the table is keyed by the ResourceKey and time
+type AuditRecord struct {
+	Time         time.Time
+	Account      string
+	Region       string
+	Type         string
+	ID           string
+	Name         string
+	Schedule     string
+	Action       ScheduledAction
+	Outcome      string // AuditSuccess, the error or the policy that rejected the change
+	Actor        string // what applied the change, e.g. "schedule" or "slack:jane"
+	InvocationID string // the lambda request ID
+}
+
+// Key returns the partition key of the record, so that the same resource ID in other accounts or regions has its own
+// history
+func (r *AuditRecord) Key() string {
+	return ResourceKey(r.Account, r.Region, r.Type, r.ID)
+}
+
+// NewAuditRecords returns a record for every start and stop in the report, a halted report has none
+func NewAuditRecords(report *Report, actor, invocationID string) []*AuditRecord {
+	if report.Halted != "" {
+		return nil
+	}
+	var records []*AuditRecord
+	for _, result := range report.Results {
+		for _, change := range result.Changes {
+			if change.Action != StartAction && change.Action != StopAction {
+				continue
+			}
+			records = append(records, NewAuditRecord(report.Time, result.Account, result.Region, change, actor, invocationID))
+		}
+	}
+	return records
+}
+
+// NewAuditRecord returns the record of a change that was applied at ts
+func NewAuditRecord(ts time.Time, account, region string, change Change, actor, invocationID string) *AuditRecord {
+	outcome := AuditSuccess
+	if change.Error != "" {
+		outcome = change.Error
+	}
+	if change.Rejected != "" {
+		outcome = "rejected by " + change.Rejected
+	}
+	return &AuditRecord{
+		Time:         ts,
+		Account:      account,
+		Region:       region,
+		Type:         change.Type,
+		ID:           aws.StringValue(change.ID),
+		Name:         change.Name,
+		Schedule:     change.Schedule,
+		Action:       change.Action,
+		Outcome:      outcome,
+		Actor:        actor,
+		InvocationID: invocationID,
+	}
+}
+
+// AuditLog writes and reads audit records, the table needs a "resource" partition key and a "time" sort key
+type AuditLog struct {
+	Client dynamodbiface.DynamoDBAPI
+	Table  string
+	TTL    time.Duration // records expire through the "expires" attribute this long after they were written
+}
+
+// NewAuditLog returns nil if the config doesn't name an audit table
+func NewAuditLog(config *AuditConfig, client dynamodbiface.DynamoDBAPI) *AuditLog {
+	if config == nil || config.Table == "" {
+		return nil
+	}
+	days := config.TTLDays
+	if days <= 0 {
+		days = DefaultAuditTTLDays
+	}
+	return &AuditLog{Client: client, Table: config.Table, TTL: time.Duration(days) * 24 * time.Hour}
+}
+
+// Write stores the records in batches, a nil AuditLog doesn't write anything
+func (a *AuditLog) Write(ctx context.Context, records []*AuditRecord) error {
+	if a == nil {
+		return nil
+	}
+
+	for start := 0; start < len(records); start += auditBatchSize {
+		end := start + auditBatchSize
+		if end > len(records) {
+			end = len(records)
+		}
+
+		var requests []*dynamodb.WriteRequest
+		for _, record := range records[start:end] {
+			requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: a.item(record)}})
+		}
+
+		// dynamodb returns the items it couldn't write when the table is throttled, so retry those a few times
+		pending := map[string][]*dynamodb.WriteRequest{a.Table: requests}
+		for i := 0; i < 3 && len(pending) > 0; i++ {
+			if i > 0 {
+				time.Sleep(time.Duration(i) * 100 * time.Millisecond)
+			}
+			out, err := a.Client.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return err
+			}
+			pending = out.UnprocessedItems
+		}
+		if len(pending[a.Table]) > 0 {
+			return fmt.Errorf("could not write %d audit records to %s", len(pending[a.Table]), a.Table)
+		}
+	}
+	return nil
+}
+
+// History returns the newest records of the resource with the key first, at most limit records if limit is more than
+// 0. The key is the ResourceKey of the resource.
+func (a *AuditLog) History(ctx context.Context, key string, limit int64) ([]*AuditRecord, error) {
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String(a.Table),
+		KeyConditionExpression:   aws.String("#resource = :resource"),
+		ExpressionAttributeNames: map[string]*string{"#resource": aws.String("resource")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":resource": {S: aws.String(key)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int64(limit)
+	}
+
+	var records []*AuditRecord
+	var parseErr error
+	err := a.Client.QueryPagesWithContext(ctx, input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			record, err := parseAuditItem(item)
+			if err != nil {
+				parseErr = err
+				return false
+			}
+			records = append(records, record)
+		}
+		return limit <= 0 || int64(len(records)) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, parseErr
+}
+
+// HistoryByID returns the newest records of the resources with the ID first, in every account and region, at most
+// limit records if limit is more than 0. The table is keyed by the resource key, so this scans the whole table.
+func (a *AuditLog) HistoryByID(ctx context.Context, id string, limit int64) ([]*AuditRecord, error) {
+	var records []*AuditRecord
+	var parseErr error
+	err := a.Client.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:                 aws.String(a.Table),
+		FilterExpression:          aws.String("#id = :id"),
+		ExpressionAttributeNames:  map[string]*string{"#id": aws.String("id")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":id": {S: aws.String(id)}},
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			record, err := parseAuditItem(item)
+			if err != nil {
+				parseErr = err
+				return false
+			}
+			records = append(records, record)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Time.After(records[j].Time)
+	})
+	if limit > 0 && int64(len(records)) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+func (a *AuditLog) item(record *AuditRecord) map[string]*dynamodb.AttributeValue {
+	str := func(s string) *dynamodb.AttributeValue {
+		// dynamodb doesn't accept empty strings in key attributes and it's pointless to store them elsewhere
+		if s == "" {
+			return &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+		}
+		return &dynamodb.AttributeValue{S: aws.String(s)}
+	}
+	item := map[string]*dynamodb.AttributeValue{
+		"resource":      str(record.Key()),
+		"id":            str(record.ID),
+		"time":          str(record.Time.UTC().Format(auditTimeFormat)),
+		"account":       str(record.Account),
+		"region":        str(record.Region),
+		"type":          str(record.Type),
+		"name":          str(record.Name),
+		"schedule":      str(record.Schedule),
+		"action":        str(record.Action.String()),
+		"outcome":       str(record.Outcome),
+		"actor":         str(record.Actor),
+		"invocation_id": str(record.InvocationID),
+	}
+	if a.TTL > 0 {
+		item["expires"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(record.Time.Add(a.TTL).Unix(), 10))}
+	}
+	return item
+}
+
+func parseAuditItem(item map[string]*dynamodb.AttributeValue) (*AuditRecord, error) {
+	str := func(name string) string {
+		if attr, ok := item[name]; ok && attr.S != nil {
+			return *attr.S
+		}
+		return ""
+	}
+	ts, err := time.Parse(auditTimeFormat, str("time"))
+	if err != nil {
+		return nil, err
+	}
+	var action ScheduledAction
+	if err := action.UnmarshalText([]byte(str("action"))); err != nil {
+		return nil, err
+	}
+	return &AuditRecord{
+		Time:         ts,
+		Account:      str("account"),
+		Region:       str("region"),
+		Type:         str("type"),
+		ID:           str("id"),
+		Name:         str("name"),
+		Schedule:     str("schedule"),
+		Action:       action,
+		Outcome:      str("outcome"),
+		Actor:        str("actor"),
+		InvocationID: str("invocation_id"),
+	}, nil
+}
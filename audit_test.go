@@ -0,0 +1,240 @@
+package possum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func TestNewAuditRecords(t *testing.T) {
+	ts := time.Date(2018, 5, 7, 12, 0, 0, 0, time.UTC)
+	report := &Report{Time: ts}
+	report.Add("staging", "ap-southeast-2", Changes{
+		{ID: aws.String("i-1"), Action: StopAction, Type: InstanceResource, Schedule: "OfficeHours"},
+		{ID: aws.String("i-2"), Action: WarnAction, Type: InstanceResource},
+		{ID: aws.String("db"), Action: StartAction, Type: DBInstanceResource, Error: "InvalidDBInstanceState"},
+		{ID: aws.String("i-3"), Action: StopAction, Type: InstanceResource, Rejected: "prod-window: outside the change window 'Sat 02:00-06:00'"},
+	})
+
+	records := NewAuditRecords(report, ScheduleActor, "req-1")
+	if len(records) != 3 {
+		t.Fatalf("expected warnings to be left out of the audit log, got %d records", len(records))
+	}
+
+	tests := []struct {
+		id, outcome string
+		action      ScheduledAction
+	}{
+		{"i-1", AuditSuccess, StopAction},
+		{"db", "InvalidDBInstanceState", StartAction},
+		{"i-3", "rejected by prod-window: outside the change window 'Sat 02:00-06:00'", StopAction},
+	}
+	for i, test := range tests {
+		r := records[i]
+		if r.ID != test.id || r.Outcome != test.outcome || r.Action != test.action {
+			t.Errorf("case %d. expected %s %s %s, got %s %s %s", i+1, test.id, test.action, test.outcome, r.ID, r.Action, r.Outcome)
+		}
+		if r.Account != "staging" || r.Region != "ap-southeast-2" || r.Actor != ScheduleActor || r.InvocationID != "req-1" || !r.Time.Equal(ts) {
+			t.Errorf("case %d. unexpected record %+v", i+1, r)
+		}
+	}
+}
+
+func TestNewAuditLog(t *testing.T) {
+	if NewAuditLog(nil, nil) != nil || NewAuditLog(&AuditConfig{}, nil) != nil {
+		t.Errorf("expected no audit log without a table")
+	}
+	if l := NewAuditLog(&AuditConfig{Table: "audit"}, nil); l.TTL != DefaultAuditTTLDays*24*time.Hour {
+		t.Errorf("expected the default TTL, got %s", l.TTL)
+	}
+	if l := NewAuditLog(&AuditConfig{Table: "audit", TTLDays: 7}, nil); l.TTL != 7*24*time.Hour {
+		t.Errorf("expected a 7 day TTL, got %s", l.TTL)
+	}
+
+	// a nil audit log is a no-op, so that callers don't have to check if auditing is enabled
+	var l *AuditLog
+	if err := l.Write(context.Background(), []*AuditRecord{{}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuditLogWriteAndHistory(t *testing.T) {
+	client := &mockDynamoDBClient{items: make(map[string][]map[string]*dynamodb.AttributeValue)}
+	auditLog := &AuditLog{Client: client, Table: "audit", TTL: 24 * time.Hour}
+
+	ts := time.Date(2018, 5, 7, 12, 0, 0, 0, time.UTC)
+	var records []*AuditRecord
+	for i := 0; i < 30; i++ {
+		records = append(records, &AuditRecord{
+			Time:    ts.Add(time.Duration(i) * time.Minute),
+			Account: "dev",
+			Region:  "ap-southeast-2",
+			ID:      fmt.Sprintf("i-%d", i%2),
+			Type:    InstanceResource,
+			Action:  StopAction,
+			Outcome: AuditSuccess,
+			Actor:   ScheduleActor,
+		})
+	}
+
+	if err := auditLog.Write(context.Background(), records); err != nil {
+		t.Fatal(err)
+	}
+	if client.batches != 2 {
+		t.Errorf("expected 30 records to be written in 2 batches, got %d", client.batches)
+	}
+
+	item := client.items["dev/ap-southeast-2/instance/i-0"][0]
+	if *item["expires"].N != fmt.Sprintf("%d", ts.Add(24*time.Hour).Unix()) {
+		t.Errorf("expected the record to expire a day after it was written, got %s", *item["expires"].N)
+	}
+	if item["schedule"].NULL == nil {
+		t.Errorf("expected an empty schedule to be stored as NULL")
+	}
+
+	history, err := auditLog.History(context.Background(), "dev/ap-southeast-2/instance/i-1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(history))
+	}
+	if !history[0].Time.Equal(ts.Add(29*time.Minute)) || history[0].Action != StopAction || history[0].ID != "i-1" {
+		t.Errorf("expected the newest record first, got %+v", history[0])
+	}
+}
+
+func TestAuditLogWrite_SameIDInAccounts(t *testing.T) {
+	client := &mockDynamoDBClient{items: make(map[string][]map[string]*dynamodb.AttributeValue)}
+	auditLog := &AuditLog{Client: client, Table: "audit"}
+
+	// the same ID in two accounts and two regions, stopped in the same run
+	ts := time.Date(2018, 5, 7, 12, 0, 0, 0, time.UTC)
+	var records []*AuditRecord
+	for _, location := range [][2]string{{"dev", "us-east-1"}, {"prod", "us-east-1"}, {"prod", "eu-west-1"}} {
+		records = append(records, &AuditRecord{Time: ts, Account: location[0], Region: location[1], Type: DBInstanceResource, ID: "reports", Action: StopAction, Outcome: AuditSuccess})
+	}
+	if err := auditLog.Write(context.Background(), records); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := auditLog.History(context.Background(), ResourceKey("prod", "us-east-1", DBInstanceResource, "reports"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Account != "prod" || history[0].Region != "us-east-1" || history[0].ID != "reports" {
+		t.Errorf("expected only the history of the resource in the account and region, got %v", history)
+	}
+}
+
+func TestAuditLogHistoryByID(t *testing.T) {
+	client := &mockDynamoDBClient{items: make(map[string][]map[string]*dynamodb.AttributeValue)}
+	auditLog := &AuditLog{Client: client, Table: "audit"}
+
+	ts := time.Date(2018, 5, 7, 12, 0, 0, 0, time.UTC)
+	var records []*AuditRecord
+	for i, location := range [][2]string{{"dev", "us-east-1"}, {"prod", "us-east-1"}, {"prod", "eu-west-1"}} {
+		records = append(records,
+			&AuditRecord{Time: ts.Add(time.Duration(i) * time.Minute), Account: location[0], Region: location[1], Type: DBInstanceResource, ID: "reports", Action: StopAction, Outcome: AuditSuccess},
+			&AuditRecord{Time: ts, Account: location[0], Region: location[1], Type: InstanceResource, ID: "i-1", Action: StopAction, Outcome: AuditSuccess},
+		)
+	}
+	if err := auditLog.Write(context.Background(), records); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := auditLog.HistoryByID(context.Background(), "reports", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(history))
+	}
+	if history[0].Account != "prod" || history[0].Region != "eu-west-1" || history[1].Region != "us-east-1" {
+		t.Errorf("expected the newest records of the ID in every account and region first, got %+v %+v", history[0], history[1])
+	}
+	for _, r := range history {
+		if r.ID != "reports" {
+			t.Errorf("expected only records of the ID, got %+v", r)
+		}
+	}
+}
+
+func TestAuditLogWriteUnprocessed(t *testing.T) {
+	client := &mockDynamoDBClient{items: make(map[string][]map[string]*dynamodb.AttributeValue), unprocessed: 1}
+	auditLog := &AuditLog{Client: client, Table: "audit"}
+
+	if err := auditLog.Write(context.Background(), []*AuditRecord{{ID: "i-1"}, {ID: "i-2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if client.batches != 2 {
+		t.Errorf("expected the unprocessed item to be retried, got %d batches", client.batches)
+	}
+
+	client.err = errors.New("ProvisionedThroughputExceededException")
+	if err := auditLog.Write(context.Background(), []*AuditRecord{{ID: "i-1"}}); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+type mockDynamoDBClient struct {
+	dynamodbiface.DynamoDBAPI
+	items       map[string][]map[string]*dynamodb.AttributeValue // keyed by the partition key
+	batches     int
+	unprocessed int // the number of items or keys to leave unprocessed in the first batch
+	err         error
+	config      map[string]map[string]*dynamodb.AttributeValue // the items of the config table, keyed by id
+	scans       int
+}
+
+func (m *mockDynamoDBClient) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, options ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	m.batches++
+	out := &dynamodb.BatchWriteItemOutput{}
+	for table, requests := range input.RequestItems {
+		if m.unprocessed > 0 {
+			out.UnprocessedItems = map[string][]*dynamodb.WriteRequest{table: requests[:m.unprocessed]}
+			requests = requests[m.unprocessed:]
+			m.unprocessed = 0
+		}
+		// dynamodb refuses a batch that writes the same key twice
+		keys := make(map[string]bool)
+		for _, r := range requests {
+			key := *r.PutRequest.Item["resource"].S + " " + *r.PutRequest.Item["time"].S
+			if keys[key] {
+				return nil, errors.New("ValidationException: Provided list of item keys contains duplicates")
+			}
+			keys[key] = true
+		}
+		for _, r := range requests {
+			key := *r.PutRequest.Item["resource"].S
+			m.items[key] = append(m.items[key], r.PutRequest.Item)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockDynamoDBClient) QueryPagesWithContext(ctx aws.Context, input *dynamodb.QueryInput, fnc func(*dynamodb.QueryOutput, bool) bool, options ...request.Option) error {
+	items := append([]map[string]*dynamodb.AttributeValue{}, m.items[*input.ExpressionAttributeValues[":resource"].S]...)
+	sort.Slice(items, func(i, j int) bool {
+		if aws.BoolValue(input.ScanIndexForward) {
+			return *items[i]["time"].S < *items[j]["time"].S
+		}
+		return *items[i]["time"].S > *items[j]["time"].S
+	})
+	if input.Limit != nil && int64(len(items)) > *input.Limit {
+		items = items[:*input.Limit]
+	}
+	fnc(&dynamodb.QueryOutput{Items: items}, true)
+	return nil
+}
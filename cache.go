@@ -0,0 +1,80 @@
+package possum
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// revisioner is a schedule store that can tell whether the schedules changed without reading them. The revision is
+// empty if the store can't tell, e.g. because nothing is stored yet.
+type revisioner interface {
+	Revision(ctx context.Context) (string, error)
+}
+
+// CachedScheduleStore keeps the schedules of another store in memory, so that a warm lambda doesn't read them on every
+// invocation. The schedules are used for the TTL without checking the store. After that, stores that can tell whether
+// the schedules changed are asked for their revision, and the schedules are only read again if it changed, other
+// stores are read again. The cached schedules are shared between callers and must not be changed.
+type CachedScheduleStore struct {
+	Store ScheduleStore
+	TTL   time.Duration
+
+	now func() time.Time // for tests
+
+	mu        sync.Mutex
+	loaded    bool
+	schedules Schedules
+	revision  string
+	checked   time.Time
+}
+
+func NewCachedScheduleStore(store ScheduleStore, ttl time.Duration) *CachedScheduleStore {
+	return &CachedScheduleStore{Store: store, TTL: ttl, now: time.Now}
+}
+
+func (c *CachedScheduleStore) GetSchedules(ctx context.Context) (Schedules, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.loaded && now.Sub(c.checked) < c.TTL {
+		return c.schedules, nil
+	}
+
+	// the revision is read before the schedules, so that a change in between is picked up by the next check
+	var revision string
+	if r, ok := c.Store.(revisioner); ok {
+		var err error
+		if revision, err = r.Revision(ctx); err != nil {
+			return nil, err
+		}
+		if c.loaded && revision != "" && revision == c.revision {
+			c.checked = now
+			return c.schedules, nil
+		}
+	}
+
+	schedules, err := c.Store.GetSchedules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.loaded = true
+	c.schedules = schedules
+	c.revision = revision
+	c.checked = now
+	return schedules, nil
+}
+
+// PutSchedules stores the schedules and reads them again the next time
+func (c *CachedScheduleStore) PutSchedules(ctx context.Context, schedules Schedules) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.loaded = false
+	return c.Store.PutSchedules(ctx, schedules)
+}
+
+func (c *CachedScheduleStore) String() string {
+	return c.Store.String()
+}
@@ -0,0 +1,100 @@
+package possum
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCachedScheduleStore(t *testing.T) {
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		after         time.Duration // since the start
+		change        bool          // change the schedules before the read
+		revisions     bool          // the store can tell if the schedules changed
+		reads         int           // the reads of the store so far
+		revisionReads int
+	}{
+		{0, false, true, 1, 1},
+		{time.Minute, false, true, 1, 1},       // within the TTL
+		{5 * time.Minute, false, true, 1, 2},   // checked, not changed
+		{6 * time.Minute, true, true, 1, 2},    // changed, but within the TTL of the last check
+		{10 * time.Minute, false, true, 2, 3},  // checked and changed
+		{20 * time.Minute, false, false, 3, 3}, // read again by stores without revisions
+	}
+
+	store := &countingScheduleStore{schedules: Schedules{NewSchedule("OfficeHours")}, revision: "1"}
+	cache := NewCachedScheduleStore(store, 5*time.Minute)
+	for i, test := range tests {
+		cache.now = func() time.Time { return start.Add(test.after) }
+		if test.change {
+			store.schedules = Schedules{NewSchedule("OfficeHours"), NewSchedule("AfterHours")}
+			store.revision = "2"
+		}
+		if !test.revisions {
+			cache.Store = &withoutRevisions{store}
+		}
+
+		schedules, err := cache.GetSchedules(context.Background())
+		if err != nil {
+			t.Fatalf("case %d. %s", i+1, err)
+		}
+		if store.reads != test.reads || store.revisionReads != test.revisionReads {
+			t.Errorf("case %d. expected %d reads and %d revision reads, got %d and %d", i+1, test.reads, test.revisionReads, store.reads, store.revisionReads)
+		}
+		if len(schedules) == 0 {
+			t.Errorf("case %d. expected schedules", i+1)
+		}
+	}
+
+	// a put is read back the next time
+	cache.Store = store
+	if err := cache.PutSchedules(context.Background(), Schedules{NewSchedule("Weekends")}); err != nil {
+		t.Fatal(err)
+	}
+	schedules, err := cache.GetSchedules(context.Background())
+	if err != nil || len(schedules) != 1 || schedules[0].Name != "Weekends" {
+		t.Errorf("expected the put schedules, got %v, %v", schedules, err)
+	}
+
+	// errors aren't cached
+	store.err = errors.New("throttled")
+	cache.now = func() time.Time { return start.Add(time.Hour) }
+	if _, err := cache.GetSchedules(context.Background()); err == nil {
+		t.Errorf("expected the error of the store")
+	}
+}
+
+type countingScheduleStore struct {
+	schedules     Schedules
+	revision      string
+	err           error
+	reads         int
+	revisionReads int
+}
+
+func (s *countingScheduleStore) GetSchedules(ctx context.Context) (Schedules, error) {
+	s.reads++
+	return s.schedules, s.err
+}
+
+func (s *countingScheduleStore) PutSchedules(ctx context.Context, schedules Schedules) error {
+	s.schedules = schedules
+	s.revision += "+"
+	return nil
+}
+
+func (s *countingScheduleStore) Revision(ctx context.Context) (string, error) {
+	s.revisionReads++
+	return s.revision, s.err
+}
+
+func (s *countingScheduleStore) String() string {
+	return "counting://"
+}
+
+// withoutRevisions hides the Revision method of the store
+type withoutRevisions struct {
+	ScheduleStore
+}
@@ -1,12 +1,30 @@
 package possum
 
+import (
+	"time"
+)
+
+// The resource types that possum can schedule
+const (
+	InstanceResource         = "instance"
+	AutoScalingGroupResource = "asg"
+	DBInstanceResource       = "rds"
+)
+
 type Change struct {
 	ID             *string         // AWS unique identifier
 	Name           string          // human readable identifier
 	Action         ScheduledAction // start or stop action
 	Type           string
-	minSize        int64 // some resources have a number of resources
-	currentMinSize int64 // some resources have a number of resources
+	Schedule       string            // name of the schedule that triggered the change
+	Mode           Mode              // the mode of the schedule or the resource
+	Due            time.Time         // when the stop that a warning is about will happen, or when a hold ends
+	Error          string            // why the change could not be applied, empty if it was
+	Rejected       string            // the policy that rejected the change and why, empty if none did
+	tags           map[string]string // the tags of the resource, policies apply to resources by tag
+	minSize        int64             // some resources have a number of resources
+	currentMinSize int64             // some resources have a number of resources
+	arn            string            // rds resources are tagged by ARN
 }
 
 type Changes []Change
@@ -14,3 +32,45 @@ type Changes []Change
 func (c Changes) Append(o Changes) Changes {
 	return append(c, o...)
 }
+
+// Notified returns the changes without the noops of resources with invalid schedules, they're logged every time the
+// changes are planned and would otherwise be notified on every run as well
+func (c Changes) Notified() Changes {
+	var notified Changes
+	for _, change := range c {
+		if change.Action != NoopAction {
+			notified = append(notified, change)
+		}
+	}
+	return notified
+}
+
+// invalidSchedule logs that the schedule of a resource can't be resolved and returns a noop with the error, so that
+// possum-cli plan shows why the resource is left alone
+func invalidSchedule(logger *Logger, name string, id *string, resourceType, schedule string, err error, tags map[string]string) Change {
+	logger.Warnf("%s, leaving '%s' alone", err, name)
+	return Change{Name: name, ID: id, Action: NoopAction, Type: resourceType, Schedule: schedule, Error: err.Error(), tags: tags}
+}
+
+// markFailed records err on the changes at the indexes, and returns the first error of a perform call
+func markFailed(list Changes, indexes []int, err, firstErr error) error {
+	if err == nil {
+		return firstErr
+	}
+	for _, i := range indexes {
+		list[i].Error = err.Error()
+	}
+	if firstErr == nil {
+		return err
+	}
+	return firstErr
+}
+
+// changeIDs returns the IDs of the changes at the indexes
+func changeIDs(list Changes, indexes []int) []*string {
+	var ids []*string
+	for _, i := range indexes {
+		ids = append(ids, list[i].ID)
+	}
+	return ids
+}
@@ -1 +1,44 @@
 package possum
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestMarkFailed(t *testing.T) {
+	list := Changes{
+		{ID: aws.String("i-1")},
+		{ID: aws.String("i-2")},
+		{ID: aws.String("i-3")},
+	}
+
+	first := errors.New("first")
+	err := markFailed(list, []int{0, 2}, first, nil)
+	err = markFailed(list, []int{1}, nil, err)
+	err = markFailed(list, []int{1}, errors.New("second"), err)
+
+	if err != first {
+		t.Errorf("expected the first error to be returned, got %v", err)
+	}
+	expected := []string{"first", "second", "first"}
+	for i, e := range expected {
+		if list[i].Error != e {
+			t.Errorf("case %d. expected error '%s', got '%s'", i+1, e, list[i].Error)
+		}
+	}
+}
+
+func TestChanges_Notified(t *testing.T) {
+	list := Changes{
+		{ID: aws.String("i-1"), Action: StopAction},
+		{ID: aws.String("i-2"), Action: NoopAction, Error: "could not find schedule 'x'"},
+		{ID: aws.String("i-3"), Action: StartAction, Error: "InsufficientInstanceCapacity"},
+	}
+
+	notified := list.Notified()
+	if len(notified) != 2 || *notified[0].ID != "i-1" || *notified[1].ID != "i-3" {
+		t.Errorf("expected the noop to be left out, got %v", notified)
+	}
+}
@@ -0,0 +1,27 @@
+package possum
+
+import (
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+)
+
+// Clients holds the AWS service clients for the resources possum schedules in a single account and region
+type Clients struct {
+	EC2         ec2iface.EC2API
+	AutoScaling autoscalingiface.AutoScalingAPI
+	RDS         rdsiface.RDSAPI
+}
+
+// NewClients creates the service clients, the provider is usually a session for an account and region
+func NewClients(p client.ConfigProvider) *Clients {
+	return &Clients{
+		EC2:         ec2.New(p),
+		AutoScaling: autoscaling.New(p),
+		RDS:         rds.New(p),
+	}
+}
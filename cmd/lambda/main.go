@@ -8,164 +8,281 @@ import (
 	"sync"
 	"time"
 
-	"strings"
-
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/rds"
-	"github.com/slack-go/slack"
 	"github.com/silverstripeltd/possum"
 )
 
+// scheduleStore is kept between invocations, so that a warm lambda can use the schedules it read before
+var scheduleStore *possum.CachedScheduleStore
+
 func main() {
 	lambda.Start(Handler)
 }
 
 // @todo handle env variables with KMS
-// @todo tag resources with possum last action time stamp
-// @todo fetch period information from aws dynamodb / s3 bucket (dynamo with 1/1 capacity is around $0.67 / month
 func Handler(ctx context.Context, evt events.CloudWatchEvent) (interface{}, error) {
 
-	notifier := &Slack{
-		channelID: os.Getenv("SLACK_CHANNEL"),
-		token:     os.Getenv("SLACK_TOKEN"),
+	// every log entry of the invocation carries its id, and the account and region it's about
+	var invocationID string
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		invocationID = lc.AwsRequestID
 	}
+	logger := possum.LoggerFrom(ctx).With(possum.LogInvocationID, invocationID)
+	ctx = possum.WithLogger(ctx, logger)
 
+	// the config table is needed even when the schedules are stored somewhere else, it keeps the approvals of the stops
+	// of resources with the require approval tag
 	tableName := os.Getenv("CONFIG_TABLE")
 	if tableName == "" {
 		return nil, errors.New("env variable CONFIG_TABLE is empty, this should be the name of dynamodb table, see docs")
 	}
 
-	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("ap-southeast-2")}))
-	client := dynamodb.New(sess)
-	schedules, err := possum.GetSchedules(client, tableName)
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(possum.HomeRegion())}))
+	store, err := cachedScheduleStore(sess)
+	if err != nil {
+		return nil, err
+	}
+	schedules, err := store.GetSchedules(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	if len(schedules) == 0 {
-		return nil, fmt.Errorf("did not find any schedules in storage '%s'", tableName)
+		return nil, fmt.Errorf("did not find any schedules in storage '%s'", store)
+	}
+
+	client := dynamodb.New(sess)
+	config, err := possum.GetConfig(client, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	config.ApplyEnv(ctx)
+
+	// the SLACK_CHANNEL and SLACK_TOKEN env variables are still supported for backwards compatibility
+	if channel := os.Getenv("SLACK_CHANNEL"); channel != "" {
+		config.Notifiers = append(config.Notifiers, &possum.NotifierConfig{Type: possum.SlackNotifier, Channel: channel})
+	}
+
+	notifier, err := possum.NewNotifiers(ctx, config.Notifiers, sess)
+	if err != nil {
+		return nil, err
 	}
 
-	regions, err := getRegions(ctx)
+	accounts, err := possum.GetAccounts(ctx, sess, config, evt.AccountID)
 	if err != nil {
 		return nil, err
 	}
 
+	// stops that need approval wait in the config table, the ones that weren't approved in time are dropped
+	approvals := possum.NewApprovalStore(client, tableName)
+	if _, err := approvals.Expire(ctx, evt.Time); err != nil {
+		logger.Errorf("expiring approvals: %s", err)
+	}
+
 	var errs []error
-	regionalChanges := make(map[string]possum.Changes)
+	var plans []*regionPlan
+	report := &possum.Report{Time: evt.Time}
 
 	var x sync.Mutex
 	var wg sync.WaitGroup
-	wg.Add(len(regions))
-	for _, region := range regions {
-		go func(r *string) {
+	wg.Add(len(accounts))
+	for _, account := range accounts {
+		go func(a *possum.Account) {
 			defer wg.Done()
-			changes, err := perRegion(r, ctx, evt, schedules)
-			if err != nil {
-				errs = append(errs, err)
-			}
-			if len(changes) > 0 {
-				x.Lock()
-				regionalChanges[*r] = changes
-				x.Unlock()
+			accountPlans, accountErrs := perAccount(ctx, sess, a, evt, schedules, config, approvals)
+			x.Lock()
+			defer x.Unlock()
+			for _, err := range accountErrs {
+				var assumeErr *assumeRoleError
+				if errors.As(err, &assumeErr) {
+					report.Unreachable = append(report.Unreachable, assumeErr.Error())
+				}
 			}
-		}(region)
-		// wait a bit before next region so that we do not so easily get into rate limiting
-		time.Sleep(time.Millisecond * 500)
+			errs = append(errs, accountErrs...)
+			plans = append(plans, accountPlans...)
+		}(account)
 	}
 	wg.Wait()
 
+	// every region is planned before anything changes, so that a bad schedule can't stop everything at once
+	if err := checkLimit(config.Limit, plans); err != nil {
+		report.Halted = err.Error()
+		logger.Errorf("%s, possum didn't make any changes", err)
+	} else {
+		errs = append(errs, apply(ctx, plans, evt, config, approvals)...)
+	}
+	for _, p := range plans {
+		report.Add(p.account.String(), p.region, p.changes.Append(p.rejected).Notified())
+	}
+
 	var outputErr error
 	if len(errs) > 0 {
-		fmt.Printf("%d errors, first error was: %s", len(errs), errs[len(errs)-1])
+		logger.Errorf("%d errors, first error was: %s", len(errs), errs[len(errs)-1])
 	}
 
-	var notification string
-	for region, changes := range regionalChanges {
-		if len(changes) != 0 {
-			str := format(changes)
-			notification += fmt.Sprintf("*%s*\n%s\n", region, str)
-		}
+	report.Sort()
+
+	// a failing audit log shouldn't stop the notifications about the changes that were made
+	auditLog := possum.NewAuditLog(config.Audit, client)
+	if err := auditLog.Write(ctx, possum.NewAuditRecords(report, possum.ScheduleActor, invocationID)); err != nil {
+		logger.Errorf("writing audit log: %s", err)
+		outputErr = err
 	}
 
-	if notification != "" {
-		if err := notifier.Notify(notification); err != nil {
-			return regionalChanges, err
+	if !report.Empty() {
+		if err := notifier.Notify(ctx, report); err != nil {
+			return report, err
 		}
 	}
 
-	return regionalChanges, outputErr
+	return report, outputErr
 }
 
-func perRegion(region *string, ctx context.Context, evt events.CloudWatchEvent, schedules possum.Schedules) (possum.Changes, error) {
-
-	sess := session.Must(session.NewSession(&aws.Config{Region: region}))
-
-	changes, err := possum.DoInstances(ctx, ec2.New(sess), evt.Time, schedules)
-	if err != nil {
-		return changes, err
+// cachedScheduleStore returns the store in the env variables, the schedules are used for SCHEDULE_CACHE_TTL, e.g. 10m,
+// before checking whether they changed
+func cachedScheduleStore(sess *session.Session) (*possum.CachedScheduleStore, error) {
+	if scheduleStore != nil {
+		return scheduleStore, nil
 	}
 
-	asg, err := possum.DoAutoScalingGroups(ctx, autoscaling.New(sess), evt.Time, schedules)
-	changes = changes.Append(asg)
+	store, err := possum.ScheduleStoreFromEnv(sess)
 	if err != nil {
-		return changes, err
+		return nil, err
 	}
-
-	db, err := possum.DoDB(ctx, rds.New(sess), evt.Time, schedules)
-	changes = changes.Append(db)
-	if err != nil {
-		return changes, err
+	var ttl time.Duration
+	if s := os.Getenv("SCHEDULE_CACHE_TTL"); s != "" {
+		if ttl, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid SCHEDULE_CACHE_TTL: %w", err)
+		}
 	}
+	scheduleStore = possum.NewCachedScheduleStore(store, ttl)
+	return scheduleStore, nil
+}
 
-	return changes, nil
+// regionPlan is what possum is about to do in a region of an account
+type regionPlan struct {
+	account   *possum.Account
+	region    string
+	clients   *possum.Clients
+	changes   possum.Changes
+	rejected  possum.Changes // the changes that the policies rejected, they're reported but not made
+	resources int            // the number of scheduled resources in the region
 }
 
-func getRegions(ctx context.Context) ([]*string, error) {
+// perAccount plans the changes in every configured region of the account, checks them against the policies and holds
+// the stops that need approval
+func perAccount(ctx context.Context, sess *session.Session, account *possum.Account, evt events.CloudWatchEvent, schedules possum.Schedules, config *possum.Config, approvals *possum.ApprovalStore) ([]*regionPlan, []error) {
 
-	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("ap-southeast-2")}))
-	client := ec2.New(sess)
+	sess = possum.AccountSession(sess, account)
+	ctx = possum.WithLogger(ctx, possum.LoggerFrom(ctx).With(possum.LogAccount, account.String()))
 
-	res, err := client.DescribeRegionsWithContext(ctx, &ec2.DescribeRegionsInput{})
-	if err != nil {
-		return nil, err
+	// assume the role up front so that an account we can't access is reported once instead of once per region
+	if _, err := sess.Config.Credentials.GetWithContext(ctx); err != nil {
+		return nil, []error{&assumeRoleError{account: account, err: err}}
 	}
-	var regions []*string
-	for _, reg := range res.Regions {
-		regions = append(regions, reg.RegionName)
+
+	regions, err := possum.GetRegions(ctx, sess, config)
+	if err != nil {
+		return nil, []error{fmt.Errorf("account %s: %w", account, err)}
 	}
-	return regions, err
-}
 
-func format(s possum.Changes) string {
-	var str strings.Builder
+	var errs []error
+	var plans []*regionPlan
 
-	for _, a := range s {
-		str.WriteString(fmt.Sprintf(" • %s `%s` (%s, %s)\n", a.Action, a.Name, a.Type, *a.ID))
+	var x sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(len(regions))
+	for _, region := range regions {
+		go func(r *string) {
+			defer wg.Done()
+			ctx := regionContext(ctx, account, *r)
+			clients := possum.NewClients(sess.Copy(&aws.Config{Region: r}))
+			// the changes that were planned before an error are still made
+			changes, resources, err := possum.PlanResources(ctx, clients, evt.Time, schedules, config.Tags)
+			changes, rejected := config.Policies.Check(changes, evt.Time, schedules)
+			for _, change := range rejected {
+				possum.LoggerFrom(ctx).WithChange(change).Warnf("%s '%s' rejected by %s", change.Action, change.Name, change.Rejected)
+			}
+			changes, approvalErr := approvals.Check(ctx, account.String(), *r, changes, evt.Time, schedules, config.Tags)
+			x.Lock()
+			defer x.Unlock()
+			for _, err := range []error{err, approvalErr} {
+				if err != nil {
+					errs = append(errs, fmt.Errorf("account %s, region %s: %w", account, *r, err))
+				}
+			}
+			// regions without changes count towards the scheduled resources of the change limit
+			plans = append(plans, &regionPlan{account: account, region: *r, clients: clients, changes: changes, rejected: rejected, resources: resources})
+		}(region)
+		// wait a bit before next region so that we do not so easily get into rate limiting
+		time.Sleep(time.Millisecond * 500)
 	}
+	wg.Wait()
 
-	return str.String()
+	return plans, errs
 }
 
-type Slack struct {
-	token     string
-	channelID string
+// checkLimit checks the planned changes of every region against the limit, out of the scheduled resources in all
+// regions
+func checkLimit(limit *possum.ChangeLimit, plans []*regionPlan) error {
+	var planned possum.Changes
+	var resources int
+	for _, p := range plans {
+		planned = planned.Append(p.changes)
+		resources += p.resources
+	}
+	return limit.Check(planned, resources)
 }
 
-func (s *Slack) Notify(message string) error {
-
-	if s.token == "" || s.channelID == "" {
-		return errors.New("can't send slack notification, missing either SLACK_TOKEN or SLACK_CHANNEL")
+// apply makes the planned changes in every region at the same time
+func apply(ctx context.Context, plans []*regionPlan, evt events.CloudWatchEvent, config *possum.Config, approvals *possum.ApprovalStore) []error {
+	var errs []error
+	var x sync.Mutex
+	var wg sync.WaitGroup
+	for _, plan := range plans {
+		if len(plan.changes) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(p *regionPlan) {
+			defer wg.Done()
+			// an approval is used up by the stop it approved, the changes that failed keep theirs
+			err := possum.Apply(regionContext(ctx, p.account, p.region), p.clients, p.changes, evt.Time, config.Tags)
+			if consumeErr := approvals.Consume(ctx, p.account.String(), p.region, p.changes, config.Tags); err == nil {
+				err = consumeErr
+			}
+			if err != nil {
+				x.Lock()
+				defer x.Unlock()
+				errs = append(errs, fmt.Errorf("account %s, region %s: %w", p.account, p.region, err))
+			}
+		}(plan)
 	}
+	wg.Wait()
+	return errs
+}
 
-	api := slack.New(s.token)
+// regionContext returns a context that logs with the account and region
+func regionContext(ctx context.Context, account *possum.Account, region string) context.Context {
+	return possum.WithLogger(ctx, possum.LoggerFrom(ctx).With(possum.LogAccount, account.String(), possum.LogRegion, region))
+}
+
+// assumeRoleError is returned when possum can't assume the role of an account
+type assumeRoleError struct {
+	account *possum.Account
+	err     error
+}
+
+func (e *assumeRoleError) Error() string {
+	return fmt.Sprintf("%s: could not assume role %s: %s", e.account, e.account.RoleARN, e.err)
+}
 
-	_, _, err := api.PostMessage(s.channelID, slack.MsgOptionText(message, false), slack.MsgOptionAsUser(true))
-	return err
+func (e *assumeRoleError) Unwrap() error {
+	return e.err
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/silverstripeltd/possum"
+)
+
+func stops(n int) possum.Changes {
+	var changes possum.Changes
+	for i := 0; i < n; i++ {
+		changes = append(changes, possum.Change{ID: aws.String(fmt.Sprintf("i-%d", i)), Action: possum.StopAction})
+	}
+	return changes
+}
+
+func TestCheckLimit(t *testing.T) {
+	account := &possum.Account{Name: "dev"}
+	tests := []struct {
+		plans []*regionPlan
+		limit *possum.ChangeLimit
+		err   bool
+	}{
+		// the idle resources of a region without changes count towards the percentage
+		{
+			plans: []*regionPlan{
+				{account: account, region: "us-east-1", resources: 100},
+				{account: account, region: "ap-southeast-2", changes: stops(10), resources: 10},
+			},
+			limit: &possum.ChangeLimit{MaxPercent: 10},
+		},
+		{
+			plans: []*regionPlan{
+				{account: account, region: "us-east-1", resources: 100},
+				{account: account, region: "ap-southeast-2", changes: stops(13), resources: 13},
+			},
+			limit: &possum.ChangeLimit{MaxPercent: 10},
+			err:   true,
+		},
+		{
+			plans: []*regionPlan{
+				{account: account, region: "us-east-1", changes: stops(3), resources: 3},
+				{account: account, region: "ap-southeast-2", changes: stops(3), resources: 3},
+			},
+			limit: &possum.ChangeLimit{Max: 5},
+			err:   true,
+		},
+		{
+			plans: []*regionPlan{
+				{account: account, region: "us-east-1", changes: stops(3), resources: 3},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		if err := checkLimit(test.limit, test.plans); (err != nil) != test.err {
+			t.Errorf("case %d. expected an error %t, got %v", i+1, test.err, err)
+		}
+	}
+}
@@ -1,18 +1,47 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
-	"encoding/json"
-
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/silverstripeltd/possum"
 )
 
+const usage = `usage: possum-cli <command> [arguments]
+
+commands:
+  get [-yaml]                             print the stored schedules, as JSON or YAML
+  put <file>                              store the schedules in a JSON or YAML file, after validating them
+  validate <file>                         check the schedules in a JSON or YAML file against the schema
+  schema                                  print the JSON Schema of the schedules
+  delete [-version n] <name>              delete a schedule from the config table, at the version if one is given
+  migrate                                 move the schedules in the config table into an item per schedule
+  versions                                print the versions of the schedules in the config table
+  diff <a> <b>                            print what changed in the schedules between two versions
+  rollback <n>                            store the schedules of a version again
+  snooze [-region r] <type> <id> <duration>  leave a resource alone for a while, type is one of instance, asg or rds
+  history [-limit n] <resource>           print the audit log of a resource, newest first, by <account>/<region>/<type>/<id> or by ID
+  plan [-region r] [-at time]             print what possum would do now, or at a RFC3339 time, without doing it
+  approvals                               print the stops that wait for approval, and the approved ones
+  approve <id>                            approve a stop, possum makes it in its next run
+
+The schedules are read from the SCHEDULE_STORE env variable, e.g. file:///path/schedules.json, or else from the
+config table in the CONFIG_TABLE and CONFIG_REGION env variables. The audit table is read from AUDIT_TABLE or the
+stored config.
+`
+
 func main() {
 	err := _main()
 	if err != nil {
@@ -22,35 +51,494 @@ func main() {
 }
 
 func _main() error {
-	schedule := possum.NewSchedule("OfficeHours")
-	officeHourPeriod, err := possum.NewPeriod("8:00", "19:00", []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday})
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		return errors.New("missing command")
+	}
+
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(possum.HomeRegion())}))
+
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "get":
+		return getSchedules(sess, args)
+	case "put":
+		return putSchedules(sess, args)
+	case "validate":
+		return validate(args)
+	case "schema":
+		return schema()
+	case "delete":
+		return deleteSchedule(sess, args)
+	case "migrate":
+		return migrate(sess)
+	case "versions":
+		return versions(sess)
+	case "diff":
+		return diff(sess, args)
+	case "rollback":
+		return rollback(sess, args)
+	case "snooze":
+		return snooze(sess, args)
+	case "history":
+		return history(sess, args)
+	case "plan":
+		return plan(sess, args)
+	case "approvals":
+		return approvals(sess)
+	case "approve":
+		return approve(sess, args)
+	}
+	fmt.Print(usage)
+	return fmt.Errorf("unknown command '%s'", os.Args[1])
+}
+
+func getSchedules(sess *session.Session, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	asYAML := flags.Bool("yaml", false, "print the schedules as YAML instead of JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := possum.ScheduleStoreFromEnv(sess)
+	if err != nil {
+		return err
+	}
+
+	out, err := store.GetSchedules(context.Background())
+	if err != nil {
+		return err
+	}
+	return printSchedules(out, *asYAML)
+}
+
+func printSchedules(schedules possum.Schedules, asYAML bool) error {
+	if asYAML {
+		b, err := possum.MarshalSchedulesYAML(schedules)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(b))
+		return nil
+	}
+
+	b, err := json.MarshalIndent(schedules, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n", b)
+	return nil
+}
+
+func putSchedules(sess *session.Session, args []string) error {
+	if len(args) != 1 {
+		return errors.New("put expects the path to a schedules file")
+	}
+
+	store, err := scheduleStore(sess)
 	if err != nil {
 		return err
 	}
-	if err = schedule.AddPeriod("Pacific/Auckland", officeHourPeriod); err != nil {
+
+	b, err := os.ReadFile(args[0])
+	if err != nil {
 		return err
 	}
-	schedules := possum.Schedules{schedule}
+	if err := possum.ValidateSchedules(b); err != nil {
+		return fmt.Errorf("%s is invalid:\n%w", args[0], err)
+	}
 
-	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("ap-southeast-2")}))
-	client := dynamodb.New(sess)
-	//tableName := aws.String(os.Getenv("CONFIG_TABLE"))
-	tableName := "lambda-possum-prod-ConfigTable-1S735A37BBT16"
+	schedules, err := possum.UnmarshalSchedules(b)
+	if err != nil {
+		return err
+	}
 
-	if err := possum.PutSchedules(client, tableName, schedules); err != nil {
+	if err := store.PutSchedules(context.Background(), schedules); err != nil {
 		return err
 	}
 
-	out, err := possum.GetSchedules(client, tableName)
+	stored, err := store.GetSchedules(context.Background())
 	if err != nil {
 		return err
 	}
+	return printSchedules(stored, possum.IsYAMLPath(args[0]))
+}
 
-	b, err := json.MarshalIndent(out, "", "\t")
+func validate(args []string) error {
+	if len(args) != 1 {
+		return errors.New("validate expects the path to a schedules file")
+	}
+	b, err := os.ReadFile(args[0])
 	if err != nil {
 		return err
 	}
+	if err := possum.ValidateSchedules(b); err != nil {
+		return fmt.Errorf("%s is invalid:\n%w", args[0], err)
+	}
+	fmt.Printf("%s is valid\n", args[0])
+	return nil
+}
 
+func schema() error {
+	b, err := json.MarshalIndent(possum.SchedulesSchema(), "", "\t")
+	if err != nil {
+		return err
+	}
 	fmt.Printf("%s\n", b)
 	return nil
 }
+
+func deleteSchedule(sess *session.Session, args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	version := flags.Int("version", 0, "the version of the schedule as it was read, defaults to the stored version")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("delete expects the name of a schedule")
+	}
+
+	store, err := dynamoDBStore(sess)
+	if err != nil {
+		return err
+	}
+
+	if *version == 0 {
+		schedules, err := store.GetSchedules(context.Background())
+		if err != nil {
+			return err
+		}
+		if schedule := schedules.Find(flags.Arg(0)); schedule != nil {
+			*version = schedule.Version
+		}
+	}
+
+	if err := store.DeleteSchedule(context.Background(), flags.Arg(0), *version); err != nil {
+		return err
+	}
+	fmt.Printf("deleted schedule '%s'\n", flags.Arg(0))
+	return nil
+}
+
+func migrate(sess *session.Session) error {
+	store, err := dynamoDBStore(sess)
+	if err != nil {
+		return err
+	}
+	n, err := store.Migrate(context.Background())
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		fmt.Println("the schedules are already in their own items")
+		return nil
+	}
+	fmt.Printf("moved %d schedules into their own items\n", n)
+	return nil
+}
+
+func versions(sess *session.Session) error {
+	store, err := dynamoDBStore(sess)
+	if err != nil {
+		return err
+	}
+	versions, err := store.Versions(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		fmt.Println("no versions of the schedules yet, they're recorded when the schedules are changed")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tTIME\tAUTHOR")
+	for _, v := range versions {
+		author := v.Author
+		if author == "" {
+			author = "-"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", v.Number, v.Time.Local().Format(time.RFC1123), author)
+	}
+	return w.Flush()
+}
+
+func diff(sess *session.Session, args []string) error {
+	if len(args) != 2 {
+		return errors.New("diff expects two version numbers, e.g. diff 3 4")
+	}
+	a, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+
+	store, err := dynamoDBStore(sess)
+	if err != nil {
+		return err
+	}
+	from, err := store.Version(context.Background(), a)
+	if err != nil {
+		return err
+	}
+	to, err := store.Version(context.Background(), b)
+	if err != nil {
+		return err
+	}
+
+	out, err := possum.DiffSchedules(from.Schedules, to.Schedules)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		fmt.Printf("the schedules are the same in version %d and %d\n", a, b)
+		return nil
+	}
+	fmt.Print(out)
+	return nil
+}
+
+func rollback(sess *session.Session, args []string) error {
+	if len(args) != 1 {
+		return errors.New("rollback expects a version number")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+
+	store, err := dynamoDBStore(sess)
+	if err != nil {
+		return err
+	}
+	if err := store.Rollback(context.Background(), n); err != nil {
+		return err
+	}
+	fmt.Printf("rolled the schedules back to version %d\n", n)
+	return nil
+}
+
+// scheduleStore returns the schedule store, with the caller as the author of the changes to schedules in DynamoDB
+func scheduleStore(sess *session.Session) (possum.ScheduleStore, error) {
+	store, err := possum.ScheduleStoreFromEnv(sess)
+	if err != nil {
+		return nil, err
+	}
+	if dynamoDB, ok := store.(*possum.DynamoDBScheduleStore); ok {
+		dynamoDB.Author = author(sess)
+	}
+	return store, nil
+}
+
+// author returns the ARN of the caller, or the local user if the caller can't be found
+func author(sess *session.Session) string {
+	out, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err == nil && out.Arn != nil {
+		return *out.Arn
+	}
+	return os.Getenv("USER")
+}
+
+// dynamoDBStore returns the schedule store if the schedules are stored in DynamoDB
+func dynamoDBStore(sess *session.Session) (*possum.DynamoDBScheduleStore, error) {
+	store, err := scheduleStore(sess)
+	if err != nil {
+		return nil, err
+	}
+	dynamoDB, ok := store.(*possum.DynamoDBScheduleStore)
+	if !ok {
+		return nil, fmt.Errorf("the schedules are stored in %s, only schedules in DynamoDB have versions and can be deleted or migrated", store)
+	}
+	return dynamoDB, nil
+}
+
+func snooze(sess *session.Session, args []string) error {
+	flags := flag.NewFlagSet("snooze", flag.ContinueOnError)
+	region := flags.String("region", *sess.Config.Region, "the region of the resource")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 3 {
+		return errors.New("snooze expects a resource type, a resource id and a duration, e.g. instance i-0123456789 2h")
+	}
+
+	duration, err := time.ParseDuration(flags.Arg(2))
+	if err != nil {
+		return err
+	}
+
+	config, err := loadConfig(sess)
+	if err != nil {
+		return err
+	}
+
+	until := time.Now().Add(duration)
+	clients := possum.NewClients(sess.Copy(&aws.Config{Region: region}))
+	if err := possum.SetOverride(context.Background(), clients, flags.Arg(0), flags.Arg(1), until, config.Tags); err != nil {
+		return err
+	}
+
+	fmt.Printf("possum will leave %s %s alone until %s\n", flags.Arg(0), flags.Arg(1), until.Format(time.RFC1123))
+	return nil
+}
+
+func history(sess *session.Session, args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	limit := flags.Int64("limit", 20, "the maximum number of records to print, 0 prints all of them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("history expects a resource ID, or its key <account>/<region>/<type>/<id>, e.g. prod/us-east-1/instance/i-0123456789")
+	}
+
+	// AUDIT_TABLE is enough, the config table is only read for the audit table in the stored config
+	config := &possum.Config{}
+	var err error
+	if os.Getenv("AUDIT_TABLE") != "" {
+		config.ApplyEnv(context.Background())
+	} else if config, err = loadConfig(sess); err != nil {
+		return err
+	}
+
+	auditLog := possum.NewAuditLog(config.Audit, dynamodb.New(sess))
+	if auditLog == nil {
+		return errors.New("no audit table configured, set AUDIT_TABLE or Audit.Table in the config")
+	}
+
+	// a bare ID finds the resource in every account and region
+	query := auditLog.History
+	if !strings.Contains(flags.Arg(0), "/") {
+		query = auditLog.HistoryByID
+	}
+	records, err := query(context.Background(), flags.Arg(0), *limit)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Printf("no history for %s\n", flags.Arg(0))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tOUTCOME\tACTOR\tSCHEDULE\tACCOUNT\tREGION\tTYPE\tINVOCATION")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Time.Local().Format(time.RFC1123), r.Action, r.Outcome, r.Actor, r.Schedule, r.Account, r.Region, r.Type, r.InvocationID)
+	}
+	return w.Flush()
+}
+
+func plan(sess *session.Session, args []string) error {
+	flags := flag.NewFlagSet("plan", flag.ContinueOnError)
+	region := flags.String("region", *sess.Config.Region, "the region to plan")
+	at := flags.String("at", "", "the RFC3339 time to plan for, defaults to now")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ts := time.Now()
+	if *at != "" {
+		var err error
+		if ts, err = time.Parse(time.RFC3339, *at); err != nil {
+			return err
+		}
+	}
+
+	store, err := possum.ScheduleStoreFromEnv(sess)
+	if err != nil {
+		return err
+	}
+	schedules, err := store.GetSchedules(context.Background())
+	if err != nil {
+		return err
+	}
+
+	config, err := loadConfig(sess)
+	if err != nil {
+		return err
+	}
+
+	clients := possum.NewClients(sess.Copy(&aws.Config{Region: region}))
+	changes, err := possum.Plan(context.Background(), clients, ts, schedules, config.Tags)
+	if err != nil {
+		return err
+	}
+	changes, rejected := config.Policies.Check(changes, ts, schedules)
+	changes = changes.Append(rejected)
+
+	report := &possum.Report{Time: ts}
+	report.Add("plan", *region, changes)
+	if report.Empty() {
+		fmt.Printf("possum has nothing to do in %s at %s\n", *region, ts.Format(time.RFC1123))
+		return nil
+	}
+	fmt.Print(report)
+	return nil
+}
+
+func approvals(sess *session.Session) error {
+	tableName, err := configTable()
+	if err != nil {
+		return err
+	}
+	approvals, err := possum.NewApprovalStore(dynamodb.New(sess), tableName).List(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(approvals) == 0 {
+		fmt.Println("no stops wait for approval")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSCHEDULE\tREQUESTED\tEXPIRES\tAPPROVED BY")
+	for _, a := range approvals {
+		approvedBy := a.ApprovedBy
+		if approvedBy == "" {
+			approvedBy = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", a.ID, a.Name, a.Schedule, a.Requested.Local().Format(time.RFC1123), a.Expires.Local().Format(time.RFC1123), approvedBy)
+	}
+	return w.Flush()
+}
+
+func approve(sess *session.Session, args []string) error {
+	if len(args) != 1 {
+		return errors.New("approve expects the id of an approval, e.g. prod/us-east-1/rds/reports-db, see possum-cli approvals")
+	}
+	tableName, err := configTable()
+	if err != nil {
+		return err
+	}
+	approval, err := possum.NewApprovalStore(dynamodb.New(sess), tableName).Approve(context.Background(), args[0], author(sess), time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("approved the stop of %s, possum stops it in its next run before %s\n", approval.ID, approval.Expires.Local().Format(time.RFC1123))
+	return nil
+}
+
+// loadConfig returns the config in the config table, if there is one, with the env variables applied
+func loadConfig(sess *session.Session) (*possum.Config, error) {
+	config := &possum.Config{}
+	if tableName := os.Getenv("CONFIG_TABLE"); tableName != "" {
+		var err error
+		if config, err = possum.GetConfig(dynamodb.New(sess), tableName); err != nil {
+			return nil, err
+		}
+	}
+	config.ApplyEnv(context.Background())
+	return config, nil
+}
+
+func configTable() (string, error) {
+	tableName := os.Getenv("CONFIG_TABLE")
+	if tableName == "" {
+		return "", errors.New("env variable CONFIG_TABLE is empty, this should be the name of dynamodb table, see docs")
+	}
+	return tableName, nil
+}
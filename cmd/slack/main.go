@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	awslambda "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/silverstripeltd/possum"
+)
+
+func main() {
+	lambda.Start(Handler)
+}
+
+// event is either a request from slack via API Gateway, or a slash command the function dispatched to itself
+type event struct {
+	events.APIGatewayProxyRequest
+	Command *slashCommand `json:"possumCommand,omitempty"`
+}
+
+// Handler answers slack slash commands and interactions, like the snooze buttons in warnings, via API Gateway. Slash
+// commands are acknowledged right away and answered by an asynchronous invocation of the function itself.
+func Handler(ctx context.Context, e event) (events.APIGatewayProxyResponse, error) {
+	var invocationID, functionARN string
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		invocationID = lc.AwsRequestID
+		functionARN = lc.InvokedFunctionArn
+	}
+	ctx = possum.WithLogger(ctx, possum.LoggerFrom(ctx).With(possum.LogInvocationID, invocationID))
+
+	secret := os.Getenv("SLACK_SIGNING_SECRET")
+	if secret == "" {
+		return events.APIGatewayProxyResponse{}, errors.New("env variable SLACK_SIGNING_SECRET is empty, see docs")
+	}
+
+	tableName := os.Getenv("CONFIG_TABLE")
+	if tableName == "" {
+		return events.APIGatewayProxyResponse{}, errors.New("env variable CONFIG_TABLE is empty, this should be the name of dynamodb table, see docs")
+	}
+
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(possum.HomeRegion())}))
+	client := dynamodb.New(sess)
+	config, err := possum.GetConfig(client, tableName)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	config.ApplyEnv(ctx)
+
+	b := &awsBackend{
+		sess:              sess,
+		config:            config,
+		deployedAccountID: e.RequestContext.AccountID,
+		auditLog:          possum.NewAuditLog(config.Audit, client),
+		approvals:         possum.NewApprovalStore(client, tableName),
+		invocationID:      invocationID,
+	}
+
+	srv := &server{
+		signingSecret: secret,
+		backend:       b,
+		now:           time.Now,
+		respond:       respond,
+		dispatch:      dispatch(awslambda.New(sess), functionARN, e.RequestContext.AccountID),
+	}
+	if e.Command != nil {
+		srv.run(ctx, e.Command)
+		return events.APIGatewayProxyResponse{}, nil
+	}
+	return srv.handle(ctx, e.APIGatewayProxyRequest), nil
+}
+
+// dispatch invokes the function asynchronously with the slash command, the account ID is passed on because the
+// asynchronous invocation doesn't come from API Gateway
+func dispatch(client lambdaiface.LambdaAPI, functionARN, accountID string) func(context.Context, *slashCommand) error {
+	return func(ctx context.Context, cmd *slashCommand) error {
+		e := &event{Command: cmd}
+		e.RequestContext.AccountID = accountID
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = client.InvokeWithContext(ctx, &awslambda.InvokeInput{
+			FunctionName:   aws.String(functionARN),
+			InvocationType: aws.String(awslambda.InvocationTypeEvent),
+			Payload:        payload,
+		})
+		return err
+	}
+}
+
+// awsBackend finds and changes resources in every account and region possum is configured for
+type awsBackend struct {
+	sess              *session.Session
+	config            *possum.Config
+	deployedAccountID string
+	auditLog          *possum.AuditLog
+	approvals         *possum.ApprovalStore
+	invocationID      string
+	accounts          []*possum.Account
+}
+
+func (b *awsBackend) Resources(ctx context.Context) ([]*located, error) {
+	accounts, err := b.getAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	var result []*located
+
+	var x sync.Mutex
+	var wg sync.WaitGroup
+	for _, account := range accounts {
+		sess := possum.AccountSession(b.sess, account)
+		regions, err := possum.GetRegions(ctx, sess, b.config)
+		if err != nil {
+			// the goroutines of the accounts before this one append to errs as well
+			x.Lock()
+			errs = append(errs, fmt.Errorf("%s: %w", account, err))
+			x.Unlock()
+			continue
+		}
+		wg.Add(len(regions))
+		for _, region := range regions {
+			go func(a *possum.Account, r *string) {
+				defer wg.Done()
+				resources, err := possum.ListResources(ctx, possum.NewClients(sess.Copy(&aws.Config{Region: r})), b.config.Tags)
+				x.Lock()
+				defer x.Unlock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s, %s: %w", a, *r, err))
+					return
+				}
+				for _, resource := range resources {
+					result = append(result, &located{Resource: resource, Account: a.String(), Region: *r})
+				}
+			}(account, region)
+		}
+	}
+	wg.Wait()
+
+	// partial results are more useful than none when one account is unreachable
+	if len(result) == 0 && len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return result, nil
+}
+
+func (b *awsBackend) Perform(ctx context.Context, r *located, action possum.ScheduledAction, actor string) error {
+	clients, err := b.clients(ctx, r.Account, r.Region)
+	if err != nil {
+		return err
+	}
+	changes := possum.Changes{r.Change(action)}
+	err = possum.Perform(ctx, clients, changes, b.config.Tags)
+
+	record := possum.NewAuditRecord(time.Now(), r.Account, r.Region, changes[0], actor, b.invocationID)
+	if auditErr := b.auditLog.Write(ctx, []*possum.AuditRecord{record}); auditErr != nil {
+		possum.LoggerFrom(ctx).With(possum.LogAccount, r.Account, possum.LogRegion, r.Region).WithChange(changes[0]).Errorf("writing audit log: %s", auditErr)
+	}
+	return err
+}
+
+func (b *awsBackend) Override(ctx context.Context, r *located, until time.Time) error {
+	clients, err := b.clients(ctx, r.Account, r.Region)
+	if err != nil {
+		return err
+	}
+	return possum.SetOverride(ctx, clients, r.Type, r.ID, until, b.config.Tags)
+}
+
+func (b *awsBackend) Approve(ctx context.Context, id, actor string) (*possum.Approval, error) {
+	return b.approvals.Approve(ctx, id, actor, time.Now())
+}
+
+func (b *awsBackend) clients(ctx context.Context, account, region string) (*possum.Clients, error) {
+	accounts, err := b.getAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		if a.String() == account || a.ID == account {
+			sess := possum.AccountSession(b.sess, a).Copy(&aws.Config{Region: aws.String(region)})
+			return possum.NewClients(sess), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown account '%s'", account)
+}
+
+func (b *awsBackend) getAccounts(ctx context.Context) ([]*possum.Account, error) {
+	if b.accounts != nil {
+		return b.accounts, nil
+	}
+	accounts, err := possum.GetAccounts(ctx, b.sess, b.config, b.deployedAccountID)
+	if err != nil {
+		return nil, err
+	}
+	b.accounts = accounts
+	return accounts, nil
+}
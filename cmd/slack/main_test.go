@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awslambda "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+type mockLambdaClient struct {
+	lambdaiface.LambdaAPI
+	invoked []*awslambda.InvokeInput
+}
+
+func (m *mockLambdaClient) InvokeWithContext(ctx aws.Context, input *awslambda.InvokeInput, options ...request.Option) (*awslambda.InvokeOutput, error) {
+	m.invoked = append(m.invoked, input)
+	return &awslambda.InvokeOutput{StatusCode: aws.Int64(202)}, nil
+}
+
+func TestDispatch(t *testing.T) {
+	client := &mockLambdaClient{}
+	cmd := &slashCommand{Text: "status web", User: "jane", ResponseURL: "https://hooks.slack.com/commands/T0123ABCD/123/abc"}
+	if err := dispatch(client, "arn:aws:lambda:us-east-1:123456789012:function:possum-slack:live", "123456789012")(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.invoked) != 1 || aws.StringValue(client.invoked[0].InvocationType) != awslambda.InvocationTypeEvent {
+		t.Fatalf("expected one asynchronous invocation, got %v", client.invoked)
+	}
+
+	// the function gets the command back as its event
+	var e event
+	if err := json.Unmarshal(client.invoked[0].Payload, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Command == nil || *e.Command != *cmd || e.RequestContext.AccountID != "123456789012" {
+		t.Errorf("expected the command and the account in the event, got %+v", e)
+	}
+
+	// requests from API Gateway don't have a command
+	var req event
+	if err := json.Unmarshal([]byte(`{"body": "text=status", "requestContext": {"accountId": "123456789012"}}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Command != nil || req.Body != "text=status" {
+		t.Errorf("expected an API Gateway request, got %+v", req)
+	}
+}
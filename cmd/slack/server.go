@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/silverstripeltd/possum"
+	"github.com/slack-go/slack"
+)
+
+// slack requests older than this are rejected to prevent replays
+const maxRequestAge = 5 * time.Minute
+
+// defaultOverride is how long possum leaves a resource alone after it was started or stopped from slack
+const defaultOverride = time.Hour
+
+const help = "Usage: `/possum <command>`\n" +
+	"• `status [filter]` lists scheduled resources, optionally only those with the filter in their name, id or schedule\n" +
+	"• `start <name|id> [duration]` starts a resource and leaves it running for the duration, 1h by default\n" +
+	"• `stop <name|id> [duration]` stops a resource and leaves it stopped for the duration, 1h by default\n" +
+	"• `snooze <name|id> <duration>` leaves a resource alone for the duration\n" +
+	"• `approve <name|id>` approves the stop of a resource that requires approval\n" +
+	"• `help` shows this message"
+
+// located is a resource and where to find it
+type located struct {
+	*possum.Resource
+	Account string
+	Region  string
+}
+
+// backend looks up and changes the resources that slack users ask about
+type backend interface {
+	Resources(ctx context.Context) ([]*located, error)
+	Perform(ctx context.Context, r *located, action possum.ScheduledAction, actor string) error
+	Override(ctx context.Context, r *located, until time.Time) error
+	Approve(ctx context.Context, id, actor string) (*possum.Approval, error)
+}
+
+// slashCommand is a /possum command that is answered after the request was acknowledged, slack gives up on commands
+// that aren't acknowledged within 3 seconds and listing the resources of every account and region takes longer
+type slashCommand struct {
+	Text        string
+	User        string
+	ResponseURL string
+}
+
+type server struct {
+	signingSecret string
+	backend       backend
+	now           func() time.Time
+	respond       func(ctx context.Context, responseURL string, msg *slack.WebhookMessage) error
+	dispatch      func(ctx context.Context, cmd *slashCommand) error // runs the command after the response, see run
+}
+
+// respond posts a message to the response_url of a slash command or interaction
+func respond(ctx context.Context, responseURL string, msg *slack.WebhookMessage) error {
+	return slack.PostWebhookContext(ctx, responseURL, msg)
+}
+
+func (s *server) handle(ctx context.Context, req events.APIGatewayProxyRequest) events.APIGatewayProxyResponse {
+	body := req.Body
+	if req.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return textResponse(http.StatusBadRequest, "invalid body")
+		}
+		body = string(b)
+	}
+
+	if err := s.verify(header(req.Headers, "X-Slack-Request-Timestamp"), header(req.Headers, "X-Slack-Signature"), body); err != nil {
+		possum.LoggerFrom(ctx).Warnf("rejected slack request: %s", err)
+		return textResponse(http.StatusUnauthorized, "invalid signature")
+	}
+
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		return textResponse(http.StatusBadRequest, "invalid body")
+	}
+
+	if payload := form.Get("payload"); payload != "" {
+		return s.interaction(ctx, payload)
+	}
+	return s.acknowledge(ctx, &slashCommand{Text: form.Get("text"), User: form.Get("user_name"), ResponseURL: form.Get("response_url")})
+}
+
+// acknowledge answers a slash command right away, the commands that look up resources are dispatched and their
+// answer is posted to the response_url of the command
+func (s *server) acknowledge(ctx context.Context, cmd *slashCommand) events.APIGatewayProxyResponse {
+	args := strings.Fields(cmd.Text)
+	if len(args) == 0 || args[0] == "help" {
+		return messageResponse(ephemeral(help))
+	}
+	if err := s.dispatch(ctx, cmd); err != nil {
+		possum.LoggerFrom(ctx).Errorf("dispatching slack command '%s': %s", cmd.Text, err)
+		return messageResponse(ephemeral(fmt.Sprintf(":x: could not run `%s`: %s", cmd.Text, err)))
+	}
+	return messageResponse(ephemeral(fmt.Sprintf(":hourglass_flowing_sand: on it, looking up the resources for `%s`", cmd.Text)))
+}
+
+// run answers a dispatched slash command
+func (s *server) run(ctx context.Context, cmd *slashCommand) {
+	msg := s.command(ctx, cmd.Text, cmd.User)
+	if err := s.respond(ctx, cmd.ResponseURL, msg); err != nil {
+		possum.LoggerFrom(ctx).Errorf("responding to slack: %s", err)
+	}
+}
+
+// verify checks the request signature as described in https://api.slack.com/authentication/verifying-requests-from-slack
+func (s *server) verify(timestamp, signature, body string) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp '%s'", timestamp)
+	}
+	age := s.now().Sub(time.Unix(ts, 0))
+	if age > maxRequestAge || age < -maxRequestAge {
+		return fmt.Errorf("timestamp '%s' is too old", timestamp)
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.signingSecret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// interaction handles the snooze buttons in stop warnings and the approve buttons of stops that wait for approval
+func (s *server) interaction(ctx context.Context, payload string) events.APIGatewayProxyResponse {
+	var callback slack.InteractionCallback
+	if err := json.Unmarshal([]byte(payload), &callback); err != nil {
+		return textResponse(http.StatusBadRequest, "invalid payload")
+	}
+
+	for _, action := range callback.ActionCallback.BlockActions {
+		var text string
+		switch {
+		case action.ActionID == possum.SlackApproveActionID:
+			text = s.approve(ctx, action.Value, callback.User.Name)
+		case strings.HasPrefix(action.ActionID, possum.SlackSnoozeActionID+":"):
+			var snooze possum.Snooze
+			if err := json.Unmarshal([]byte(action.Value), &snooze); err != nil {
+				text = fmt.Sprintf(":x: invalid snooze: %s", err)
+			} else {
+				text = s.snooze(ctx, &snooze, callback.User.Name)
+			}
+		default:
+			continue
+		}
+
+		msg := &slack.WebhookMessage{Text: text, ResponseType: slack.ResponseTypeInChannel}
+		if err := s.respond(ctx, callback.ResponseURL, msg); err != nil {
+			possum.LoggerFrom(ctx).Errorf("responding to slack: %s", err)
+		}
+	}
+	return textResponse(http.StatusOK, "")
+}
+
+func (s *server) snooze(ctx context.Context, snooze *possum.Snooze, user string) string {
+	duration, err := time.ParseDuration(snooze.Duration)
+	if err != nil {
+		return fmt.Sprintf(":x: invalid duration '%s'", snooze.Duration)
+	}
+	r := &located{
+		Resource: &possum.Resource{ID: snooze.ID, Name: snooze.ID, Type: snooze.Type},
+		Account:  snooze.Account,
+		Region:   snooze.Region,
+	}
+	until := s.now().Add(duration)
+	if err := s.backend.Override(ctx, r, until); err != nil {
+		return fmt.Sprintf(":x: could not snooze `%s`: %s", snooze.ID, err)
+	}
+	return fmt.Sprintf(":zzz: %s snoozed `%s` in %s (%s) until %s", user, snooze.ID, snooze.Account, snooze.Region, slackDate(until))
+}
+
+// approve approves a stop that waits for approval, possum makes it in its next run
+func (s *server) approve(ctx context.Context, id, user string) string {
+	approval, err := s.backend.Approve(ctx, id, "slack:"+user)
+	if err != nil {
+		return fmt.Sprintf(":x: could not approve the stop: %s", err)
+	}
+	return fmt.Sprintf(":white_check_mark: %s approved the stop of `%s` in %s (%s), possum stops it in its next run", user, approval.Resource, approval.Account, approval.Region)
+}
+
+// command handles the text of a /possum slash command
+func (s *server) command(ctx context.Context, text, user string) *slack.WebhookMessage {
+	args := strings.Fields(text)
+	if len(args) == 0 {
+		return ephemeral(help)
+	}
+
+	switch args[0] {
+	case "status":
+		return s.status(ctx, strings.Join(args[1:], " "))
+	case "start", "stop":
+		if len(args) < 2 || len(args) > 3 {
+			return ephemeral(fmt.Sprintf("`%s` expects a resource name or id and an optional duration", args[0]))
+		}
+		duration := defaultOverride
+		if len(args) == 3 {
+			d, err := time.ParseDuration(args[2])
+			if err != nil {
+				return ephemeral(fmt.Sprintf("invalid duration '%s'", args[2]))
+			}
+			duration = d
+		}
+		action := possum.StartAction
+		if args[0] == "stop" {
+			action = possum.StopAction
+		}
+		return s.perform(ctx, args[1], action, duration, user)
+	case "snooze":
+		if len(args) != 3 {
+			return ephemeral("`snooze` expects a resource name or id and a duration, e.g. `snooze web-1 2h`")
+		}
+		duration, err := time.ParseDuration(args[2])
+		if err != nil {
+			return ephemeral(fmt.Sprintf("invalid duration '%s'", args[2]))
+		}
+		return s.perform(ctx, args[1], possum.NoopAction, duration, user)
+	case "approve":
+		if len(args) != 2 {
+			return ephemeral("`approve` expects a resource name or id, e.g. `approve reports-db`")
+		}
+		resources, err := s.backend.Resources(ctx)
+		if err != nil {
+			return ephemeral(fmt.Sprintf(":x: %s", err))
+		}
+		r, err := find(resources, args[1])
+		if err != nil {
+			return ephemeral(fmt.Sprintf(":x: %s", err))
+		}
+		return &slack.WebhookMessage{
+			ResponseType: slack.ResponseTypeInChannel,
+			Text:         s.approve(ctx, possum.ApprovalID(r.Account, r.Region, r.Type, r.ID), user),
+		}
+	case "help":
+		return ephemeral(help)
+	}
+	return ephemeral(fmt.Sprintf("unknown command '%s'\n%s", args[0], help))
+}
+
+func (s *server) status(ctx context.Context, filter string) *slack.WebhookMessage {
+	resources, err := s.backend.Resources(ctx)
+	if err != nil {
+		return ephemeral(fmt.Sprintf(":x: %s", err))
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		a, b := resources[i], resources[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.Name < b.Name
+	})
+
+	filter = strings.ToLower(filter)
+	now := s.now()
+	var lines []string
+	for _, r := range resources {
+		if filter != "" && !strings.Contains(strings.ToLower(r.Name+" "+r.ID+" "+r.Schedule), filter) {
+			continue
+		}
+		line := fmt.Sprintf("• *%s* `%s` %s · %s · _%s_ · %s (%s)", r.Name, r.ID, r.Type, r.State, r.Schedule, r.Account, r.Region)
+		if r.Protected {
+			line += " · :lock: protected"
+		}
+		if r.Overridden(now) {
+			line += fmt.Sprintf(" · :zzz: until %s", slackDate(r.OverrideUntil))
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		return ephemeral("no scheduled resources found")
+	}
+	return ephemeral(strings.Join(lines, "\n"))
+}
+
+// perform applies the action to a resource and overrides its schedule for the duration, so that possum doesn't undo it
+func (s *server) perform(ctx context.Context, query string, action possum.ScheduledAction, duration time.Duration, user string) *slack.WebhookMessage {
+	resources, err := s.backend.Resources(ctx)
+	if err != nil {
+		return ephemeral(fmt.Sprintf(":x: %s", err))
+	}
+	r, err := find(resources, query)
+	if err != nil {
+		return ephemeral(fmt.Sprintf(":x: %s", err))
+	}
+
+	if r.Protected {
+		return ephemeral(fmt.Sprintf(":lock: `%s` is protected, possum doesn't start, stop or snooze it", r.ID))
+	}
+
+	if action != possum.NoopAction {
+		if err := s.backend.Perform(ctx, r, action, "slack:"+user); err != nil {
+			return ephemeral(fmt.Sprintf(":x: could not %s `%s`: %s", action, r.ID, err))
+		}
+	}
+
+	until := s.now().Add(duration)
+	if err := s.backend.Override(ctx, r, until); err != nil {
+		return ephemeral(fmt.Sprintf(":x: could not override the schedule of `%s`: %s", r.ID, err))
+	}
+
+	verb := "snoozed"
+	switch action {
+	case possum.StartAction:
+		verb = "started"
+	case possum.StopAction:
+		verb = "stopped"
+	}
+	return &slack.WebhookMessage{
+		ResponseType: slack.ResponseTypeInChannel,
+		Text:         fmt.Sprintf("%s *%s* `%s` in %s (%s), possum leaves it alone until %s", verb, r.Name, r.ID, r.Account, r.Region, slackDate(until)),
+	}
+}
+
+// find returns the resource with the id, or else the name, an ambiguous name is an error
+func find(resources []*located, query string) (*located, error) {
+	for _, r := range resources {
+		if r.ID == query {
+			return r, nil
+		}
+	}
+
+	var matches []*located
+	for _, r := range resources {
+		if strings.EqualFold(r.Name, query) {
+			matches = append(matches, r)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no scheduled resource named '%s'", query)
+	case 1:
+		return matches[0], nil
+	}
+
+	var ids []string
+	for _, r := range matches {
+		ids = append(ids, fmt.Sprintf("`%s` (%s, %s)", r.ID, r.Account, r.Region))
+	}
+	return nil, fmt.Errorf("'%s' matches several resources, use one of the ids: %s", query, strings.Join(ids, ", "))
+}
+
+func ephemeral(text string) *slack.WebhookMessage {
+	return &slack.WebhookMessage{ResponseType: slack.ResponseTypeEphemeral, Text: text}
+}
+
+func slackDate(t time.Time) string {
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>", t.Unix(), t.UTC().Format(time.RFC1123))
+}
+
+// header returns a header value, API Gateway doesn't normalise the case of header names
+func header(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+func messageResponse(msg *slack.WebhookMessage) events.APIGatewayProxyResponse {
+	b, _ := json.Marshal(msg)
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(b),
+	}
+}
+
+func textResponse(status int, text string) events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "text/plain"},
+		Body:       text,
+	}
+}
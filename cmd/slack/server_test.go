@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/silverstripeltd/possum"
+	"github.com/slack-go/slack"
+)
+
+const testSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+var testNow = time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+
+type performed struct {
+	id     string
+	action possum.ScheduledAction
+	actor  string
+}
+
+type overridden struct {
+	account, region, resourceType, id string
+	until                             time.Time
+}
+
+type fakeBackend struct {
+	resources  []*located
+	err        error
+	performed  []performed
+	overridden []overridden
+	approved   []string
+	listed     int
+}
+
+func (b *fakeBackend) Resources(ctx context.Context) ([]*located, error) {
+	b.listed++
+	return b.resources, b.err
+}
+
+func (b *fakeBackend) Perform(ctx context.Context, r *located, action possum.ScheduledAction, actor string) error {
+	b.performed = append(b.performed, performed{r.ID, action, actor})
+	return nil
+}
+
+func (b *fakeBackend) Override(ctx context.Context, r *located, until time.Time) error {
+	b.overridden = append(b.overridden, overridden{r.Account, r.Region, r.Type, r.ID, until})
+	return nil
+}
+
+func (b *fakeBackend) Approve(ctx context.Context, id, actor string) (*possum.Approval, error) {
+	parts := strings.Split(id, "/")
+	if len(parts) != 4 || parts[3] != "db-1" {
+		return nil, fmt.Errorf("no pending approval '%s'", id)
+	}
+	b.approved = append(b.approved, id+" by "+actor)
+	return &possum.Approval{ID: id, Account: parts[0], Region: parts[1], Type: parts[2], Resource: parts[3], ApprovedBy: actor}, nil
+}
+
+// newTestServer returns a server that runs dispatched commands right away, instead of in another invocation
+func newTestServer(b backend, responses *[]*slack.WebhookMessage) *server {
+	srv := &server{
+		signingSecret: testSecret,
+		backend:       b,
+		now:           func() time.Time { return testNow },
+		respond: func(ctx context.Context, responseURL string, msg *slack.WebhookMessage) error {
+			*responses = append(*responses, msg)
+			return nil
+		},
+	}
+	srv.dispatch = func(ctx context.Context, cmd *slashCommand) error {
+		srv.run(ctx, cmd)
+		return nil
+	}
+	return srv
+}
+
+func signedRequest(body string, ts time.Time) events.APIGatewayProxyRequest {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	return events.APIGatewayProxyRequest{
+		Headers: map[string]string{
+			"x-slack-request-timestamp": timestamp,
+			"x-slack-signature":         "v0=" + hex.EncodeToString(mac.Sum(nil)),
+		},
+		Body: body,
+	}
+}
+
+func commandBody(text string) string {
+	return url.Values{
+		"command":      {"/possum"},
+		"text":         {text},
+		"user_name":    {"jane"},
+		"response_url": {"https://hooks.slack.com/commands/T0123ABCD/123/abc"},
+	}.Encode()
+}
+
+func testResources() []*located {
+	return []*located{
+		{Resource: &possum.Resource{ID: "i-0123456789", Name: "web-1", Type: possum.InstanceResource, Schedule: "OfficeHours", State: "running", Running: true}, Account: "staging", Region: "ap-southeast-2"},
+		{Resource: &possum.Resource{ID: "i-9876543210", Name: "worker", Type: possum.InstanceResource, Schedule: "OfficeHours", State: "stopped"}, Account: "staging", Region: "ap-southeast-2"},
+		{Resource: &possum.Resource{ID: "i-5555555555", Name: "worker", Type: possum.InstanceResource, Schedule: "OfficeHours", State: "stopped"}, Account: "prod", Region: "us-east-1"},
+		{Resource: &possum.Resource{ID: "db-1", Name: "db-1", Type: possum.DBInstanceResource, Schedule: "Weekdays", State: "available", Running: true, OverrideUntil: testNow.Add(time.Hour)}, Account: "prod", Region: "us-east-1"},
+		{Resource: &possum.Resource{ID: "i-7777777777", Name: "vault", Type: possum.InstanceResource, Schedule: "OfficeHours", State: "running", Running: true, Protected: true}, Account: "prod", Region: "us-east-1"},
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := commandBody("help")
+	tests := []struct {
+		name   string
+		req    events.APIGatewayProxyRequest
+		status int
+	}{
+		{"valid", signedRequest(body, testNow), 200},
+		{"valid within window", signedRequest(body, testNow.Add(-4*time.Minute)), 200},
+		{"replayed", signedRequest(body, testNow.Add(-6*time.Minute)), 401},
+		{"tampered", func() events.APIGatewayProxyRequest {
+			req := signedRequest(body, testNow)
+			req.Body = commandBody("stop web-1")
+			return req
+		}(), 401},
+		{"unsigned", events.APIGatewayProxyRequest{Body: body}, 401},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var responses []*slack.WebhookMessage
+			resp := newTestServer(&fakeBackend{}, &responses).handle(context.Background(), test.req)
+			if resp.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d", test.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		text         string
+		responseType string
+		contains     []string
+		excludes     []string
+		performed    []performed
+		overridden   []string
+	}{
+		{
+			text:         "",
+			responseType: slack.ResponseTypeEphemeral,
+			contains:     []string{"Usage"},
+		},
+		{
+			text:         "status",
+			responseType: slack.ResponseTypeEphemeral,
+			contains:     []string{"web-1", "worker", "db-1", ":zzz: until", "vault", ":lock: protected"},
+		},
+		{
+			text:         "status web",
+			responseType: slack.ResponseTypeEphemeral,
+			contains:     []string{"web-1"},
+			excludes:     []string{"worker", "db-1"},
+		},
+		{
+			text:         "start i-9876543210",
+			responseType: slack.ResponseTypeInChannel,
+			contains:     []string{"started *worker*", "staging"},
+			performed:    []performed{{"i-9876543210", possum.StartAction, "slack:jane"}},
+			overridden:   []string{"i-9876543210"},
+		},
+		{
+			text:         "stop WEB-1 30m",
+			responseType: slack.ResponseTypeInChannel,
+			contains:     []string{"stopped *web-1*"},
+			performed:    []performed{{"i-0123456789", possum.StopAction, "slack:jane"}},
+			overridden:   []string{"i-0123456789"},
+		},
+		{
+			text:         "snooze db-1 2h",
+			responseType: slack.ResponseTypeInChannel,
+			contains:     []string{"snoozed *db-1*"},
+			overridden:   []string{"db-1"},
+		},
+		{
+			text:         "stop vault",
+			responseType: slack.ResponseTypeEphemeral,
+			contains:     []string{"`i-7777777777` is protected"},
+		},
+		{
+			text:         "snooze vault 2h",
+			responseType: slack.ResponseTypeEphemeral,
+			contains:     []string{"is protected"},
+		},
+		{
+			text:         "approve db-1",
+			responseType: slack.ResponseTypeInChannel,
+			contains:     []string{"jane approved the stop of `db-1` in prod (us-east-1)"},
+		},
+		{
+			text:         "approve web-1",
+			responseType: slack.ResponseTypeInChannel,
+			contains:     []string{"could not approve", "no pending approval 'staging/ap-southeast-2/instance/i-0123456789'"},
+		},
+		{
+			text:         "start worker",
+			responseType: slack.ResponseTypeEphemeral,
+			contains:     []string{"matches several resources", "i-9876543210", "i-5555555555"},
+		},
+		{
+			text:         "stop nothing",
+			responseType: slack.ResponseTypeEphemeral,
+			contains:     []string{"no scheduled resource named 'nothing'"},
+		},
+		{
+			text:         "snooze web-1 forever",
+			responseType: slack.ResponseTypeEphemeral,
+			contains:     []string{"invalid duration"},
+		},
+		{
+			text:         "reboot web-1",
+			responseType: slack.ResponseTypeEphemeral,
+			contains:     []string{"unknown command 'reboot'"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			b := &fakeBackend{resources: testResources()}
+			var responses []*slack.WebhookMessage
+			resp := newTestServer(b, &responses).handle(context.Background(), signedRequest(commandBody(test.text), testNow))
+			if resp.StatusCode != 200 {
+				t.Fatalf("expected status 200, got %d", resp.StatusCode)
+			}
+
+			// help is answered right away, the other commands at the response_url
+			var msg slack.WebhookMessage
+			if err := json.Unmarshal([]byte(resp.Body), &msg); err != nil {
+				t.Fatal(err)
+			}
+			if len(responses) == 1 {
+				msg = *responses[0]
+			}
+			if msg.ResponseType != test.responseType {
+				t.Errorf("expected response type %s, got %s", test.responseType, msg.ResponseType)
+			}
+			for _, s := range test.contains {
+				if !strings.Contains(msg.Text, s) {
+					t.Errorf("expected response to contain '%s', got '%s'", s, msg.Text)
+				}
+			}
+			for _, s := range test.excludes {
+				if strings.Contains(msg.Text, s) {
+					t.Errorf("expected response not to contain '%s', got '%s'", s, msg.Text)
+				}
+			}
+
+			if len(b.performed) != len(test.performed) {
+				t.Fatalf("expected %d actions, got %d", len(test.performed), len(b.performed))
+			}
+			for i := range test.performed {
+				if b.performed[i] != test.performed[i] {
+					t.Errorf("expected %v, got %v", test.performed[i], b.performed[i])
+				}
+			}
+			if len(b.overridden) != len(test.overridden) {
+				t.Fatalf("expected %d overrides, got %d", len(test.overridden), len(b.overridden))
+			}
+			for i, id := range test.overridden {
+				if b.overridden[i].id != id {
+					t.Errorf("expected override of %s, got %s", id, b.overridden[i].id)
+				}
+			}
+		})
+	}
+}
+
+func TestCommandOverrideDuration(t *testing.T) {
+	b := &fakeBackend{resources: testResources()}
+	var responses []*slack.WebhookMessage
+	srv := newTestServer(b, &responses)
+
+	srv.handle(context.Background(), signedRequest(commandBody("stop web-1"), testNow))
+	srv.handle(context.Background(), signedRequest(commandBody("stop web-1 30m"), testNow))
+
+	expected := []time.Time{testNow.Add(defaultOverride), testNow.Add(30 * time.Minute)}
+	for i, e := range expected {
+		if !b.overridden[i].until.Equal(e) {
+			t.Errorf("expected override until %s, got %s", e, b.overridden[i].until)
+		}
+	}
+}
+
+func TestCommandBackendError(t *testing.T) {
+	b := &fakeBackend{err: errors.New("AccessDenied")}
+	var responses []*slack.WebhookMessage
+	newTestServer(b, &responses).handle(context.Background(), signedRequest(commandBody("status"), testNow))
+	if len(responses) != 1 || !strings.Contains(responses[0].Text, "AccessDenied") {
+		t.Errorf("expected the error in the response, got %v", responses)
+	}
+}
+
+func TestCommandAcknowledged(t *testing.T) {
+	b := &fakeBackend{resources: testResources()}
+	var responses []*slack.WebhookMessage
+	var dispatched []*slashCommand
+	srv := newTestServer(b, &responses)
+	srv.dispatch = func(ctx context.Context, cmd *slashCommand) error {
+		dispatched = append(dispatched, cmd)
+		return nil
+	}
+
+	resp := srv.handle(context.Background(), signedRequest(commandBody("stop web-1"), testNow))
+	if resp.StatusCode != 200 || !strings.Contains(resp.Body, "on it") {
+		t.Errorf("expected the command to be acknowledged, got %d %s", resp.StatusCode, resp.Body)
+	}
+	if b.listed != 0 || len(b.performed) != 0 || len(responses) != 0 {
+		t.Errorf("expected the command not to run before it's acknowledged")
+	}
+	expected := slashCommand{Text: "stop web-1", User: "jane", ResponseURL: "https://hooks.slack.com/commands/T0123ABCD/123/abc"}
+	if len(dispatched) != 1 || *dispatched[0] != expected {
+		t.Fatalf("expected the command to be dispatched, got %v", dispatched)
+	}
+
+	srv.run(context.Background(), dispatched[0])
+	if b.listed != 1 || len(responses) != 1 || !strings.Contains(responses[0].Text, "stopped *web-1*") {
+		t.Errorf("expected the dispatched command to be answered at the response url, got %v", responses)
+	}
+
+	srv.dispatch = func(ctx context.Context, cmd *slashCommand) error {
+		return errors.New("AccessDeniedException")
+	}
+	resp = srv.handle(context.Background(), signedRequest(commandBody("status"), testNow))
+	if !strings.Contains(resp.Body, "could not run `status`: AccessDeniedException") {
+		t.Errorf("expected the dispatch error in the response, got %s", resp.Body)
+	}
+}
+
+func TestSnoozeButton(t *testing.T) {
+	payload, err := os.ReadFile("testdata/snooze_payload.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &fakeBackend{}
+	var responses []*slack.WebhookMessage
+	body := url.Values{"payload": {string(payload)}}.Encode()
+	resp := newTestServer(b, &responses).handle(context.Background(), signedRequest(body, testNow))
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	expected := overridden{"staging", "ap-southeast-2", possum.InstanceResource, "i-0123456789", testNow.Add(2 * time.Hour)}
+	if len(b.overridden) != 1 || b.overridden[0] != expected {
+		t.Fatalf("expected override %v, got %v", expected, b.overridden)
+	}
+
+	if len(responses) != 1 {
+		t.Fatalf("expected 1 response, got %d", len(responses))
+	}
+	if !strings.Contains(responses[0].Text, "jane snoozed `i-0123456789`") {
+		t.Errorf("unexpected response '%s'", responses[0].Text)
+	}
+}
+
+func TestApproveButton(t *testing.T) {
+	payload, err := os.ReadFile("testdata/approve_payload.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &fakeBackend{}
+	var responses []*slack.WebhookMessage
+	body := url.Values{"payload": {string(payload)}}.Encode()
+	resp := newTestServer(b, &responses).handle(context.Background(), signedRequest(body, testNow))
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	if len(b.approved) != 1 || b.approved[0] != "prod/us-east-1/rds/db-1 by slack:jane" {
+		t.Fatalf("expected the stop of db-1 to be approved, got %v", b.approved)
+	}
+	if len(responses) != 1 || !strings.Contains(responses[0].Text, "jane approved the stop of `db-1`") {
+		t.Errorf("unexpected responses %v", responses)
+	}
+	if len(b.overridden) != 0 {
+		t.Errorf("expected the approve button not to snooze anything")
+	}
+}
@@ -0,0 +1,122 @@
+package possum
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Compose resolves the schedules that extend other schedules, it returns an error for missing parents and cycles.
+// The returned schedules are copies, and are stored as they were defined.
+func (s Schedules) Compose() (Schedules, error) {
+	byName := make(map[string]*Schedule)
+	for _, schedule := range s {
+		if _, ok := byName[schedule.Name]; ok {
+			return nil, fmt.Errorf("there is more than one schedule named '%s'", schedule.Name)
+		}
+		byName[schedule.Name] = schedule
+	}
+
+	composed := make(map[string]*Schedule)
+	var compose func(schedule *Schedule, path []string) (*Schedule, error)
+	compose = func(schedule *Schedule, path []string) (*Schedule, error) {
+		for i, name := range path {
+			if name == schedule.Name {
+				return nil, fmt.Errorf("schedules extend each other in a cycle: %s -> %s", strings.Join(path[i:], " -> "), schedule.Name)
+			}
+		}
+		if c, ok := composed[schedule.Name]; ok {
+			return c, nil
+		}
+
+		def := schedule.definition
+		if def == nil {
+			def = schedule
+		}
+
+		c := &Schedule{
+			Name:                 def.Name,
+			WarnBefore:           def.WarnBefore,
+			RespectManualChanges: def.RespectManualChanges,
+			Mode:                 def.Mode,
+			Extends:              def.Extends,
+			Timezone:             def.Timezone,
+			Exclude:              def.Exclude,
+			Version:              def.Version,
+			definition:           def,
+		}
+
+		var loc *time.Location
+		if def.Timezone != "" {
+			var err error
+			if loc, err = time.LoadLocation(def.Timezone); err != nil {
+				return nil, fmt.Errorf("schedule '%s': %w", def.Name, err)
+			}
+		}
+
+		if def.Extends != "" {
+			parent, ok := byName[def.Extends]
+			if !ok {
+				return nil, fmt.Errorf("schedule '%s' extends '%s', which doesn't exist", def.Name, def.Extends)
+			}
+			p, err := compose(parent, append(path, def.Name))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, period := range p.Periods {
+				if excluded(period, def.Exclude) {
+					continue
+				}
+				if loc != nil {
+					moved := *period
+					moved.Location = loc
+					period = &moved
+				}
+				c.Periods = append(c.Periods, period)
+			}
+
+			// settings that the schedule doesn't set are inherited
+			if c.WarnBefore == 0 {
+				c.WarnBefore = p.WarnBefore
+			}
+			if !c.RespectManualChanges {
+				c.RespectManualChanges = p.RespectManualChanges
+			}
+			if c.Mode == "" {
+				c.Mode = p.Mode
+			}
+		}
+
+		for _, period := range def.Periods {
+			if period.Location == nil && loc != nil {
+				own := *period
+				own.Location = loc
+				period = &own
+			}
+			c.Periods = append(c.Periods, period)
+		}
+		composed[def.Name] = c
+		return c, nil
+	}
+
+	var result Schedules
+	for _, schedule := range s {
+		c, err := compose(schedule, nil)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+// excluded returns true if the period is in the list, periods are compared by their times and weekdays
+func excluded(period *Period, exclude []*Period) bool {
+	for _, e := range exclude {
+		if e.String() == period.String() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package possum
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+const composeTestSchedules = `[
+	{
+		"Name": "NZ Office",
+		"Locations": ["Pacific/Auckland", "Pacific/Auckland"],
+		"Periods": [
+			{"StartTime": "08:00", "StopTime": "18:00", "Weekdays": ["Monday", "Tuesday", "Wednesday", "Thursday", "Friday"]},
+			{"StartTime": "10:00", "StopTime": "14:00", "Weekdays": ["Saturday"]}
+		],
+		"WarnBefore": 15,
+		"Mode": "stop-only"
+	},
+	{
+		"Name": "UK Office",
+		"Extends": "NZ Office",
+		"Timezone": "Europe/London",
+		"Exclude": [
+			{"StartTime": "10:00", "StopTime": "14:00", "Weekdays": ["Saturday"]}
+		],
+		"Periods": [
+			{"StartTime": "12:00", "StopTime": "16:00", "Weekdays": ["Sunday"]}
+		],
+		"WarnBefore": 30
+	},
+	{
+		"Name": "UK Support",
+		"Extends": "UK Office"
+	}
+]`
+
+func TestSchedules_Compose(t *testing.T) {
+	var schedules Schedules
+	if err := json.Unmarshal([]byte(composeTestSchedules), &schedules); err != nil {
+		t.Fatal(err)
+	}
+
+	composed, err := schedules.Compose()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		periods    []string
+		locations  []string
+		warnBefore int
+		mode       Mode
+	}{
+		{
+			name:       "NZ Office",
+			periods:    []string{"08:00-18:00 [Monday, Tuesday, Wednesday, Thursday, Friday]", "10:00-14:00 [Saturday]"},
+			locations:  []string{"Pacific/Auckland", "Pacific/Auckland"},
+			warnBefore: 15,
+			mode:       StopOnlyMode,
+		},
+		{
+			name:       "UK Office",
+			periods:    []string{"08:00-18:00 [Monday, Tuesday, Wednesday, Thursday, Friday]", "12:00-16:00 [Sunday]"},
+			locations:  []string{"Europe/London", "Europe/London"},
+			warnBefore: 30,
+			mode:       StopOnlyMode,
+		},
+		{
+			name:       "UK Support",
+			periods:    []string{"08:00-18:00 [Monday, Tuesday, Wednesday, Thursday, Friday]", "12:00-16:00 [Sunday]"},
+			locations:  []string{"Europe/London", "Europe/London"},
+			warnBefore: 30,
+			mode:       StopOnlyMode,
+		},
+	}
+
+	for i, test := range tests {
+		s := composed.Find(test.name)
+		if s == nil {
+			t.Errorf("case %d. expected schedule %s", i+1, test.name)
+			continue
+		}
+		var periods, locations []string
+		for _, p := range s.Periods {
+			periods = append(periods, p.String())
+			locations = append(locations, p.Location.String())
+		}
+		if strings.Join(periods, "|") != strings.Join(test.periods, "|") {
+			t.Errorf("case %d. expected periods %v, got %v", i+1, test.periods, periods)
+		}
+		if strings.Join(locations, "|") != strings.Join(test.locations, "|") {
+			t.Errorf("case %d. expected locations %v, got %v", i+1, test.locations, locations)
+		}
+		if s.WarnBefore != test.warnBefore || s.Mode != test.mode {
+			t.Errorf("case %d. expected WarnBefore %d and mode %s, got %d and %s", i+1, test.warnBefore, test.mode, s.WarnBefore, s.Mode)
+		}
+	}
+
+	// the originals are left alone, and composed schedules are stored as they were defined
+	if len(schedules.Find("UK Support").Periods) != 0 {
+		t.Errorf("expected Compose not to change the original schedules")
+	}
+	b, err := json.Marshal(composed.Find("UK Support"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "StartTime") {
+		t.Errorf("expected the inherited periods not to be stored, got %s", b)
+	}
+
+	// composing composed schedules doesn't inherit the periods twice
+	again, err := composed.Compose()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Find("UK Support").Periods) != 2 {
+		t.Errorf("expected 2 periods after composing twice, got %d", len(again.Find("UK Support").Periods))
+	}
+}
+
+func TestSchedules_ComposeAction(t *testing.T) {
+	var schedules Schedules
+	if err := json.Unmarshal([]byte(composeTestSchedules), &schedules); err != nil {
+		t.Fatal(err)
+	}
+	composed, err := schedules.Compose()
+	if err != nil {
+		t.Fatal(err)
+	}
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// UK Support inherits the weekdays of NZ Office and the Sunday period of UK Office, 2018-05-07 is a Monday
+	schedule := composed.Find("UK Support")
+	tests := []struct {
+		t         time.Time
+		isRunning bool
+		expected  ScheduledAction
+	}{
+		{time.Date(2018, 5, 7, 9, 0, 0, 0, london), true, NoopAction},
+		{time.Date(2018, 5, 7, 9, 0, 0, 0, london), false, StartAction},
+		{time.Date(2018, 5, 13, 13, 0, 0, 0, london), true, NoopAction},
+		{time.Date(2018, 5, 13, 13, 0, 0, 0, london), false, StartAction},
+		{time.Date(2018, 5, 12, 11, 0, 0, 0, london), true, StopAction},
+		{time.Date(2018, 5, 12, 11, 0, 0, 0, london), false, NoopAction},
+	}
+	for i, test := range tests {
+		if actual := schedule.Action(test.t, test.isRunning); actual != test.expected {
+			t.Errorf("case %d. expected %s, but got %s for %s, isRunning: %t", i+1, test.expected, actual, test.t.Format(time.RFC1123), test.isRunning)
+		}
+	}
+}
+
+func TestSchedules_ComposeErrors(t *testing.T) {
+	tests := []struct {
+		schedules Schedules
+		expected  string
+	}{
+		{
+			Schedules{{Name: "a", Extends: "b"}},
+			"schedule 'a' extends 'b', which doesn't exist",
+		},
+		{
+			Schedules{{Name: "a", Extends: "b"}, {Name: "b", Extends: "c"}, {Name: "c", Extends: "a"}},
+			"cycle: a -> b -> c -> a",
+		},
+		{
+			Schedules{{Name: "a", Extends: "a"}},
+			"cycle: a -> a",
+		},
+		{
+			Schedules{{Name: "a"}, {Name: "b", Extends: "a", Timezone: "Mars/Olympus"}},
+			"schedule 'b'",
+		},
+		{
+			Schedules{{Name: "a"}, {Name: "a"}},
+			"more than one schedule named 'a'",
+		},
+	}
+
+	for i, test := range tests {
+		_, err := test.schedules.Compose()
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("case %d. expected an error containing '%s', got %v", i+1, test.expected, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package possum
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+)
+
+// AllRegions can be used instead of a list of region names to process every region enabled in the account
+const AllRegions = "all"
+
+// Config is the runtime configuration of possum, it's stored next to the schedules in the config table
+type Config struct {
+	Regions  []string   // The regions to process, if empty or set to "all" every enabled region will be processed
+	Accounts []*Account // The accounts to process, if empty only the account possum is deployed in will be processed
+	// Discover the accounts to process from the AWS Organization, these are processed in addition to the Accounts
+	Organization *Organization
+	Notifiers    []*NotifierConfig // Where to send notifications about changes, possum doesn't notify if empty
+	Audit        *AuditConfig      // Where to keep a log of the changes possum applies
+	Tags         *TagKeys          // The keys of the tags possum reads and writes, if empty the possum: tags are used
+	Limit        *ChangeLimit      // The most starts and stops in a run, above it possum only plans them and raises an alert
+	Policies     Policies          // Guardrails on the changes to resources with matching tags, rejected changes are reported
+}
+
+// Validate returns an error for a config that possum can't run with
+func (c *Config) Validate() error {
+	if c.Organization != nil && strings.TrimSpace(c.Organization.RoleName) == "" {
+		return errors.New("the Organization has no RoleName, possum assumes the role with this name in the discovered accounts")
+	}
+	return checkAccountNames(c.Accounts)
+}
+
+// ApplyEnv overrides the stored config with the env variables, so that the env variables take precedence
+func (c *Config) ApplyEnv(ctx context.Context) {
+	if regions := os.Getenv("REGIONS"); regions != "" {
+		c.Regions = ParseRegions(regions)
+	}
+	if table := os.Getenv("AUDIT_TABLE"); table != "" {
+		if c.Audit == nil {
+			c.Audit = &AuditConfig{}
+		}
+		c.Audit.Table = table
+	}
+	if prefix := os.Getenv("TAG_PREFIX"); prefix != "" {
+		if c.Tags == nil {
+			c.Tags = &TagKeys{}
+		}
+		c.Tags.Prefix = prefix
+	}
+	if max := os.Getenv("MAX_CHANGES"); max != "" {
+		limit, err := ParseChangeLimit(max)
+		if err != nil {
+			LoggerFrom(ctx).Warnf("ignoring MAX_CHANGES: %s", err)
+		} else {
+			c.Limit = limit
+		}
+	}
+}
+
+// AutoDiscoverRegions returns true if possum should process every region that is enabled in the account
+func (c *Config) AutoDiscoverRegions() bool {
+	if len(c.Regions) == 0 {
+		return true
+	}
+	for _, region := range c.Regions {
+		if region == AllRegions {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRegions splits a comma separated list of region names, like "ap-southeast-2, us-east-1"
+func ParseRegions(s string) []string {
+	var regions []string
+	for _, region := range strings.Split(s, ",") {
+		region = strings.TrimSpace(region)
+		if region != "" {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
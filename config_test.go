@@ -0,0 +1,129 @@
+package possum
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseRegions(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []string
+	}{
+		{"", nil},
+		{"all", []string{"all"}},
+		{"ap-southeast-2", []string{"ap-southeast-2"}},
+		{"ap-southeast-2,us-east-1", []string{"ap-southeast-2", "us-east-1"}},
+		{" ap-southeast-2 , us-east-1, ", []string{"ap-southeast-2", "us-east-1"}},
+	}
+
+	for i, test := range tests {
+		actual := ParseRegions(test.in)
+		if len(actual) != len(test.expected) {
+			t.Errorf("case %d. expected %d regions, got %d", i+1, len(test.expected), len(actual))
+			continue
+		}
+		for j := range actual {
+			if actual[j] != test.expected[j] {
+				t.Errorf("case %d. expected region %s, got %s", i+1, test.expected[j], actual[j])
+			}
+		}
+	}
+}
+
+func TestConfig_AutoDiscoverRegions(t *testing.T) {
+	tests := []struct {
+		regions  []string
+		expected bool
+	}{
+		{nil, true},
+		{[]string{AllRegions}, true},
+		{[]string{"ap-southeast-2", AllRegions}, true},
+		{[]string{"ap-southeast-2"}, false},
+		{[]string{"ap-southeast-2", "us-east-1"}, false},
+	}
+
+	for i, test := range tests {
+		cfg := &Config{Regions: test.regions}
+		if actual := cfg.AutoDiscoverRegions(); actual != test.expected {
+			t.Errorf("case %d. expected %t, got %t for %v", i+1, test.expected, actual, test.regions)
+		}
+	}
+}
+
+func TestConfig_ApplyEnv(t *testing.T) {
+	t.Setenv("REGIONS", "")
+	t.Setenv("AUDIT_TABLE", "")
+	t.Setenv("TAG_PREFIX", "acme:scheduler/")
+	t.Setenv("MAX_CHANGES", "10%")
+
+	cfg := &Config{Tags: &TagKeys{Schedule: "Schedule"}}
+	cfg.ApplyEnv(context.Background())
+	if cfg.Tags.Prefix != "acme:scheduler/" || cfg.Tags.Schedule != "Schedule" {
+		t.Errorf("expected TAG_PREFIX to set the prefix and leave the other keys, got %+v", cfg.Tags)
+	}
+
+	if cfg.Limit == nil || cfg.Limit.MaxPercent != 10 {
+		t.Errorf("expected MAX_CHANGES to set the limit, got %+v", cfg.Limit)
+	}
+
+	t.Setenv("MAX_CHANGES", "lots")
+	cfg = &Config{Limit: &ChangeLimit{Max: 50}}
+	cfg.ApplyEnv(context.Background())
+	if cfg.Limit.Max != 50 {
+		t.Errorf("expected an invalid MAX_CHANGES to leave the stored limit, got %+v", cfg.Limit)
+	}
+
+	cfg = &Config{}
+	cfg.ApplyEnv(context.Background())
+	if cfg.Tags == nil || cfg.Tags.Prefix != "acme:scheduler/" {
+		t.Errorf("expected TAG_PREFIX to set the prefix without stored tags, got %+v", cfg.Tags)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		accounts []*Account
+		org      *Organization
+		expected string
+	}{
+		{[]*Account{{ID: "1", Name: "dev"}, {ID: "2", Name: "prod"}, {ID: "3"}}, nil, ""},
+		{[]*Account{{ID: "1", Name: "dev"}, {ID: "2", Name: "dev"}}, nil, "accounts 1 and 2 are both known as 'dev'"},
+		{[]*Account{{ID: "1", Name: "2"}, {ID: "2"}}, nil, "accounts 1 and 2 are both known as '2'"},
+		{[]*Account{{ID: "1", Name: "dev"}, {ID: "1", Name: "test"}}, nil, "accounts 1 and 1 are both known as '1'"},
+		{[]*Account{{ID: "1", Name: "1"}}, nil, ""},
+		{nil, &Organization{RoleName: "possum"}, ""},
+		{nil, &Organization{}, "no RoleName"},
+		{nil, &Organization{RoleName: " ", OUs: []string{"ou-dev"}}, "no RoleName"},
+	}
+
+	for i, test := range tests {
+		err := (&Config{Accounts: test.accounts, Organization: test.org}).Validate()
+		if test.expected == "" {
+			if err != nil {
+				t.Errorf("case %d. unexpected error %s", i+1, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("case %d. expected an error containing '%s', got %v", i+1, test.expected, err)
+		}
+	}
+}
+
+func TestAccount_String(t *testing.T) {
+	tests := []struct {
+		account  *Account
+		expected string
+	}{
+		{&Account{ID: "123456789012"}, "123456789012"},
+		{&Account{ID: "123456789012", Name: "playpen"}, "playpen"},
+	}
+
+	for i, test := range tests {
+		if actual := test.account.String(); actual != test.expected {
+			t.Errorf("case %d. expected %s, got %s", i+1, test.expected, actual)
+		}
+	}
+}
@@ -4,26 +4,29 @@ import (
 	"context"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/rds"
 	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
 )
 
-func DoDB(ctx context.Context, client rdsiface.RDSAPI, ts time.Time, schedules Schedules) (Changes, error) {
-	instances, err := getDBInstances(ctx, client)
+func DoDB(ctx context.Context, client rdsiface.RDSAPI, ts time.Time, schedules Schedules, tags *TagKeys) (Changes, error) {
+	tags = tags.resolved()
+	instances, err := getDBInstances(ctx, client, tags)
 	if err != nil {
 		return nil, err
 	}
-	changes := getDBInstanceChanges(instances, ts, schedules)
+	changes := getDBInstanceChanges(ctx, instances, ts, schedules, tags)
 	err = performDBInstanceChanges(client, changes)
-	return changes, err
+	return changes, firstError(err, tagDBInstancesLastAction(ctx, client, changes, ts, tags), holdDBInstances(ctx, client, changes, tags))
 }
 
 type dbInstanceSchedule struct {
 	resource *rds.DBInstance
 	schedule string
+	tags     []*rds.Tag
 }
 
-func getDBInstances(ctx context.Context, client rdsiface.RDSAPI) ([]*dbInstanceSchedule, error) {
+func getDBInstances(ctx context.Context, client rdsiface.RDSAPI, tags *TagKeys) ([]*dbInstanceSchedule, error) {
 
 	var list []*dbInstanceSchedule
 
@@ -53,10 +56,11 @@ func getDBInstances(ctx context.Context, client rdsiface.RDSAPI) ([]*dbInstanceS
 			return list, err
 		}
 
-		if schedule := getRDSTagValue(res.TagList, scheduleTag); schedule != nil {
+		if schedule := getRDSTagValue(res.TagList, tags.Schedule); schedule != nil {
 			list = append(list, &dbInstanceSchedule{
 				resource: instance,
 				schedule: *schedule,
+				tags:     res.TagList,
 			})
 		}
 	}
@@ -65,7 +69,7 @@ func getDBInstances(ctx context.Context, client rdsiface.RDSAPI) ([]*dbInstanceS
 }
 
 // @todo extend the schemas with the db maintenance window
-func getDBInstanceChanges(list []*dbInstanceSchedule, ts time.Time, schedules Schedules) Changes {
+func getDBInstanceChanges(ctx context.Context, list []*dbInstanceSchedule, ts time.Time, schedules Schedules, tags *TagKeys) Changes {
 	const runningState = "available"
 	const stoppedState = "stopped"
 
@@ -74,54 +78,92 @@ func getDBInstanceChanges(list []*dbInstanceSchedule, ts time.Time, schedules Sc
 	for _, a := range list {
 
 		dbInstance := a.resource
+		logger := LoggerFrom(ctx).With(LogResourceType, DBInstanceResource, LogResourceID, *dbInstance.DBInstanceIdentifier, LogSchedule, a.schedule)
 
 		// skip db list that are in a transitional state
 		if *dbInstance.DBInstanceStatus != runningState && *dbInstance.DBInstanceStatus != stoppedState {
 			continue
 		}
 
-		effectiveSchedule := schedules.Find(a.schedule)
-		if effectiveSchedule == nil {
+		// protected resources are never started or stopped
+		if tagEnabled(getRDSTagValue(a.tags, tags.Protected)) {
+			continue
+		}
+
+		// someone asked possum to leave this db instance alone for now
+		if isOverridden(getRDSTagValue(a.tags, tags.OverrideUntil), ts) {
+			continue
+		}
+
+		// the tag value is either the name of a schedule or a schedule expression
+		effectiveSchedule, err := schedules.Resolve(a.schedule)
+		if err != nil {
+			changes = append(changes, invalidSchedule(logger, *dbInstance.DBInstanceIdentifier, dbInstance.DBInstanceIdentifier, DBInstanceResource, a.schedule, err, rdsTagMap(a.tags)))
 			continue
 		}
 
 		isRunning := *dbInstance.DBInstanceStatus == runningState
 		act := effectiveSchedule.Action(ts, isRunning)
+		mode := resourceMode(logger, effectiveSchedule, tags.Mode, getRDSTagValue(a.tags, tags.Mode), *dbInstance.DBInstanceIdentifier)
+		if !mode.Allows(act) {
+			act = NoopAction
+		}
+
+		var due time.Time
+		lastAction, lastActionAt := parseLastAction(getRDSTagValue(a.tags, tags.LastAction), getRDSTagValue(a.tags, tags.LastActionAt))
+		if until, ok := effectiveSchedule.ManualChange(ts, isRunning, lastAction, lastActionAt); ok && act != NoopAction {
+			act, due = HoldAction, until
+		} else if act == NoopAction && isRunning && mode.Allows(WarnAction) {
+			if stop, ok := effectiveSchedule.Warn(ts); ok {
+				act, due = WarnAction, stop
+			}
+		}
 
 		if act == NoopAction {
 			continue
 		}
 
 		changes = append(changes, Change{
-			ID:     dbInstance.DBInstanceIdentifier,
-			Name:   *dbInstance.DBInstanceIdentifier,
-			Action: act,
-			Type:   "rds",
+			ID:       dbInstance.DBInstanceIdentifier,
+			Name:     *dbInstance.DBInstanceIdentifier,
+			Action:   act,
+			Type:     DBInstanceResource,
+			Schedule: effectiveSchedule.Name,
+			Mode:     mode,
+			Due:      due,
+			arn:      aws.StringValue(dbInstance.DBInstanceArn),
+			tags:     rdsTagMap(a.tags),
 		})
 	}
 	return changes
 }
 
 func performDBInstanceChanges(client rdsiface.RDSAPI, list Changes) error {
-	for _, a := range list {
+	var firstErr error
+	for i, a := range list {
+		var err error
 		switch a.Action {
 		case StartAction:
-			_, err := client.StartDBInstance(&rds.StartDBInstanceInput{
+			_, err = client.StartDBInstance(&rds.StartDBInstanceInput{
 				DBInstanceIdentifier: a.ID,
 			})
-			if err != nil {
-				return err
-			}
 		case StopAction:
-			_, err := client.StopDBInstance(&rds.StopDBInstanceInput{
+			_, err = client.StopDBInstance(&rds.StopDBInstanceInput{
 				DBInstanceIdentifier: a.ID,
 			})
-			if err != nil {
-				return err
-			}
 		}
+		firstErr = markFailed(list, []int{i}, err, firstErr)
 	}
-	return nil
+	return firstErr
+}
+
+// rdsTagMap returns the tags by key
+func rdsTagMap(tags []*rds.Tag) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		m[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return m
 }
 
 // helper to get a specific value out of rds tags
@@ -32,10 +32,10 @@ func TestGetDBInstances(t *testing.T) {
 			client.describeDBInstancesResult = []*rds.DBInstance{test.instance}
 		}
 		if test.hasSchema {
-			client.listTagsForResource = []*rds.Tag{{Key: aws.String(scheduleTag), Value: aws.String("value")}}
+			client.listTagsForResource = []*rds.Tag{{Key: aws.String(defaultTags.Schedule), Value: aws.String("value")}}
 		}
 
-		list, err := getDBInstances(context.Background(), client)
+		list, err := getDBInstances(context.Background(), client, defaultTags)
 		if err != nil {
 			t.Error(err)
 			continue
@@ -112,7 +112,7 @@ func TestGetDBInstanceChanges(t *testing.T) {
 			schedule: test.schedule,
 		}
 		list := []*dbInstanceSchedule{action}
-		changes := getDBInstanceChanges(list, chkTime, schedules)
+		changes := getDBInstanceChanges(context.Background(), list, chkTime, schedules, defaultTags)
 		for _, change := range changes {
 			if change.Action != test.expected {
 				t.Errorf("case %d. expected %s, got %s", i+1, test.expected, change.Action)
@@ -161,6 +161,7 @@ type mockRDSClient struct {
 	listTagsForResource       []*rds.Tag
 	startedInstances          int
 	stoppedInstances          int
+	addTagsToResourceInput    []*rds.AddTagsToResourceInput
 }
 
 func (m *mockRDSClient) DescribeDBInstancesPagesWithContext(ctx aws.Context, input *rds.DescribeDBInstancesInput, fnc func(*rds.DescribeDBInstancesOutput, bool) bool, options ...request.Option) error {
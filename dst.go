@@ -0,0 +1,53 @@
+package possum
+
+import "time"
+
+// wallClock returns the instant on the day of t, in the location of t, at which the local clock shows the minutes
+// after midnight. Around daylight saving changes:
+//   - a time that is skipped when the clocks go forward is the moment they go forward, e.g. a start at 02:30 on the
+//     night the clocks jump from 02:00 to 03:00 happens at 03:00
+//   - a time that happens twice when the clocks go back is its first occurrence, so that a period starts and stops
+//     only once on that night
+func wallClock(t time.Time, minutes int) time.Time {
+	y, m, d := t.Date()
+	loc := t.Location()
+	expected := time.Date(y, m, d, 0, minutes, 0, 0, time.UTC)
+
+	at := time.Date(y, m, d, 0, minutes, 0, 0, loc)
+	if !sameWallClock(at, expected) {
+		// the time doesn't exist, find the first minute after the clocks went forward, time.Date may have picked a
+		// time on either side of the change
+		for wallClockBefore(at, expected) {
+			at = at.Add(time.Minute)
+		}
+		for prev := at.Add(-time.Minute); !wallClockBefore(prev, expected); prev = prev.Add(-time.Minute) {
+			at = prev
+		}
+		return at
+	}
+
+	// the clocks go back by 30 minutes in some places, and by two hours in others
+	for _, shift := range []time.Duration{30 * time.Minute, time.Hour, 2 * time.Hour} {
+		if earlier := at.Add(-shift); sameWallClock(earlier, expected) {
+			at = earlier
+		}
+	}
+	return at
+}
+
+// sameWallClock returns true if the local date and time of t are the date and time of the UTC expected
+func sameWallClock(t, expected time.Time) bool {
+	return localAsUTC(t).Equal(expected)
+}
+
+func wallClockBefore(t, expected time.Time) bool {
+	return localAsUTC(t).Before(expected)
+}
+
+func localAsUTC(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func (d *KitchenTime) minutes() int {
+	return d.Hour*60 + d.Minute
+}
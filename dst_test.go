@@ -0,0 +1,138 @@
+package possum
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWallClock(t *testing.T) {
+	tests := []struct {
+		zone     string
+		day      string
+		clock    string
+		expected string
+	}{
+		// a normal day
+		{"Pacific/Auckland", "2018-05-07", "08:00", "2018-05-07T08:00:00+12:00"},
+		// clocks go forward 02:00 -> 03:00
+		{"Pacific/Auckland", "2018-09-30", "02:30", "2018-09-30T03:00:00+13:00"},
+		{"Pacific/Auckland", "2018-09-30", "01:59", "2018-09-30T01:59:00+12:00"},
+		{"Pacific/Auckland", "2018-09-30", "03:00", "2018-09-30T03:00:00+13:00"},
+		// clocks go back 03:00 -> 02:00
+		{"Pacific/Auckland", "2018-04-01", "02:30", "2018-04-01T02:30:00+13:00"},
+		{"Pacific/Auckland", "2018-04-01", "03:00", "2018-04-01T03:00:00+12:00"},
+		{"Europe/London", "2018-03-25", "01:15", "2018-03-25T02:00:00+01:00"},
+		{"Europe/London", "2018-10-28", "01:15", "2018-10-28T01:15:00+01:00"},
+		{"America/New_York", "2018-03-11", "02:59", "2018-03-11T03:00:00-04:00"},
+		{"America/New_York", "2018-11-04", "01:30", "2018-11-04T01:30:00-04:00"},
+		// Lord Howe Island changes by 30 minutes
+		{"Australia/Lord_Howe", "2018-10-07", "02:15", "2018-10-07T02:30:00+11:00"},
+		{"Australia/Lord_Howe", "2018-04-01", "01:45", "2018-04-01T01:45:00+11:00"},
+		// the minute after 23:59 is the next day
+		{"Pacific/Auckland", "2018-05-07", "24:00", "2018-05-08T00:00:00+12:00"},
+	}
+
+	for i, test := range tests {
+		loc, err := time.LoadLocation(test.zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		day, err := time.ParseInLocation("2006-01-02", test.day, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock, err := NewKitchenTime(test.clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := wallClock(day.Add(12*time.Hour), clock.minutes())
+		if actual.Format(time.RFC3339) != test.expected {
+			t.Errorf("case %d. expected %s at %s in %s to be %s, got %s", i+1, test.day, test.clock, test.zone, test.expected, actual.Format(time.RFC3339))
+		}
+	}
+}
+
+// TestSchedule_DST runs a schedule every minute through a day with a daylight saving change, and checks that resources
+// are started and stopped once, at the expected times
+func TestSchedule_DST(t *testing.T) {
+	tests := []struct {
+		zone        string
+		day         string
+		start, stop string
+		started     string // empty if the resource should not be started
+		stopped     string
+	}{
+		// clocks go forward 02:00 -> 03:00
+		{"Pacific/Auckland", "2018-09-30", "02:30", "06:00", "2018-09-30T03:00:00+13:00", "2018-09-30T06:01:00+13:00"},
+		{"Pacific/Auckland", "2018-09-30", "00:00", "02:30", "2018-09-30T00:00:00+12:00", "2018-09-30T03:00:00+13:00"},
+		{"Europe/London", "2018-03-25", "01:30", "09:00", "2018-03-25T02:00:00+01:00", "2018-03-25T09:01:00+01:00"},
+		{"Australia/Lord_Howe", "2018-10-07", "02:15", "04:00", "2018-10-07T02:30:00+11:00", "2018-10-07T04:01:00+11:00"},
+		// a period that is skipped entirely doesn't run
+		{"America/New_York", "2018-03-11", "02:00", "02:30", "", ""},
+		// clocks go back 03:00 -> 02:00, the repeated times don't start or stop anything again
+		{"Pacific/Auckland", "2018-04-01", "02:30", "05:00", "2018-04-01T02:30:00+13:00", "2018-04-01T05:01:00+12:00"},
+		{"Pacific/Auckland", "2018-04-01", "00:00", "02:30", "2018-04-01T00:00:00+13:00", "2018-04-01T02:31:00+13:00"},
+		{"Europe/London", "2018-10-28", "01:00", "01:30", "2018-10-28T01:00:00+01:00", "2018-10-28T01:31:00+01:00"},
+		{"America/New_York", "2018-11-04", "08:00", "18:00", "2018-11-04T08:00:00-05:00", "2018-11-04T18:01:00-05:00"},
+		{"Australia/Lord_Howe", "2018-04-01", "01:45", "03:00", "2018-04-01T01:45:00+11:00", "2018-04-01T03:01:00+10:30"},
+	}
+
+	for i, test := range tests {
+		loc, err := time.LoadLocation(test.zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		day, err := time.ParseInLocation("2006-01-02", test.day, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		period, err := NewPeriod(test.start, test.stop, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schedule := NewSchedule("DST")
+		if err := schedule.AddPeriod(test.zone, period); err != nil {
+			t.Fatal(err)
+		}
+
+		var started, stopped []string
+		var running bool
+		from := day.Add(-time.Hour)
+		to := day.AddDate(0, 0, 1)
+		for tick := from; tick.Before(to); tick = tick.Add(time.Minute) {
+			switch schedule.Action(tick, running) {
+			case StartAction:
+				started = append(started, tick.In(loc).Format(time.RFC3339))
+				running = true
+			case StopAction:
+				stopped = append(stopped, tick.In(loc).Format(time.RFC3339))
+				running = false
+			}
+		}
+
+		if test.started == "" {
+			if len(started) != 0 || len(stopped) != 0 {
+				t.Errorf("case %d. expected nothing to happen, got starts %v and stops %v", i+1, started, stopped)
+			}
+			if next, ok := schedule.NextStart(from); ok && next.Before(to) {
+				t.Errorf("case %d. expected no start on %s, got %s", i+1, test.day, next)
+			}
+			continue
+		}
+		if len(started) != 1 || started[0] != test.started {
+			t.Errorf("case %d. expected one start at %s, got %v", i+1, test.started, started)
+		}
+		if len(stopped) != 1 || stopped[0] != test.stopped {
+			t.Errorf("case %d. expected one stop at %s, got %v", i+1, test.stopped, stopped)
+		}
+
+		// the next start and stop agree with the actions
+		if next, ok := schedule.NextStart(from); !ok || next.In(loc).Format(time.RFC3339) != test.started {
+			t.Errorf("case %d. expected the next start to be %s, got %s", i+1, test.started, next.In(loc).Format(time.RFC3339))
+		}
+		if next, ok := schedule.NextStop(from); !ok || next.In(loc).Format(time.RFC3339) != test.stopped {
+			t.Errorf("case %d. expected the next stop to be %s, got %s", i+1, test.stopped, next.In(loc).Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package possum
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// the timezone of a schedule expression that doesn't name one
+const defaultExpressionTimezone = "UTC"
+
+var timeRangeRe = regexp.MustCompile(`^(\d{1,2}:\d{2})-(\d{1,2}:\d{2})$`)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Resolve returns the schedule with the name, or else parses the value as a schedule expression, so that a resource can
+// be tagged with either
+func (s Schedules) Resolve(value string) (*Schedule, error) {
+	if schedule := s.Find(value); schedule != nil {
+		return schedule, nil
+	}
+	schedule, err := ParseScheduleExpression(value)
+	if err != nil {
+		return nil, fmt.Errorf("could not find schedule '%s' and it's not a valid schedule expression: %w", value, err)
+	}
+	return schedule, nil
+}
+
+// ParseScheduleExpression parses a compact schedule like "Mon-Fri 08:00-18:00 Pacific/Auckland" into a schedule named
+// after the expression. Every time range is a period on the days before it, all days if there are none, e.g.
+// "Mon-Fri 08:00-18:00 Sat 10:00-14:00". Days are names or ranges joined with "," or "+", or one of weekdays, weekends
+// and daily. The optional timezone comes last and defaults to UTC.
+func ParseScheduleExpression(expr string) (*Schedule, error) {
+	tokens := strings.Fields(strings.ReplaceAll(expr, ";", " "))
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty schedule expression")
+	}
+
+	var hasTimeRange bool
+	for _, token := range tokens {
+		hasTimeRange = hasTimeRange || timeRangeRe.MatchString(token)
+	}
+	if !hasTimeRange {
+		return nil, fmt.Errorf("'%s' has no time range, e.g. 08:00-18:00", expr)
+	}
+
+	timezone := defaultExpressionTimezone
+	if last := tokens[len(tokens)-1]; !timeRangeRe.MatchString(last) {
+		if _, err := parseWeekdays(last); err != nil {
+			if _, err := time.LoadLocation(last); err != nil {
+				return nil, fmt.Errorf("unknown timezone '%s'", last)
+			}
+			timezone = last
+			tokens = tokens[:len(tokens)-1]
+		}
+	}
+
+	schedule := NewSchedule(expr)
+	var days []time.Weekday
+	var pendingDays bool
+	for _, token := range tokens {
+		if m := timeRangeRe.FindStringSubmatch(token); m != nil {
+			period, err := NewPeriod(m[1], m[2], days)
+			if err != nil {
+				return nil, err
+			}
+			if err := validateKitchenTimes(period.StartTime, period.StopTime); err != nil {
+				return nil, err
+			}
+			if err := schedule.AddPeriod(timezone, period); err != nil {
+				return nil, err
+			}
+			days, pendingDays = nil, false
+			continue
+		}
+
+		d, err := parseWeekdays(token)
+		if err != nil {
+			return nil, err
+		}
+		days, pendingDays = append(days, d...), true
+	}
+
+	if pendingDays {
+		return nil, fmt.Errorf("the days at the end of '%s' need a time range, e.g. 08:00-18:00", expr)
+	}
+	return schedule, nil
+}
+
+// parseWeekdays parses "Mon-Fri", "Mon,Wed,Fri", "Sat+Sun" or one of the keywords weekdays, weekends and daily
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	switch strings.ToLower(s) {
+	case "weekdays":
+		return []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, nil
+	case "weekends":
+		return []time.Weekday{time.Saturday, time.Sunday}, nil
+	case "daily":
+		return AllWeekdays(), nil
+	}
+
+	var days []time.Weekday
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '+' }) {
+		bounds := strings.SplitN(part, "-", 2)
+		from, err := parseWeekday(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(bounds) == 1 {
+			days = append(days, from)
+			continue
+		}
+		to, err := parseWeekday(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		// ranges can wrap around the end of the week, e.g. Fri-Mon
+		for d := from; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == to {
+				break
+			}
+		}
+	}
+	if len(days) == 0 {
+		return nil, fmt.Errorf("'%s' is not a day or a time range", s)
+	}
+	return days, nil
+}
+
+// parseWeekday accepts full day names and their first three letters in any case
+func parseWeekday(s string) (time.Weekday, error) {
+	if len(s) >= 3 {
+		if d, ok := weekdayNames[strings.ToLower(s[:3])]; ok && strings.HasPrefix(strings.ToLower(d.String()), strings.ToLower(s)) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("'%s' is not a day or a time range", s)
+}
+
+func validateKitchenTimes(times ...*KitchenTime) error {
+	for _, t := range times {
+		if t.Hour < 0 || t.Hour > 23 || t.Minute < 0 || t.Minute > 59 {
+			return fmt.Errorf("'%s' is not a valid time", t)
+		}
+	}
+	return nil
+}
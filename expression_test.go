@@ -0,0 +1,115 @@
+package possum
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestParseScheduleExpression(t *testing.T) {
+	tests := []struct {
+		expr     string
+		timezone string
+		periods  []string
+	}{
+		{"Mon-Fri 08:00-18:00 Pacific/Auckland", "Pacific/Auckland", []string{"08:00-18:00 [Monday, Tuesday, Wednesday, Thursday, Friday]"}},
+		{"08:00-18:00", "UTC", []string{"08:00-18:00"}},
+		{"weekdays 7:30-19:00 Europe/London", "Europe/London", []string{"07:30-19:00 [Monday, Tuesday, Wednesday, Thursday, Friday]"}},
+		{"Mon,Wed+friday 09:00-17:00", "UTC", []string{"09:00-17:00 [Monday, Wednesday, Friday]"}},
+		{"Fri-Mon 10:00-14:00", "UTC", []string{"10:00-14:00 [Friday, Saturday, Sunday, Monday]"}},
+		{"Mon-Fri 08:00-18:00 Sat 10:00-14:00 America/New_York", "America/New_York", []string{
+			"08:00-18:00 [Monday, Tuesday, Wednesday, Thursday, Friday]",
+			"10:00-14:00 [Saturday]",
+		}},
+		{"Mon-Fri 08:00-18:00; weekends 10:00-12:00", "UTC", []string{
+			"08:00-18:00 [Monday, Tuesday, Wednesday, Thursday, Friday]",
+			"10:00-12:00 [Saturday, Sunday]",
+		}},
+	}
+
+	for i, test := range tests {
+		schedule, err := ParseScheduleExpression(test.expr)
+		if err != nil {
+			t.Errorf("case %d. %s", i+1, err)
+			continue
+		}
+		if schedule.Name != test.expr {
+			t.Errorf("case %d. expected the schedule to be named after the expression, got '%s'", i+1, schedule.Name)
+		}
+		if len(schedule.Periods) != len(test.periods) {
+			t.Errorf("case %d. expected %d periods, got %d", i+1, len(test.periods), len(schedule.Periods))
+			continue
+		}
+		for j, period := range schedule.Periods {
+			if period.String() != test.periods[j] {
+				t.Errorf("case %d. expected period %s, got %s", i+1, test.periods[j], period)
+			}
+			if period.Location.String() != test.timezone {
+				t.Errorf("case %d. expected timezone %s, got %s", i+1, test.timezone, period.Location)
+			}
+		}
+	}
+}
+
+func TestParseScheduleExpression_Errors(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected string
+	}{
+		{"", "empty"},
+		{"OfficeHours", "no time range"},
+		{"Mon-Fri 08:00-18:00 Mars/Olympus", "unknown timezone"},
+		{"Mon-Fry 08:00-18:00", "'Fry' is not a day"},
+		{"Mon-Fri 08:00-25:00", "not a valid time"},
+		{"08:00-18:00 Sat", "need a time range"},
+	}
+
+	for i, test := range tests {
+		_, err := ParseScheduleExpression(test.expr)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("case %d. expected an error containing '%s', got %v", i+1, test.expected, err)
+		}
+	}
+}
+
+func TestSchedules_Resolve(t *testing.T) {
+	// a named schedule wins over an expression
+	named := NewSchedule("08:00-18:00")
+	schedules := Schedules{named}
+	if s, err := schedules.Resolve("08:00-18:00"); err != nil || s != named {
+		t.Errorf("expected the named schedule")
+	}
+	if s, err := schedules.Resolve("Mon-Fri 08:00-18:00"); err != nil || s == named {
+		t.Errorf("expected an ad-hoc schedule")
+	}
+	if _, err := schedules.Resolve("OfficeHours"); err == nil || !strings.Contains(err.Error(), "could not find schedule 'OfficeHours'") {
+		t.Errorf("expected an error for an unknown schedule name, got %v", err)
+	}
+}
+
+func TestGetInstanceChanges_Expression(t *testing.T) {
+	list := makeInstanceSchedule("a", "Mon-Fri 08:00-18:00 UTC", ec2.InstanceStateNameRunning, false)
+
+	changes := getInstanceChanges(context.Background(), list, time.Date(2018, 5, 7, 22, 0, 0, 0, time.UTC), nil, defaultTags)
+	if len(changes) != 1 || changes[0].Action != StopAction {
+		t.Fatalf("expected the instance to be stopped by the schedule expression")
+	}
+	if changes[0].Schedule != "Mon-Fri 08:00-18:00 UTC" {
+		t.Errorf("expected the change to name the expression, got '%s'", changes[0].Schedule)
+	}
+
+	list = makeInstanceSchedule("a", "Mon-Fri 08:00-18:00 Nowhere", ec2.InstanceStateNameRunning, false)
+	changes = getInstanceChanges(context.Background(), list, time.Date(2018, 5, 7, 22, 0, 0, 0, time.UTC), nil, defaultTags)
+	if len(changes) != 1 || changes[0].Action != NoopAction {
+		t.Fatalf("expected an invalid expression to leave the instance alone, got %v", changes)
+	}
+	if !strings.Contains(changes[0].Error, "Nowhere") {
+		t.Errorf("expected the change to carry the error of the expression, got '%s'", changes[0].Error)
+	}
+	if changes[0].Schedule != "Mon-Fri 08:00-18:00 Nowhere" {
+		t.Errorf("expected the change to name the expression, got '%s'", changes[0].Schedule)
+	}
+}
@@ -6,6 +6,7 @@ require (
 	github.com/aws/aws-lambda-go v1.41.0
 	github.com/aws/aws-sdk-go v1.45.20
 	github.com/slack-go/slack v0.12.3
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
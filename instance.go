@@ -2,7 +2,6 @@ package possum
 
 import (
 	"context"
-	"log"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -10,16 +9,15 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
-const autoScalingGroupTag = "aws:autoscaling:groupName"
-
-func DoInstances(ctx context.Context, client ec2iface.EC2API, ts time.Time, schedules Schedules) (Changes, error) {
-	instances, err := getInstances(ctx, client)
+func DoInstances(ctx context.Context, client ec2iface.EC2API, ts time.Time, schedules Schedules, tags *TagKeys) (Changes, error) {
+	tags = tags.resolved()
+	instances, err := getInstances(ctx, client, tags)
 	if err != nil {
 		return nil, err
 	}
-	changes := getInstanceChanges(instances, ts, schedules)
+	changes := getInstanceChanges(ctx, instances, ts, schedules, tags)
 	err = performInstanceChanges(ctx, client, changes)
-	return changes, err
+	return changes, firstError(err, tagInstancesLastAction(ctx, client, changes, ts, tags), holdInstances(ctx, client, changes, tags))
 }
 
 type instanceSchedule struct {
@@ -27,21 +25,21 @@ type instanceSchedule struct {
 	schedule string
 }
 
-func getInstances(ctx context.Context, client ec2iface.EC2API) ([]*instanceSchedule, error) {
+func getInstances(ctx context.Context, client ec2iface.EC2API, tags *TagKeys) ([]*instanceSchedule, error) {
 	var list []*instanceSchedule
 	params := &ec2.DescribeInstancesInput{
 		Filters: []*ec2.Filter{
-			{Name: aws.String("tag-key"), Values: []*string{aws.String(scheduleTag)}},
+			{Name: aws.String("tag-key"), Values: []*string{aws.String(tags.Schedule)}},
 		},
 	}
 	err := client.DescribeInstancesPagesWithContext(ctx, params, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
 		for _, reservation := range page.Reservations {
 			for _, inst := range reservation.Instances {
-				asgName := getEC2TagValue(inst.Tags, autoScalingGroupTag)
+				asgName := getEC2TagValue(inst.Tags, tags.AutoScalingGroup)
 				if asgName != nil {
 					continue
 				}
-				if schedule := getEC2TagValue(inst.Tags, scheduleTag); schedule != nil {
+				if schedule := getEC2TagValue(inst.Tags, tags.Schedule); schedule != nil {
 					list = append(list, &instanceSchedule{resource: inst, schedule: *schedule})
 
 				}
@@ -53,11 +51,13 @@ func getInstances(ctx context.Context, client ec2iface.EC2API) ([]*instanceSched
 	return list, err
 }
 
-func getInstanceChanges(list []*instanceSchedule, ts time.Time, schedules Schedules) Changes {
+func getInstanceChanges(ctx context.Context, list []*instanceSchedule, ts time.Time, schedules Schedules, tags *TagKeys) Changes {
 
 	var changes Changes
 
 	for _, a := range list {
+		logger := LoggerFrom(ctx).With(LogResourceType, InstanceResource, LogResourceID, *a.resource.InstanceId, LogSchedule, a.schedule)
+
 		// skip instance that are in a transitional state
 		if *a.resource.State.Name != ec2.InstanceStateNameStopped && *a.resource.State.Name != ec2.InstanceStateNameRunning {
 			continue
@@ -65,28 +65,58 @@ func getInstanceChanges(list []*instanceSchedule, ts time.Time, schedules Schedu
 
 		// check if instance is a spot changes, they cannot be stopped by ordinary means
 		if a.resource.InstanceLifecycle != nil && *a.resource.InstanceLifecycle == "spot" {
-			log.Printf("INFO possum doesn't support schedules on spot instances like '%s'", *a.resource.InstanceId)
+			logger.Infof("possum doesn't support schedules on spot instances like '%s'", *a.resource.InstanceId)
+			continue
+		}
+
+		// protected resources are never started or stopped
+		if tagEnabled(getEC2TagValue(a.resource.Tags, tags.Protected)) {
+			continue
+		}
+
+		// someone asked possum to leave this instance alone for now
+		if isOverridden(getEC2TagValue(a.resource.Tags, tags.OverrideUntil), ts) {
 			continue
 		}
 
 		// Try to find the period, warn if it doesn't exist
-		effectiveSchedule := schedules.Find(a.schedule)
-		if effectiveSchedule == nil {
-			log.Printf("INFO could not find schedule '%s' for '%s'", a.schedule, *getInstanceName(a.resource))
+		// the tag value is either the name of a schedule or a schedule expression
+		effectiveSchedule, err := schedules.Resolve(a.schedule)
+		if err != nil {
+			changes = append(changes, invalidSchedule(logger, *getInstanceName(a.resource), a.resource.InstanceId, InstanceResource, a.schedule, err, ec2TagMap(a.resource.Tags)))
 			continue
 		}
 
 		isRunning := *a.resource.State.Name == ec2.InstanceStateNameRunning
 		action := effectiveSchedule.Action(ts, isRunning)
+		mode := resourceMode(logger, effectiveSchedule, tags.Mode, getEC2TagValue(a.resource.Tags, tags.Mode), *getInstanceName(a.resource))
+		if !mode.Allows(action) {
+			action = NoopAction
+		}
+
+		var due time.Time
+		lastAction, lastActionAt := parseLastAction(getEC2TagValue(a.resource.Tags, tags.LastAction), getEC2TagValue(a.resource.Tags, tags.LastActionAt))
+		if until, ok := effectiveSchedule.ManualChange(ts, isRunning, lastAction, lastActionAt); ok && action != NoopAction {
+			action, due = HoldAction, until
+		} else if action == NoopAction && isRunning && mode.Allows(WarnAction) {
+			if stop, ok := effectiveSchedule.Warn(ts); ok {
+				action, due = WarnAction, stop
+			}
+		}
+
 		if action == NoopAction {
 			continue
 		}
 
 		changes = append(changes, Change{
-			Name:   *getInstanceName(a.resource),
-			ID:     a.resource.InstanceId,
-			Action: action,
-			Type:   "instance",
+			Name:     *getInstanceName(a.resource),
+			ID:       a.resource.InstanceId,
+			Action:   action,
+			Type:     InstanceResource,
+			Schedule: effectiveSchedule.Name,
+			Mode:     mode,
+			Due:      due,
+			tags:     ec2TagMap(a.resource.Tags),
 		})
 	}
 	return changes
@@ -95,29 +125,30 @@ func getInstanceChanges(list []*instanceSchedule, ts time.Time, schedules Schedu
 func performInstanceChanges(ctx context.Context, client ec2iface.EC2API, list Changes) error {
 
 	// Since ec2 instances can be started in bulk, we sort out the changes into a start and stop list
-	var toStart []*string
-	var toStop []*string
-	for _, a := range list {
+	var toStart, toStop []int
+	for i, a := range list {
 		switch a.Action {
 		case StartAction:
-			toStart = append(toStart, a.ID)
+			toStart = append(toStart, i)
 		case StopAction:
-			toStop = append(toStop, a.ID)
+			toStop = append(toStop, i)
 		}
 	}
 
-	var err error
+	var firstErr error
 	if len(toStart) > 0 {
-		_, err = client.StartInstancesWithContext(ctx, &ec2.StartInstancesInput{
-			InstanceIds: toStart,
+		_, err := client.StartInstancesWithContext(ctx, &ec2.StartInstancesInput{
+			InstanceIds: changeIDs(list, toStart),
 		})
+		firstErr = markFailed(list, toStart, err, firstErr)
 	}
 	if len(toStop) > 0 {
-		_, err = client.StopInstancesWithContext(ctx, &ec2.StopInstancesInput{
-			InstanceIds: toStop,
+		_, err := client.StopInstancesWithContext(ctx, &ec2.StopInstancesInput{
+			InstanceIds: changeIDs(list, toStop),
 		})
+		firstErr = markFailed(list, toStop, err, firstErr)
 	}
-	return err
+	return firstErr
 }
 
 func getInstanceName(instance *ec2.Instance) *string {
@@ -128,6 +159,15 @@ func getInstanceName(instance *ec2.Instance) *string {
 	return instanceName
 }
 
+// ec2TagMap returns the tags by key
+func ec2TagMap(tags []*ec2.Tag) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		m[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return m
+}
+
 // helper to get a specific tag value out of ec2 resource tags
 func getEC2TagValue(tags []*ec2.Tag, keyName string) *string {
 	for _, tag := range tags {
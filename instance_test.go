@@ -14,10 +14,10 @@ import (
 func TestGetEC2Instances(t *testing.T) {
 
 	output := []*ec2.Instance{
-		{InstanceId: aws.String("i-abcdefgh1"), Tags: makeEc2Tags(scheduleTag, "SomeSchedule")},
-		{InstanceId: aws.String("i-abcdefgh2"), Tags: makeEc2Tags(scheduleTag, "AnotherSchedule")},
+		{InstanceId: aws.String("i-abcdefgh1"), Tags: makeEc2Tags(defaultTags.Schedule, "SomeSchedule")},
+		{InstanceId: aws.String("i-abcdefgh2"), Tags: makeEc2Tags(defaultTags.Schedule, "AnotherSchedule")},
 		{InstanceId: aws.String("i-abcdefgh3")}, // no schedueTag, should not show up
-		{InstanceId: aws.String("i-abcdefgh3"), Tags: makeEc2Tags(scheduleTag, "SomeSchedule", autoScalingGroupTag, "some-asg-group")},
+		{InstanceId: aws.String("i-abcdefgh3"), Tags: makeEc2Tags(defaultTags.Schedule, "SomeSchedule", defaultTags.AutoScalingGroup, "some-asg-group")},
 	}
 
 	expectedInstances := len(output) - 2
@@ -26,7 +26,7 @@ func TestGetEC2Instances(t *testing.T) {
 
 	ctx := context.Background()
 	var changes []*instanceSchedule
-	changes, err := getInstances(ctx, client)
+	changes, err := getInstances(ctx, client, defaultTags)
 	if err != nil {
 		t.Error(err)
 		return
@@ -79,7 +79,7 @@ func TestGetInstanceChanges(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		changes := getInstanceChanges(test.changes, chkTime, schedules)
+		changes := getInstanceChanges(context.Background(), test.changes, chkTime, schedules, defaultTags)
 		for _, change := range changes {
 			if change.Action != test.expected {
 				t.Errorf("Expected change '%s', but got change '%s'", test.expected, change.Action)
@@ -136,6 +136,7 @@ type mockEC2Client struct {
 	describeInstanceResult []*ec2.Instance
 	startInstances         []*string
 	stopInstances          []*string
+	createTagsInput        []*ec2.CreateTagsInput
 }
 
 func (m *mockEC2Client) DescribeInstancesPagesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, fnc func(*ec2.DescribeInstancesOutput, bool) bool, options ...request.Option) error {
@@ -165,7 +166,7 @@ func makeInstanceSchedule(id string, scheduleName string, stateName string, isSp
 			Name: aws.String(stateName),
 		},
 		Tags: []*ec2.Tag{
-			{Key: aws.String(scheduleTag), Value: aws.String(scheduleName)},
+			{Key: aws.String(defaultTags.Schedule), Value: aws.String(scheduleName)},
 		},
 	}
 	if isSpot {
@@ -186,3 +187,43 @@ func makeEc2Tags(val ...string) []*ec2.Tag {
 
 	return res
 }
+
+func TestGetInstanceChanges_Mode(t *testing.T) {
+	office := NewSchedule("OfficeHours")
+	p, _ := NewPeriod("08:00", "18:00", nil)
+	office.AddPeriod(time.Local.String(), p)
+	night := newWeekday(time.Monday, 22, 0)
+	day := newWeekday(time.Monday, 12, 0)
+
+	tests := []struct {
+		scheduleMode Mode
+		tag          string
+		state        string
+		ts           time.Time
+		expected     ScheduledAction
+	}{
+		{"", "", ec2.InstanceStateNameRunning, night, StopAction},
+		{StartOnlyMode, "", ec2.InstanceStateNameRunning, night, NoopAction},
+		{StartOnlyMode, "", ec2.InstanceStateNameStopped, day, StartAction},
+		{StopOnlyMode, "", ec2.InstanceStateNameStopped, day, NoopAction},
+		{StopOnlyMode, "", ec2.InstanceStateNameRunning, night, StopAction},
+		{StopOnlyMode, "start-only", ec2.InstanceStateNameRunning, night, NoopAction}, // the tag wins
+		{StopOnlyMode, "sometimes", ec2.InstanceStateNameStopped, day, NoopAction},    // an invalid tag is ignored
+	}
+
+	for i, test := range tests {
+		office.Mode = test.scheduleMode
+		list := makeInstanceSchedule("a", office.Name, test.state, false)
+		if test.tag != "" {
+			list[0].resource.Tags = append(list[0].resource.Tags, &ec2.Tag{Key: aws.String(defaultTags.Mode), Value: aws.String(test.tag)})
+		}
+
+		actual := NoopAction
+		if changes := getInstanceChanges(context.Background(), list, test.ts, Schedules{office}, defaultTags); len(changes) > 0 {
+			actual = changes[0].Action
+		}
+		if actual != test.expected {
+			t.Errorf("case %d. expected %s, got %s", i+1, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package possum
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+)
+
+// auto scaling accepts up to 25 tags in a single CreateOrUpdateTags call
+const asgTagBatchSize = 25
+
+// tagInstancesLastAction tags the instances that were started or stopped, with a CreateTags call per action
+func tagInstancesLastAction(ctx context.Context, client ec2iface.EC2API, changes Changes, ts time.Time, tags *TagKeys) error {
+	byAction := make(map[ScheduledAction][]*string)
+	for _, change := range applied(changes) {
+		byAction[change.Action] = append(byAction[change.Action], change.ID)
+	}
+
+	for _, action := range []ScheduledAction{StartAction, StopAction} {
+		if len(byAction[action]) == 0 {
+			continue
+		}
+		_, err := client.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+			Resources: byAction[action],
+			Tags: []*ec2.Tag{
+				{Key: aws.String(tags.LastAction), Value: aws.String(action.String())},
+				{Key: aws.String(tags.LastActionAt), Value: aws.String(ts.UTC().Format(time.RFC3339))},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagASGLastAction tags the auto scaling groups that were started or stopped, in batches of asgTagBatchSize tags
+func tagASGLastAction(ctx context.Context, client autoscalingiface.AutoScalingAPI, changes Changes, ts time.Time, keys *TagKeys) error {
+	var tags []*autoscaling.Tag
+	for _, change := range applied(changes) {
+		for _, tag := range [][2]string{{keys.LastAction, change.Action.String()}, {keys.LastActionAt, ts.UTC().Format(time.RFC3339)}} {
+			tags = append(tags, &autoscaling.Tag{
+				ResourceId:        change.ID,
+				ResourceType:      aws.String("auto-scaling-group"),
+				Key:               aws.String(tag[0]),
+				Value:             aws.String(tag[1]),
+				PropagateAtLaunch: aws.Bool(false),
+			})
+		}
+	}
+
+	for start := 0; start < len(tags); start += asgTagBatchSize {
+		end := start + asgTagBatchSize
+		if end > len(tags) {
+			end = len(tags)
+		}
+		if _, err := client.CreateOrUpdateTagsWithContext(ctx, &autoscaling.CreateOrUpdateTagsInput{Tags: tags[start:end]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagDBInstancesLastAction tags the db instances that were started or stopped, rds can only tag one resource per call
+func tagDBInstancesLastAction(ctx context.Context, client rdsiface.RDSAPI, changes Changes, ts time.Time, tags *TagKeys) error {
+	for _, change := range applied(changes) {
+		arn := change.arn
+		if arn == "" {
+			var err error
+			if arn, err = getDBInstanceARN(ctx, client, change.ID); err != nil {
+				return err
+			}
+		}
+
+		_, err := client.AddTagsToResourceWithContext(ctx, &rds.AddTagsToResourceInput{
+			ResourceName: aws.String(arn),
+			Tags: []*rds.Tag{
+				{Key: aws.String(tags.LastAction), Value: aws.String(change.Action.String())},
+				{Key: aws.String(tags.LastActionAt), Value: aws.String(ts.UTC().Format(time.RFC3339))},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applied returns the starts and stops that didn't fail
+func applied(changes Changes) Changes {
+	var list Changes
+	for _, change := range changes {
+		if (change.Action == StartAction || change.Action == StopAction) && change.Error == "" {
+			list = append(list, change)
+		}
+	}
+	return list
+}
+
+// parseLastAction returns the action and time from the last action tags, the time is zero if the tags are missing or
+// can't be parsed
+func parseLastAction(action, at *string) (ScheduledAction, time.Time) {
+	if action == nil || at == nil {
+		return NoopAction, time.Time{}
+	}
+	var a ScheduledAction
+	if err := a.UnmarshalText([]byte(*action)); err != nil {
+		return NoopAction, time.Time{}
+	}
+	ts, err := time.Parse(time.RFC3339, *at)
+	if err != nil {
+		return NoopAction, time.Time{}
+	}
+	return a, ts
+}
+
+// firstError returns the first error that isn't nil, so that steps after the first failing one are still run
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package possum
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+func TestTagInstancesLastAction(t *testing.T) {
+	ts := time.Date(2018, 5, 7, 12, 0, 0, 0, time.FixedZone("NZST", 12*3600))
+	changes := Changes{
+		{ID: aws.String("i-1"), Action: StopAction},
+		{ID: aws.String("i-2"), Action: StartAction},
+		{ID: aws.String("i-3"), Action: StopAction},
+		{ID: aws.String("i-4"), Action: StopAction, Error: "IncorrectInstanceState"},
+		{ID: aws.String("i-5"), Action: WarnAction},
+	}
+
+	client := &mockEC2Client{}
+	if err := tagInstancesLastAction(context.Background(), client, changes, ts, defaultTags); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.createTagsInput) != 2 {
+		t.Fatalf("expected a CreateTags call per action, got %d", len(client.createTagsInput))
+	}
+	tests := []struct {
+		action string
+		ids    []string
+	}{
+		{"start", []string{"i-2"}},
+		{"stop", []string{"i-1", "i-3"}},
+	}
+	for i, test := range tests {
+		input := client.createTagsInput[i]
+		if got := aws.StringValueSlice(input.Resources); fmt.Sprint(got) != fmt.Sprint(test.ids) {
+			t.Errorf("case %d. expected %v to be tagged, got %v", i+1, test.ids, got)
+		}
+		if *input.Tags[0].Key != defaultTags.LastAction || *input.Tags[0].Value != test.action {
+			t.Errorf("case %d. expected %s=%s, got %s=%s", i+1, defaultTags.LastAction, test.action, *input.Tags[0].Key, *input.Tags[0].Value)
+		}
+		if *input.Tags[1].Key != defaultTags.LastActionAt || *input.Tags[1].Value != "2018-05-07T00:00:00Z" {
+			t.Errorf("case %d. expected %s=2018-05-07T00:00:00Z, got %s=%s", i+1, defaultTags.LastActionAt, *input.Tags[1].Key, *input.Tags[1].Value)
+		}
+	}
+}
+
+func TestTagASGLastAction(t *testing.T) {
+	var changes Changes
+	for i := 0; i < 15; i++ {
+		changes = append(changes, Change{ID: aws.String(fmt.Sprintf("asg-%d", i)), Action: StopAction})
+	}
+
+	client := &mockAutoscalingClient{}
+	if err := tagASGLastAction(context.Background(), client, changes, time.Now(), defaultTags); err != nil {
+		t.Fatal(err)
+	}
+
+	// 15 groups with 2 tags each fit in 2 batches
+	if len(client.createOrUpdateTagsInput) != 2 {
+		t.Fatalf("expected 2 batches, got %d", len(client.createOrUpdateTagsInput))
+	}
+	if len(client.createOrUpdateTagsInput[0]) != asgTagBatchSize || len(client.createOrUpdateTagsInput[1]) != 5 {
+		t.Errorf("expected batches of %d and 5 tags", asgTagBatchSize)
+	}
+}
+
+func TestTagDBInstancesLastAction(t *testing.T) {
+	changes := Changes{
+		{ID: aws.String("db-1"), Action: StartAction, arn: "arn:aws:rds:ap-southeast-2:123456789012:db:db-1"},
+		{ID: aws.String("db-2"), Action: StopAction},
+	}
+
+	client := &mockRDSClient{describeDBInstancesResult: []*rds.DBInstance{
+		{DBInstanceIdentifier: aws.String("db-2"), DBInstanceArn: aws.String("arn:aws:rds:ap-southeast-2:123456789012:db:db-2")},
+	}}
+	if err := tagDBInstancesLastAction(context.Background(), client, changes, time.Now(), defaultTags); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.addTagsToResourceInput) != 2 {
+		t.Fatalf("expected 2 db instances to be tagged, got %d", len(client.addTagsToResourceInput))
+	}
+	for i, change := range changes {
+		expected := fmt.Sprintf("arn:aws:rds:ap-southeast-2:123456789012:db:%s", *change.ID)
+		if *client.addTagsToResourceInput[i].ResourceName != expected {
+			t.Errorf("case %d. expected %s, got %s", i+1, expected, *client.addTagsToResourceInput[i].ResourceName)
+		}
+	}
+}
+
+func TestParseLastAction(t *testing.T) {
+	tests := []struct {
+		action, at *string
+		expected   ScheduledAction
+		ok         bool
+	}{
+		{aws.String("stop"), aws.String("2018-05-07T12:00:00Z"), StopAction, true},
+		{aws.String("start"), aws.String("2018-05-07T12:00:00Z"), StartAction, true},
+		{aws.String("stop"), nil, NoopAction, false},
+		{aws.String("reboot"), aws.String("2018-05-07T12:00:00Z"), NoopAction, false},
+		{aws.String("stop"), aws.String("yesterday"), NoopAction, false},
+	}
+	for i, test := range tests {
+		action, at := parseLastAction(test.action, test.at)
+		if action != test.expected || at.IsZero() == test.ok {
+			t.Errorf("case %d. expected %s, got %s at %s", i+1, test.expected, action, at)
+		}
+	}
+}
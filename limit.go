@@ -0,0 +1,55 @@
+package possum
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ChangeLimit is the circuit breaker of a run. A run that would start or stop more resources than this only plans the
+// changes and raises an alert, so that a bad schedule edit can't stop everything at once.
+type ChangeLimit struct {
+	Max        int // the most starts and stops in a run, no limit if 0
+	MaxPercent int // the most starts and stops as a percentage of the scheduled resources, no limit if 0
+}
+
+// ParseChangeLimit reads a limit like 50, or 10% of the scheduled resources
+func ParseChangeLimit(s string) (*ChangeLimit, error) {
+	s = strings.TrimSpace(s)
+	percent := strings.HasSuffix(s, "%")
+	n, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid change limit '%s', expected a number like 50 or a percentage like 10%%", s)
+	}
+	if percent {
+		return &ChangeLimit{MaxPercent: n}, nil
+	}
+	return &ChangeLimit{Max: n}, nil
+}
+
+// Check returns an error if the starts and stops in the changes are over the limit, resources is the number of
+// scheduled resources that the changes were planned for. A nil limit allows any number of changes.
+func (l *ChangeLimit) Check(changes Changes, resources int) error {
+	if l == nil {
+		return nil
+	}
+	n := CountActions(changes)
+	if l.Max > 0 && n > l.Max {
+		return fmt.Errorf("%d starts and stops are more than the limit of %d", n, l.Max)
+	}
+	if l.MaxPercent > 0 && n*100 > l.MaxPercent*resources {
+		return fmt.Errorf("%d starts and stops of %d scheduled resources are more than the limit of %d%%", n, resources, l.MaxPercent)
+	}
+	return nil
+}
+
+// CountActions returns the number of starts and stops in the changes, warnings and holds don't count
+func CountActions(changes Changes) int {
+	var n int
+	for _, change := range changes {
+		if change.Action == StartAction || change.Action == StopAction {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,72 @@
+package possum
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestParseChangeLimit(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected *ChangeLimit
+	}{
+		{"50", &ChangeLimit{Max: 50}},
+		{" 10% ", &ChangeLimit{MaxPercent: 10}},
+		{"0", nil},
+		{"-5", nil},
+		{"ten", nil},
+		{"%", nil},
+	}
+
+	for i, test := range tests {
+		actual, err := ParseChangeLimit(test.in)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("case %d. expected an error for '%s'", i+1, test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d. unexpected error %s", i+1, err)
+			continue
+		}
+		if *actual != *test.expected {
+			t.Errorf("case %d. expected %+v, got %+v", i+1, *test.expected, *actual)
+		}
+	}
+}
+
+func TestChangeLimit_Check(t *testing.T) {
+	changes := func(actions ...ScheduledAction) Changes {
+		var list Changes
+		for i, action := range actions {
+			list = append(list, Change{ID: aws.String(string(rune('a' + i))), Action: action})
+		}
+		return list
+	}
+	threeStops := changes(StopAction, StopAction, StopAction, WarnAction, HoldAction)
+
+	tests := []struct {
+		limit     *ChangeLimit
+		changes   Changes
+		resources int
+		tripped   bool
+	}{
+		{nil, threeStops, 3, false},
+		{&ChangeLimit{}, threeStops, 3, false},
+		{&ChangeLimit{Max: 3}, threeStops, 10, false}, // warnings and holds don't count
+		{&ChangeLimit{Max: 2}, threeStops, 10, true},
+		{&ChangeLimit{MaxPercent: 30}, threeStops, 10, false},
+		{&ChangeLimit{MaxPercent: 20}, threeStops, 10, true},
+		{&ChangeLimit{Max: 5, MaxPercent: 20}, threeStops, 10, true},
+		{&ChangeLimit{MaxPercent: 10}, nil, 0, false},
+	}
+
+	for i, test := range tests {
+		err := test.limit.Check(test.changes, test.resources)
+		if (err != nil) != test.tripped {
+			t.Errorf("case %d. expected tripped to be %t, got %v", i+1, test.tripped, err)
+		}
+	}
+}
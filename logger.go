@@ -0,0 +1,173 @@
+package possum
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry, a logger drops the entries below its level
+type Level int8
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "DEBUG"
+	case InfoLevel:
+		return "INFO"
+	case WarnLevel:
+		return "WARN"
+	case ErrorLevel:
+		return "ERROR"
+	}
+	return fmt.Sprintf("Level(%d)", int8(l))
+}
+
+// ParseLevel reads a level like debug, info, warn or error
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "DEBUG":
+		return DebugLevel, nil
+	case "INFO":
+		return InfoLevel, nil
+	case "WARN", "WARNING":
+		return WarnLevel, nil
+	case "ERROR":
+		return ErrorLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("invalid log level '%s', expected debug, info, warn or error", s)
+}
+
+// The keys of the fields possum logs with, so that CloudWatch Logs Insights can filter on them
+const (
+	LogInvocationID = "invocation_id"
+	LogAccount      = "account"
+	LogRegion       = "region"
+	LogResourceType = "resource_type"
+	LogResourceID   = "resource_id"
+	LogSchedule     = "schedule"
+	LogAction       = "action"
+)
+
+// Logger writes log entries as JSON lines with its fields, e.g.
+// {"time":"2026-10-19T08:00:00Z","level":"INFO","msg":"stopped web-1","region":"us-east-1"}
+type Logger struct {
+	out    io.Writer
+	mu     *sync.Mutex // shared by the loggers returned by With, so that their lines don't interleave
+	level  Level
+	fields []string // key, value, key, value...
+	now    func() time.Time
+}
+
+// NewLogger returns a logger that writes the entries at level or above to out
+func NewLogger(out io.Writer, level Level) *Logger {
+	return &Logger{out: out, mu: &sync.Mutex{}, level: level, now: time.Now}
+}
+
+// NewLoggerFromEnv returns a logger that writes to stderr at the LOG_LEVEL env variable, or INFO if it isn't set
+func NewLoggerFromEnv() *Logger {
+	level := InfoLevel
+	var err error
+	if s := os.Getenv("LOG_LEVEL"); s != "" {
+		level, err = ParseLevel(s)
+	}
+	l := NewLogger(os.Stderr, level)
+	if err != nil {
+		l.Warnf("ignoring LOG_LEVEL: %s", err)
+	}
+	return l
+}
+
+// With returns a logger that adds the key value pairs to every entry, a key that's already set is replaced
+func (l *Logger) With(keyvals ...string) *Logger {
+	fields := append([]string(nil), l.fields...)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		replaced := false
+		for j := 0; j < len(fields); j += 2 {
+			if fields[j] == keyvals[i] {
+				fields[j+1], replaced = keyvals[i+1], true
+			}
+		}
+		if !replaced {
+			fields = append(fields, keyvals[i], keyvals[i+1])
+		}
+	}
+	c := *l
+	c.fields = fields
+	return &c
+}
+
+// WithChange returns a logger that adds the resource, schedule and action of the change to every entry
+func (l *Logger) WithChange(change Change) *Logger {
+	return l.With(LogResourceType, change.Type, LogResourceID, *change.ID, LogSchedule, change.Schedule, LogAction, change.Action.String())
+}
+
+// Enabled returns true if the logger writes entries at the level
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) { l.logf(DebugLevel, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.logf(InfoLevel, format, args...) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.logf(WarnLevel, format, args...) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.logf(ErrorLevel, format, args...) }
+
+func (l *Logger) logf(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var b strings.Builder
+	field := func(key, value string) {
+		k, _ := json.Marshal(key)
+		v, _ := json.Marshal(value)
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	field("time", l.now().UTC().Format(time.RFC3339Nano))
+	field("level", level.String())
+	field("msg", fmt.Sprintf(format, args...))
+	for i := 0; i+1 < len(l.fields); i += 2 {
+		if l.fields[i+1] != "" {
+			field(l.fields[i], l.fields[i+1])
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.out, "{%s}\n", b.String())
+}
+
+type loggerKey struct{}
+
+// defaultLogger is used when there's no logger in the context
+var defaultLogger = NewLoggerFromEnv()
+
+// WithLogger returns a context that carries the logger, the functions that are called with it log to the logger
+func WithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// LoggerFrom returns the logger in the context, or a logger from the env variables if there is none
+func LoggerFrom(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+	return defaultLogger
+}
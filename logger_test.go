@@ -0,0 +1,107 @@
+package possum
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected Level
+		err      bool
+	}{
+		{"debug", DebugLevel, false},
+		{"INFO", InfoLevel, false},
+		{" warn ", WarnLevel, false},
+		{"warning", WarnLevel, false},
+		{"error", ErrorLevel, false},
+		{"verbose", InfoLevel, true},
+		{"", InfoLevel, true},
+	}
+
+	for _, test := range tests {
+		level, err := ParseLevel(test.in)
+		if (err != nil) != test.err {
+			t.Errorf("ParseLevel(%q) error = %v, expected error %v", test.in, err, test.err)
+		}
+		if level != test.expected {
+			t.Errorf("ParseLevel(%q) = %s, expected %s", test.in, level, test.expected)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, InfoLevel)
+	logger.now = func() time.Time { return time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC) }
+
+	logger = logger.With(LogInvocationID, "abc-123", LogAccount, "dev")
+	regional := logger.With(LogRegion, "us-east-1", LogAccount, "prod")
+	change := Change{ID: aws.String("i-1"), Name: "web", Type: InstanceResource, Schedule: "office", Action: StopAction}
+
+	logger.Debugf("dropped")
+	regional.WithChange(change).Warnf("stopping '%s'", "web")
+	logger.Errorf("failed")
+
+	var entries []map[string]string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry map[string]string
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatalf("decoding log entry: %s", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	expected := []map[string]string{
+		{
+			"time":          "2026-10-19T08:00:00Z",
+			"level":         "WARN",
+			"msg":           "stopping 'web'",
+			"invocation_id": "abc-123",
+			"account":       "prod",
+			"region":        "us-east-1",
+			"resource_type": InstanceResource,
+			"resource_id":   "i-1",
+			"schedule":      "office",
+			"action":        "stop",
+		},
+		{
+			"time":          "2026-10-19T08:00:00Z",
+			"level":         "ERROR",
+			"msg":           "failed",
+			"invocation_id": "abc-123",
+			"account":       "dev",
+		},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d: %v", len(expected), len(entries), entries)
+	}
+	for i := range expected {
+		if len(entries[i]) != len(expected[i]) {
+			t.Errorf("entry %d: expected %v, got %v", i, expected[i], entries[i])
+			continue
+		}
+		for key, value := range expected[i] {
+			if entries[i][key] != value {
+				t.Errorf("entry %d: expected %s '%s', got '%s'", i, key, value, entries[i][key])
+			}
+		}
+	}
+}
+
+func TestLoggerFrom(t *testing.T) {
+	if LoggerFrom(context.Background()) != defaultLogger {
+		t.Errorf("expected the default logger without a logger in the context")
+	}
+	logger := NewLogger(&bytes.Buffer{}, DebugLevel)
+	if LoggerFrom(WithLogger(context.Background(), logger)) != logger {
+		t.Errorf("expected the logger in the context")
+	}
+}
@@ -0,0 +1,169 @@
+package possum
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+const (
+	SlackNotifier   = "slack"
+	WebhookNotifier = "webhook"
+	SNSNotifier     = "sns"
+	TeamsNotifier   = "teams"
+)
+
+// Notifier sends the report of a possum run to somewhere humans will see it
+type Notifier interface {
+	Notify(ctx context.Context, report *Report) error
+}
+
+// NotifierConfig configures a single notifier in the stored config, which fields are used depends on the Type
+type NotifierConfig struct {
+	Type     string // One of slack, webhook, sns or teams
+	URL      string // The URL to post to, for the webhook and teams notifiers
+	TopicARN string // The topic to publish to, for the sns notifier
+	Channel  string // The channel to post to, for the slack notifier
+	Token    string // The API token for the slack notifier, falls back to the SLACK_TOKEN env variable
+}
+
+// NewNotifiers creates the notifiers from the stored config, the provider is used for creating AWS clients. Without any
+// configured notifiers possum doesn't notify.
+func NewNotifiers(ctx context.Context, configs []*NotifierConfig, p client.ConfigProvider) (Notifiers, error) {
+	var notifiers Notifiers
+	for _, cfg := range configs {
+		switch cfg.Type {
+		case SlackNotifier:
+			token := cfg.Token
+			if token == "" {
+				token = os.Getenv("SLACK_TOKEN")
+			}
+			if token == "" || cfg.Channel == "" {
+				LoggerFrom(ctx).Warnf("skipping slack notifier, it's missing either a token or a channel")
+				continue
+			}
+			notifiers = append(notifiers, &Slack{Token: token, Channel: cfg.Channel})
+		case WebhookNotifier:
+			if cfg.URL == "" {
+				LoggerFrom(ctx).Warnf("skipping webhook notifier, it's missing a URL")
+				continue
+			}
+			notifiers = append(notifiers, &Webhook{URL: cfg.URL})
+		case TeamsNotifier:
+			if cfg.URL == "" {
+				LoggerFrom(ctx).Warnf("skipping teams notifier, it's missing a URL")
+				continue
+			}
+			notifiers = append(notifiers, &Teams{URL: cfg.URL})
+		case SNSNotifier:
+			topic, err := arn.Parse(cfg.TopicARN)
+			if err != nil {
+				return nil, fmt.Errorf("sns notifier: %w", err)
+			}
+			notifiers = append(notifiers, &SNS{
+				Client:   sns.New(p, aws.NewConfig().WithRegion(topic.Region)),
+				TopicARN: cfg.TopicARN,
+			})
+		default:
+			return nil, fmt.Errorf("unknown notifier type '%s'", cfg.Type)
+		}
+	}
+	return notifiers, nil
+}
+
+// Notifiers sends the report to every notifier in the list
+type Notifiers []Notifier
+
+func (n Notifiers) Notify(ctx context.Context, report *Report) error {
+	var errs []string
+	for _, notifier := range n {
+		if err := notifier.Notify(ctx, report); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d notifiers failed: %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Webhook posts the report as JSON to a URL
+type Webhook struct {
+	URL    string
+	Client *http.Client // optional, defaults to http.DefaultClient
+}
+
+func (w *Webhook) Notify(ctx context.Context, report *Report) error {
+	return postJSON(ctx, w.Client, w.URL, report)
+}
+
+// Teams posts the report to a Microsoft Teams incoming webhook
+type Teams struct {
+	URL    string
+	Client *http.Client // optional, defaults to http.DefaultClient
+}
+
+func (t *Teams) Notify(ctx context.Context, report *Report) error {
+	// teams only renders line breaks between paragraphs
+	text := strings.ReplaceAll(report.format(markdown), "\n", "\n\n")
+	card := map[string]string{
+		"@type":    "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary":  "possum changes",
+		"text":     text,
+	}
+	return postJSON(ctx, t.Client, t.URL, card)
+}
+
+// SNS publishes the report as plain text to an SNS topic
+type SNS struct {
+	Client   snsiface.SNSAPI
+	TopicARN string
+}
+
+func (s *SNS) Notify(ctx context.Context, report *Report) error {
+	_, err := s.Client.PublishWithContext(ctx, &sns.PublishInput{
+		TopicArn: aws.String(s.TopicARN),
+		Subject:  aws.String("possum changes"),
+		Message:  aws.String(report.String()),
+	})
+	return err
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("posting to %s failed with status %s", url, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package possum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+func TestNewNotifiers(t *testing.T) {
+	tests := []struct {
+		configs  []*NotifierConfig
+		expected int
+		hasErr   bool
+	}{
+		{nil, 0, false},
+		{[]*NotifierConfig{{Type: SlackNotifier, Channel: "possum", Token: "xoxb"}}, 1, false},
+		{[]*NotifierConfig{{Type: SlackNotifier, Channel: "possum"}}, 0, false}, // missing token means no notifier
+		{[]*NotifierConfig{{Type: WebhookNotifier, URL: "https://example.com"}, {Type: TeamsNotifier, URL: "https://example.com"}}, 2, false},
+		{[]*NotifierConfig{{Type: WebhookNotifier}, {Type: TeamsNotifier}}, 0, false}, // missing URL means no notifier
+		{[]*NotifierConfig{{Type: SNSNotifier, TopicARN: "arn:aws:sns:ap-southeast-2:123456789012:possum"}}, 1, false},
+		{[]*NotifierConfig{{Type: SNSNotifier, TopicARN: "not-an-arn"}}, 0, true},
+		{[]*NotifierConfig{{Type: "pigeon"}}, 0, true},
+	}
+
+	t.Setenv("SLACK_TOKEN", "")
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("ap-southeast-2")}))
+	for i, test := range tests {
+		notifiers, err := NewNotifiers(context.Background(), test.configs, sess)
+		if test.hasErr {
+			if err == nil {
+				t.Errorf("case %d. expected an error", i+1)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d. %s", i+1, err)
+			continue
+		}
+		if len(notifiers) != test.expected {
+			t.Errorf("case %d. expected %d notifiers, got %d", i+1, test.expected, len(notifiers))
+		}
+	}
+}
+
+func TestNotifiers_Notify(t *testing.T) {
+	ok := &mockNotifier{}
+	failing := &mockNotifier{err: errors.New("boom")}
+
+	if err := (Notifiers{}).Notify(context.Background(), newTestReport()); err != nil {
+		t.Errorf("expected no notifiers to not error, got %s", err)
+	}
+
+	err := Notifiers{failing, ok}.Notify(context.Background(), newTestReport())
+	if err == nil {
+		t.Errorf("expected an error from the failing notifier")
+	}
+	if ok.calls != 1 {
+		t.Errorf("expected every notifier to be called even if one fails, got %d calls", ok.calls)
+	}
+}
+
+func TestWebhook_Notify(t *testing.T) {
+	var received Report
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a json content type, got %s", r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	webhook := &Webhook{URL: server.URL}
+	if err := webhook.Notify(context.Background(), newTestReport()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(received.Results) != 1 {
+		t.Errorf("expected 1 result, got %d", len(received.Results))
+		return
+	}
+	if received.Results[0].Changes[0].Action != StopAction {
+		t.Errorf("expected action %s, got %s", StopAction, received.Results[0].Changes[0].Action)
+	}
+}
+
+func TestWebhook_NotifyFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	webhook := &Webhook{URL: server.URL}
+	if err := webhook.Notify(context.Background(), newTestReport()); err == nil {
+		t.Errorf("expected an error on a non 2xx response")
+	}
+}
+
+func TestTeams_Notify(t *testing.T) {
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	teams := &Teams{URL: server.URL}
+	if err := teams.Notify(context.Background(), newTestReport()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !strings.Contains(received["text"], "**playpen** (ap-southeast-2)") {
+		t.Errorf("expected the account in bold markdown, got %s", received["text"])
+	}
+}
+
+func TestSNS_Notify(t *testing.T) {
+	client := &mockSNSClient{}
+	notifier := &SNS{Client: client, TopicARN: "arn:aws:sns:ap-southeast-2:123456789012:possum"}
+	if err := notifier.Notify(context.Background(), newTestReport()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(client.published) != 1 {
+		t.Errorf("expected 1 published message, got %d", len(client.published))
+		return
+	}
+	if *client.published[0].TopicArn != notifier.TopicARN {
+		t.Errorf("expected message to be published to %s, got %s", notifier.TopicARN, *client.published[0].TopicArn)
+	}
+}
+
+func newTestReport() *Report {
+	report := &Report{}
+	report.Add("playpen", "ap-southeast-2", Changes{
+		{ID: aws.String("i-1"), Name: "dev-box", Action: StopAction, Type: "instance"},
+	})
+	return report
+}
+
+type mockNotifier struct {
+	err   error
+	calls int
+}
+
+func (m *mockNotifier) Notify(ctx context.Context, report *Report) error {
+	m.calls++
+	return m.err
+}
+
+type mockSNSClient struct {
+	snsiface.SNSAPI
+	published []*sns.PublishInput
+}
+
+func (m *mockSNSClient) PublishWithContext(ctx aws.Context, input *sns.PublishInput, options ...request.Option) (*sns.PublishOutput, error) {
+	m.published = append(m.published, input)
+	return &sns.PublishOutput{}, nil
+}
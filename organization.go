@@ -0,0 +1,157 @@
+package possum
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+)
+
+// Organization configures the discovery of accounts from an AWS Organization
+type Organization struct {
+	RoleName   string            // The name of the role possum assumes in every account, e.g. OrganizationAccountAccessRole
+	ExternalID string            // The external ID that the role trust policy requires, optional
+	OUs        []string          // Only schedule accounts in these organizational units or their children, optional
+	Tags       map[string]string // Only schedule accounts that have all of these tags, optional
+}
+
+// DiscoverAccounts lists the active accounts in the organization that match the OU and tag filters. Possum assumes the
+// role in every account but the one it's deployed in, usually the management account, which it uses its own
+// credentials for.
+func DiscoverAccounts(ctx context.Context, client organizationsiface.OrganizationsAPI, org *Organization, deployedAccountID string) ([]*Account, error) {
+	found, err := getOrganizationAccounts(ctx, client, org.OUs)
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []*Account
+	for _, a := range found {
+		if *a.Status != organizations.AccountStatusActive {
+			continue
+		}
+
+		if len(org.Tags) > 0 {
+			tags, err := getOrganizationTags(ctx, client, a.Id)
+			if err != nil {
+				return nil, err
+			}
+			if !matchTags(tags, org.Tags) {
+				continue
+			}
+		}
+
+		account := &Account{ID: *a.Id, Name: aws.StringValue(a.Name)}
+		if account.ID != deployedAccountID {
+			account.RoleARN = fmt.Sprintf("arn:aws:iam::%s:role/%s", *a.Id, org.RoleName)
+			account.ExternalID = org.ExternalID
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// MergeAccounts appends the accounts in b to a, skipping accounts that are already in a. The name of an account is
+// what tells it apart in notifications, approvals and the audit log, so accounts in b that share their name with
+// another account, or whose name is the ID of another account, are renamed to "name (id)". Display names in an
+// organization often aren't unique.
+func MergeAccounts(a, b []*Account) []*Account {
+	seen := make(map[string]bool)
+	taken := make(map[string]int) // the number of accounts that go by each name or ID
+	count := func(account *Account) {
+		taken[account.ID]++
+		if account.Name != "" && account.Name != account.ID {
+			taken[account.Name]++
+		}
+	}
+	for _, account := range a {
+		seen[account.ID] = true
+		count(account)
+	}
+	var added []*Account
+	for _, account := range b {
+		if !seen[account.ID] {
+			added = append(added, account)
+			seen[account.ID] = true
+			count(account)
+		}
+	}
+
+	for _, account := range added {
+		if account.Name != "" && account.Name != account.ID && taken[account.Name] > 1 {
+			renamed := *account
+			renamed.Name = fmt.Sprintf("%s (%s)", account.Name, account.ID)
+			account = &renamed
+		}
+		a = append(a, account)
+	}
+	return a
+}
+
+// getOrganizationAccounts returns every account in the organization, or only the accounts in the organizational units
+// and their children if any are given
+func getOrganizationAccounts(ctx context.Context, client organizationsiface.OrganizationsAPI, ous []string) ([]*organizations.Account, error) {
+	var accounts []*organizations.Account
+
+	if len(ous) == 0 {
+		err := client.ListAccountsPagesWithContext(ctx, &organizations.ListAccountsInput{}, func(page *organizations.ListAccountsOutput, lastPage bool) bool {
+			accounts = append(accounts, page.Accounts...)
+			return true
+		})
+		return accounts, err
+	}
+
+	seen := make(map[string]bool)
+	parents := aws.StringSlice(ous)
+	for len(parents) > 0 {
+		parent := parents[0]
+		parents = parents[1:]
+
+		err := client.ListAccountsForParentPagesWithContext(ctx, &organizations.ListAccountsForParentInput{ParentId: parent}, func(page *organizations.ListAccountsForParentOutput, lastPage bool) bool {
+			for _, account := range page.Accounts {
+				if !seen[*account.Id] {
+					accounts = append(accounts, account)
+					seen[*account.Id] = true
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// accounts in nested organizational units also belong to the parent unit
+		err = client.ListOrganizationalUnitsForParentPagesWithContext(ctx, &organizations.ListOrganizationalUnitsForParentInput{ParentId: parent}, func(page *organizations.ListOrganizationalUnitsForParentOutput, lastPage bool) bool {
+			for _, ou := range page.OrganizationalUnits {
+				parents = append(parents, ou.Id)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return accounts, nil
+}
+
+func getOrganizationTags(ctx context.Context, client organizationsiface.OrganizationsAPI, accountID *string) (map[string]string, error) {
+	tags := make(map[string]string)
+	err := client.ListTagsForResourcePagesWithContext(ctx, &organizations.ListTagsForResourceInput{ResourceId: accountID}, func(page *organizations.ListTagsForResourceOutput, lastPage bool) bool {
+		for _, tag := range page.Tags {
+			tags[*tag.Key] = *tag.Value
+		}
+		return true
+	})
+	return tags, err
+}
+
+// matchTags returns true if tags contains every key and value in filter
+func matchTags(tags, filter map[string]string) bool {
+	for key, value := range filter {
+		if v, ok := tags[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package possum
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+)
+
+func TestDiscoverAccounts(t *testing.T) {
+	client := &mockOrganizationsClient{
+		accounts: map[string][]*organizations.Account{
+			"r-root":  {makeOrgAccount("111111111111", organizations.AccountStatusActive)},
+			"ou-dev":  {makeOrgAccount("222222222222", organizations.AccountStatusActive), makeOrgAccount("333333333333", organizations.AccountStatusSuspended)},
+			"ou-team": {makeOrgAccount("444444444444", organizations.AccountStatusActive)},
+		},
+		children: map[string][]string{
+			"r-root": {"ou-dev"},
+			"ou-dev": {"ou-team"},
+		},
+		tags: map[string]map[string]string{
+			"222222222222": {"possum": "enabled"},
+			"444444444444": {"possum": "disabled"},
+		},
+	}
+
+	tests := []struct {
+		org      *Organization
+		expected []string
+	}{
+		{&Organization{RoleName: "possum"}, []string{"111111111111", "222222222222", "444444444444"}},
+		{&Organization{RoleName: "possum", OUs: []string{"ou-dev"}}, []string{"222222222222", "444444444444"}},
+		{&Organization{RoleName: "possum", OUs: []string{"ou-team"}}, []string{"444444444444"}},
+		{&Organization{RoleName: "possum", Tags: map[string]string{"possum": "enabled"}}, []string{"222222222222"}},
+		{&Organization{RoleName: "possum", OUs: []string{"ou-team"}, Tags: map[string]string{"possum": "enabled"}}, nil},
+	}
+
+	for i, test := range tests {
+		// possum is deployed in the management account, it uses its own credentials there
+		accounts, err := DiscoverAccounts(context.Background(), client, test.org, "111111111111")
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if len(accounts) != len(test.expected) {
+			t.Errorf("case %d. expected %d accounts, got %d", i+1, len(test.expected), len(accounts))
+			continue
+		}
+		for j, account := range accounts {
+			if account.ID != test.expected[j] {
+				t.Errorf("case %d. expected account %s, got %s", i+1, test.expected[j], account.ID)
+			}
+			expectedRole := "arn:aws:iam::" + test.expected[j] + ":role/possum"
+			if account.ID == "111111111111" {
+				expectedRole = ""
+			}
+			if account.RoleARN != expectedRole {
+				t.Errorf("case %d. expected role %s, got %s", i+1, expectedRole, account.RoleARN)
+			}
+		}
+	}
+}
+
+func TestMergeAccounts(t *testing.T) {
+	configured := []*Account{{ID: "1", Name: "configured"}}
+	discovered := []*Account{{ID: "1", Name: "discovered"}, {ID: "2", Name: "discovered"}}
+
+	actual := MergeAccounts(configured, discovered)
+	if len(actual) != 2 {
+		t.Errorf("expected 2 accounts, got %d", len(actual))
+		return
+	}
+	if actual[0].Name != "configured" {
+		t.Errorf("expected configured accounts to take precedence, got %s", actual[0].Name)
+	}
+
+	// discovered accounts that share a name are told apart by their ID, the configured account keeps its name
+	configured = []*Account{{ID: "1", Name: "dev"}}
+	discovered = []*Account{{ID: "2", Name: "dev"}, {ID: "3", Name: "sandbox"}, {ID: "4", Name: "sandbox"}, {ID: "5", Name: "1"}, {ID: "6", Name: "prod"}}
+	expected := []string{"dev", "dev (2)", "sandbox (3)", "sandbox (4)", "1 (5)", "prod"}
+	actual = MergeAccounts(configured, discovered)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d accounts, got %d", len(expected), len(actual))
+	}
+	for i, name := range expected {
+		if actual[i].String() != name {
+			t.Errorf("expected account %d to be named '%s', got '%s'", i+1, name, actual[i].String())
+		}
+	}
+	if discovered[0].Name != "dev" {
+		t.Errorf("expected the discovered accounts to be left as they are, got %s", discovered[0].Name)
+	}
+}
+
+type mockOrganizationsClient struct {
+	organizationsiface.OrganizationsAPI
+	accounts map[string][]*organizations.Account // accounts per parent id
+	children map[string][]string                 // organizational units per parent id
+	tags     map[string]map[string]string
+}
+
+func (m *mockOrganizationsClient) ListAccountsPagesWithContext(ctx aws.Context, input *organizations.ListAccountsInput, fnc func(*organizations.ListAccountsOutput, bool) bool, options ...request.Option) error {
+	res := &organizations.ListAccountsOutput{}
+	for _, parent := range []string{"r-root", "ou-dev", "ou-team"} {
+		res.Accounts = append(res.Accounts, m.accounts[parent]...)
+	}
+	fnc(res, true)
+	return nil
+}
+
+func (m *mockOrganizationsClient) ListAccountsForParentPagesWithContext(ctx aws.Context, input *organizations.ListAccountsForParentInput, fnc func(*organizations.ListAccountsForParentOutput, bool) bool, options ...request.Option) error {
+	fnc(&organizations.ListAccountsForParentOutput{Accounts: m.accounts[*input.ParentId]}, true)
+	return nil
+}
+
+func (m *mockOrganizationsClient) ListOrganizationalUnitsForParentPagesWithContext(ctx aws.Context, input *organizations.ListOrganizationalUnitsForParentInput, fnc func(*organizations.ListOrganizationalUnitsForParentOutput, bool) bool, options ...request.Option) error {
+	res := &organizations.ListOrganizationalUnitsForParentOutput{}
+	for _, id := range m.children[*input.ParentId] {
+		res.OrganizationalUnits = append(res.OrganizationalUnits, &organizations.OrganizationalUnit{Id: aws.String(id)})
+	}
+	fnc(res, true)
+	return nil
+}
+
+func (m *mockOrganizationsClient) ListTagsForResourcePagesWithContext(ctx aws.Context, input *organizations.ListTagsForResourceInput, fnc func(*organizations.ListTagsForResourceOutput, bool) bool, options ...request.Option) error {
+	res := &organizations.ListTagsForResourceOutput{}
+	for key, value := range m.tags[*input.ResourceId] {
+		res.Tags = append(res.Tags, &organizations.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	fnc(res, true)
+	return nil
+}
+
+func makeOrgAccount(id, status string) *organizations.Account {
+	return &organizations.Account{Id: aws.String(id), Name: aws.String("account-" + id), Status: aws.String(status)}
+}
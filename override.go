@@ -0,0 +1,140 @@
+package possum
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+)
+
+// Snooze asks possum to leave a resource alone for a while, it's the value of the snooze buttons in notifications
+type Snooze struct {
+	Account  string
+	Region   string
+	Type     string
+	ID       string
+	Duration string // e.g. 2h
+}
+
+// SetOverride tags the resource so that possum doesn't start or stop it until the given time
+func SetOverride(ctx context.Context, clients *Clients, resourceType, id string, until time.Time, tags *TagKeys) error {
+	key := tags.resolved().OverrideUntil
+	switch resourceType {
+	case InstanceResource:
+		return setInstanceOverride(ctx, clients.EC2, aws.String(id), key, until)
+	case AutoScalingGroupResource:
+		return setASGOverride(ctx, clients.AutoScaling, aws.String(id), key, until)
+	case DBInstanceResource:
+		return setDBInstanceOverride(ctx, clients.RDS, aws.String(id), "", key, until)
+	}
+	return fmt.Errorf("unknown resource type '%s'", resourceType)
+}
+
+func setInstanceOverride(ctx context.Context, client ec2iface.EC2API, id *string, key string, until time.Time) error {
+	_, err := client.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: []*string{id},
+		Tags:      []*ec2.Tag{{Key: aws.String(key), Value: aws.String(until.UTC().Format(time.RFC3339))}},
+	})
+	return err
+}
+
+func setASGOverride(ctx context.Context, client autoscalingiface.AutoScalingAPI, id *string, key string, until time.Time) error {
+	_, err := client.CreateOrUpdateTagsWithContext(ctx, &autoscaling.CreateOrUpdateTagsInput{
+		Tags: []*autoscaling.Tag{{
+			ResourceId:        id,
+			ResourceType:      aws.String("auto-scaling-group"),
+			Key:               aws.String(key),
+			Value:             aws.String(until.UTC().Format(time.RFC3339)),
+			PropagateAtLaunch: aws.Bool(false),
+		}},
+	})
+	return err
+}
+
+// setDBInstanceOverride looks up the ARN of the db instance if it's empty, rds can only tag by ARN
+func setDBInstanceOverride(ctx context.Context, client rdsiface.RDSAPI, id *string, arn, key string, until time.Time) error {
+	if arn == "" {
+		var err error
+		if arn, err = getDBInstanceARN(ctx, client, id); err != nil {
+			return err
+		}
+	}
+	_, err := client.AddTagsToResourceWithContext(ctx, &rds.AddTagsToResourceInput{
+		ResourceName: aws.String(arn),
+		Tags:         []*rds.Tag{{Key: aws.String(key), Value: aws.String(until.UTC().Format(time.RFC3339))}},
+	})
+	return err
+}
+
+func getDBInstanceARN(ctx context.Context, client rdsiface.RDSAPI, id *string) (string, error) {
+	res, err := client.DescribeDBInstancesWithContext(ctx, &rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: id,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(res.DBInstances) == 0 {
+		return "", fmt.Errorf("could not find db instance '%s'", *id)
+	}
+	return aws.StringValue(res.DBInstances[0].DBInstanceArn), nil
+}
+
+// holdInstances overrides the schedule of instances that were changed by hand until the schedule takes over again
+func holdInstances(ctx context.Context, client ec2iface.EC2API, changes Changes, tags *TagKeys) error {
+	for _, change := range changes {
+		if change.Action == HoldAction {
+			if err := setInstanceOverride(ctx, client, change.ID, tags.OverrideUntil, change.Due); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// holdASGs overrides the schedule of auto scaling groups that were changed by hand until the schedule takes over again
+func holdASGs(ctx context.Context, client autoscalingiface.AutoScalingAPI, changes Changes, tags *TagKeys) error {
+	for _, change := range changes {
+		if change.Action == HoldAction {
+			if err := setASGOverride(ctx, client, change.ID, tags.OverrideUntil, change.Due); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// holdDBInstances overrides the schedule of db instances that were changed by hand until the schedule takes over again
+func holdDBInstances(ctx context.Context, client rdsiface.RDSAPI, changes Changes, tags *TagKeys) error {
+	for _, change := range changes {
+		if change.Action == HoldAction {
+			if err := setDBInstanceOverride(ctx, client, change.ID, change.arn, tags.OverrideUntil, change.Due); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isOverridden returns true if the override tag value is a time after ts
+func isOverridden(value *string, ts time.Time) bool {
+	return ts.Before(parseOverride(value))
+}
+
+// parseOverride returns the time in the override tag value, or the zero time if the value isn't a valid time
+func parseOverride(value *string) time.Time {
+	if value == nil {
+		return time.Time{}
+	}
+	until, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		return time.Time{}
+	}
+	return until
+}
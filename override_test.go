@@ -0,0 +1,165 @@
+package possum
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+func TestIsOverridden(t *testing.T) {
+	ts := time.Date(2018, 5, 7, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    *string
+		expected bool
+	}{
+		{nil, false},
+		{aws.String("not a time"), false},
+		{aws.String("2018-05-07T11:00:00Z"), false},
+		{aws.String("2018-05-07T13:00:00Z"), true},
+		{aws.String("2018-05-08T00:00:00+12:00"), false},
+	}
+
+	for i, test := range tests {
+		if actual := isOverridden(test.value, ts); actual != test.expected {
+			t.Errorf("case %d. expected %t, got %t", i+1, test.expected, actual)
+		}
+	}
+}
+
+func TestGetInstanceChanges_Override(t *testing.T) {
+	never := NewSchedule("NeverSchedule")
+	p, _ := NewPeriod("00:00", "00:01", []time.Weekday{time.Sunday})
+	never.AddPeriod(time.Local.String(), p)
+	chkTime := newWeekday(time.Monday, 12, 0)
+
+	list := makeInstanceSchedule("a", never.Name, ec2.InstanceStateNameRunning, false)
+	list[0].resource.Tags = append(list[0].resource.Tags, &ec2.Tag{
+		Key:   aws.String(defaultTags.OverrideUntil),
+		Value: aws.String(chkTime.Add(time.Hour).Format(time.RFC3339)),
+	})
+
+	if changes := getInstanceChanges(context.Background(), list, chkTime, Schedules{never}, defaultTags); len(changes) != 0 {
+		t.Errorf("expected overridden instance to be left alone, got %s", changes[0].Action)
+	}
+
+	if changes := getInstanceChanges(context.Background(), list, chkTime.Add(2*time.Hour), Schedules{never}, defaultTags); len(changes) != 1 {
+		t.Errorf("expected instance to be stopped after the override expired")
+	}
+}
+
+func TestGetInstanceChanges_Warn(t *testing.T) {
+	office := NewSchedule("OfficeHours")
+	p, _ := NewPeriod("08:00", "12:30", nil)
+	office.AddPeriod(time.Local.String(), p)
+	office.WarnBefore = 31
+	chkTime := newWeekday(time.Monday, 12, 0)
+
+	list := makeInstanceSchedule("a", office.Name, ec2.InstanceStateNameRunning, false)
+	changes := getInstanceChanges(context.Background(), list, chkTime, Schedules{office}, defaultTags)
+	if len(changes) != 1 {
+		t.Errorf("expected 1 warning, got %d changes", len(changes))
+		return
+	}
+	if changes[0].Action != WarnAction {
+		t.Errorf("expected %s, got %s", WarnAction, changes[0].Action)
+	}
+	if !changes[0].Due.Equal(newWeekday(time.Monday, 12, 31)) {
+		t.Errorf("expected the stop to be due at 12:31, got %s", changes[0].Due)
+	}
+}
+
+func TestSetOverride(t *testing.T) {
+	until := time.Date(2018, 5, 7, 12, 0, 0, 0, time.UTC)
+	clients := &Clients{
+		EC2:         &mockEC2Client{},
+		AutoScaling: &mockAutoscalingClient{},
+		RDS: &mockRDSClient{describeDBInstancesResult: []*rds.DBInstance{
+			{DBInstanceIdentifier: aws.String("db"), DBInstanceArn: aws.String("arn:aws:rds:ap-southeast-2:123456789012:db:db")},
+		}},
+	}
+
+	for _, resourceType := range []string{InstanceResource, AutoScalingGroupResource, DBInstanceResource} {
+		if err := SetOverride(context.Background(), clients, resourceType, "id", until, defaultTags); err != nil {
+			t.Error(err)
+		}
+	}
+
+	ec2Tags := clients.EC2.(*mockEC2Client).createTagsInput
+	if len(ec2Tags) != 1 || *ec2Tags[0].Tags[0].Key != defaultTags.OverrideUntil || *ec2Tags[0].Tags[0].Value != "2018-05-07T12:00:00Z" {
+		t.Errorf("expected instance to be tagged with %s", defaultTags.OverrideUntil)
+	}
+
+	asgTags := clients.AutoScaling.(*mockAutoscalingClient).createOrUpdateTagsInput
+	if len(asgTags) != 1 || *asgTags[0][0].Key != defaultTags.OverrideUntil {
+		t.Errorf("expected auto scaling group to be tagged with %s", defaultTags.OverrideUntil)
+	}
+
+	rdsTags := clients.RDS.(*mockRDSClient).addTagsToResourceInput
+	if len(rdsTags) != 1 || *rdsTags[0].ResourceName != "arn:aws:rds:ap-southeast-2:123456789012:db:db" {
+		t.Errorf("expected db instance to be tagged by its ARN")
+	}
+
+	if err := SetOverride(context.Background(), clients, "lambda", "id", until, defaultTags); err == nil {
+		t.Errorf("expected an error for an unknown resource type")
+	}
+}
+
+func (m *mockEC2Client) CreateTagsWithContext(ctx aws.Context, input *ec2.CreateTagsInput, options ...request.Option) (*ec2.CreateTagsOutput, error) {
+	m.createTagsInput = append(m.createTagsInput, input)
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (m *mockAutoscalingClient) CreateOrUpdateTagsWithContext(ctx aws.Context, input *autoscaling.CreateOrUpdateTagsInput, options ...request.Option) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+	return m.CreateOrUpdateTags(input)
+}
+
+func (m *mockRDSClient) DescribeDBInstancesWithContext(ctx aws.Context, input *rds.DescribeDBInstancesInput, options ...request.Option) (*rds.DescribeDBInstancesOutput, error) {
+	return &rds.DescribeDBInstancesOutput{DBInstances: m.describeDBInstancesResult}, nil
+}
+
+func (m *mockRDSClient) AddTagsToResourceWithContext(ctx aws.Context, input *rds.AddTagsToResourceInput, options ...request.Option) (*rds.AddTagsToResourceOutput, error) {
+	m.addTagsToResourceInput = append(m.addTagsToResourceInput, input)
+	return &rds.AddTagsToResourceOutput{}, nil
+}
+
+func TestGetInstanceChanges_Hold(t *testing.T) {
+	office := NewSchedule("OfficeHours")
+	p, _ := NewPeriod("08:00", "18:00", nil)
+	office.AddPeriod(time.Local.String(), p)
+	office.RespectManualChanges = true
+	chkTime := newWeekday(time.Monday, 22, 0)
+
+	list := makeInstanceSchedule("a", office.Name, ec2.InstanceStateNameRunning, false)
+	list[0].resource.Tags = append(list[0].resource.Tags,
+		&ec2.Tag{Key: aws.String(defaultTags.LastAction), Value: aws.String("stop")},
+		&ec2.Tag{Key: aws.String(defaultTags.LastActionAt), Value: aws.String(newWeekday(time.Monday, 18, 1).Format(time.RFC3339))},
+	)
+
+	changes := getInstanceChanges(context.Background(), list, chkTime, Schedules{office}, defaultTags)
+	if len(changes) != 1 || changes[0].Action != HoldAction {
+		t.Fatalf("expected the instance that was started by hand to be held")
+	}
+	if !changes[0].Due.Equal(newWeekday(time.Tuesday, 8, 0)) {
+		t.Errorf("expected the hold to end at the next start, got %s", changes[0].Due)
+	}
+
+	client := &mockEC2Client{}
+	if err := holdInstances(context.Background(), client, changes, defaultTags); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.createTagsInput) != 1 || *client.createTagsInput[0].Tags[0].Key != defaultTags.OverrideUntil {
+		t.Errorf("expected the instance to be tagged with %s", defaultTags.OverrideUntil)
+	}
+
+	office.RespectManualChanges = false
+	if changes := getInstanceChanges(context.Background(), list, chkTime, Schedules{office}, defaultTags); len(changes) != 1 || changes[0].Action != StopAction {
+		t.Errorf("expected the instance to be stopped without RespectManualChanges")
+	}
+}
@@ -0,0 +1,140 @@
+package possum
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Policy is a guardrail on the changes possum makes to resources with matching tags, e.g. stops of resources tagged
+// environment=prod are only allowed in a change window. A change that breaks a policy is rejected and reported.
+type Policy struct {
+	Name    string            // shown with the changes the policy rejects, defaults to its tags
+	Tags    map[string]string // the resources the policy applies to have all of these tags, values can be patterns like prod*
+	Actions []ScheduledAction // the actions the policy applies to, starts and stops if empty
+
+	// the actions are only allowed while this schedule, or schedule expression, is running, e.g. "Sat 02:00-06:00"
+	Window string
+	// the actions are only allowed by schedules with a name that matches one of these patterns, e.g. Dev*
+	Schedules []string
+}
+
+// Policies are checked in order, the first one that rejects a change is reported
+type Policies []*Policy
+
+// Check returns the changes that the policies allow and the ones they reject, with the reason in Rejected. Holds are
+// always allowed. Warnings are checked as a stop at the time of the stop, and left out if it would be rejected.
+func (p Policies) Check(changes Changes, ts time.Time, schedules Schedules) (allowed, rejected Changes) {
+	for _, change := range changes {
+		action, at := change.Action, ts
+		switch change.Action {
+		case HoldAction, NoopAction:
+			allowed = append(allowed, change)
+			continue
+		case WarnAction:
+			action, at = StopAction, change.Due
+		}
+
+		var reason string
+		for _, policy := range p {
+			if reason = policy.check(change, action, at, schedules); reason != "" {
+				break
+			}
+		}
+		switch {
+		case reason == "":
+			allowed = append(allowed, change)
+		case change.Action != WarnAction:
+			change.Rejected = reason
+			rejected = append(rejected, change)
+		}
+	}
+	return allowed, rejected
+}
+
+// check returns why the policy rejects the action on the resource of the change at ts, or an empty string
+func (p *Policy) check(change Change, action ScheduledAction, ts time.Time, schedules Schedules) string {
+	if !p.appliesTo(action) {
+		return ""
+	}
+	matches, err := p.matches(change.tags)
+	if err != nil {
+		return fmt.Sprintf("%s: %s", p, err)
+	}
+	if !matches {
+		return ""
+	}
+
+	if len(p.Schedules) > 0 {
+		allowed := false
+		for _, pattern := range p.Schedules {
+			ok, err := path.Match(pattern, change.Schedule)
+			if err != nil {
+				return fmt.Sprintf("%s: invalid schedule pattern '%s'", p, pattern)
+			}
+			allowed = allowed || ok
+		}
+		if !allowed {
+			return fmt.Sprintf("%s: schedule '%s' isn't allowed, only %s", p, change.Schedule, strings.Join(p.Schedules, ", "))
+		}
+	}
+
+	if p.Window != "" {
+		window, err := schedules.Resolve(p.Window)
+		if err != nil {
+			return fmt.Sprintf("%s: invalid change window: %s", p, err)
+		}
+		// a stopped resource would be started while the window is open
+		if window.Action(ts, false) != StartAction {
+			return fmt.Sprintf("%s: outside the change window '%s'", p, p.Window)
+		}
+	}
+	return ""
+}
+
+func (p *Policy) appliesTo(action ScheduledAction) bool {
+	if len(p.Actions) == 0 {
+		return action == StartAction || action == StopAction
+	}
+	for _, a := range p.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns true if the tags have every tag of the policy, a policy without tags applies to every resource
+func (p *Policy) matches(tags map[string]string) (bool, error) {
+	for key, pattern := range p.Tags {
+		value, ok := tags[key]
+		if !ok {
+			return false, nil
+		}
+		matches, err := path.Match(pattern, value)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern '%s' for tag %s", pattern, key)
+		}
+		if !matches {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (p *Policy) String() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	var tags []string
+	for key, value := range p.Tags {
+		tags = append(tags, key+"="+value)
+	}
+	if len(tags) == 0 {
+		return "policy"
+	}
+	sort.Strings(tags)
+	return "policy " + strings.Join(tags, ",")
+}
@@ -0,0 +1,102 @@
+package possum
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestPolicies_Check(t *testing.T) {
+	window := NewSchedule("ChangeWindow")
+	p, _ := NewPeriod("02:00", "06:00", []time.Weekday{time.Saturday})
+	window.AddPeriod(time.Local.String(), p)
+	schedules := Schedules{window}
+
+	policies := Policies{
+		{Name: "prod-window", Tags: map[string]string{"environment": "prod"}, Actions: []ScheduledAction{StopAction}, Window: "ChangeWindow"},
+		{Tags: map[string]string{"environment": "prod*"}, Schedules: []string{"Dev*"}},
+	}
+
+	change := func(action ScheduledAction, schedule, environment string) Change {
+		c := Change{ID: aws.String("i-1"), Action: action, Type: InstanceResource, Schedule: schedule, tags: map[string]string{}}
+		if environment != "" {
+			c.tags["environment"] = environment
+		}
+		return c
+	}
+	monday := newWeekday(time.Monday, 22, 0)
+	saturday := newWeekday(time.Saturday, 3, 0)
+
+	tests := []struct {
+		change   Change
+		ts       time.Time
+		allowed  bool
+		rejected string
+	}{
+		{change(StopAction, "DevHours", "staging"), monday, true, ""},
+		{change(StopAction, "DevHours", ""), monday, true, ""},
+		{change(StopAction, "DevHours", "prod"), monday, false, "prod-window: outside the change window 'ChangeWindow'"},
+		{change(StopAction, "DevHours", "prod"), saturday, true, ""},
+		{change(StartAction, "DevHours", "prod"), monday, true, ""},
+		{change(StartAction, "OfficeHours", "prod"), monday, false, "policy environment=prod*: schedule 'OfficeHours' isn't allowed, only Dev*"},
+		{change(StartAction, "OfficeHours", "production"), monday, false, "policy environment=prod*: schedule 'OfficeHours' isn't allowed, only Dev*"},
+		{change(HoldAction, "OfficeHours", "prod"), monday, true, ""},
+	}
+
+	for i, test := range tests {
+		allowed, rejected := policies.Check(Changes{test.change}, test.ts, schedules)
+		if test.allowed {
+			if len(allowed) != 1 || len(rejected) != 0 {
+				t.Errorf("case %d. expected the change to be allowed, got %v", i+1, rejected)
+			}
+			continue
+		}
+		if len(allowed) != 0 || len(rejected) != 1 {
+			t.Errorf("case %d. expected the change to be rejected", i+1)
+			continue
+		}
+		if rejected[0].Rejected != test.rejected {
+			t.Errorf("case %d. expected '%s', got '%s'", i+1, test.rejected, rejected[0].Rejected)
+		}
+	}
+}
+
+func TestPolicies_CheckWarnings(t *testing.T) {
+	policies := Policies{{Tags: map[string]string{"environment": "prod"}, Actions: []ScheduledAction{StopAction}, Window: "Sat 02:00-06:00"}}
+	warning := Change{ID: aws.String("i-1"), Action: WarnAction, tags: map[string]string{"environment": "prod"}}
+
+	// a warning about a stop that would be rejected isn't sent, and isn't reported as rejected either
+	warning.Due = time.Date(2018, 5, 7, 22, 0, 0, 0, time.UTC)
+	allowed, rejected := policies.Check(Changes{warning}, warning.Due.Add(-time.Hour), nil)
+	if len(allowed) != 0 || len(rejected) != 0 {
+		t.Errorf("expected the warning to be left out, got %v and %v", allowed, rejected)
+	}
+
+	warning.Due = time.Date(2018, 5, 12, 3, 0, 0, 0, time.UTC)
+	allowed, _ = policies.Check(Changes{warning}, warning.Due.Add(-time.Hour), nil)
+	if len(allowed) != 1 {
+		t.Errorf("expected the warning about a stop in the window to be sent")
+	}
+}
+
+func TestPolicies_CheckInvalid(t *testing.T) {
+	change := Change{ID: aws.String("i-1"), Action: StopAction, Schedule: "OfficeHours", tags: map[string]string{"environment": "prod"}}
+	tests := []struct {
+		policy   *Policy
+		contains string
+	}{
+		{&Policy{Tags: map[string]string{"environment": "[prod"}}, "invalid pattern"},
+		{&Policy{Schedules: []string{"[Dev"}}, "invalid schedule pattern"},
+		{&Policy{Window: "Someday 02:00-06:00"}, "invalid change window"},
+	}
+
+	// a policy that can't be checked rejects the changes it might apply to, rather than letting them through
+	for i, test := range tests {
+		_, rejected := Policies{test.policy}.Check(Changes{change}, time.Now(), nil)
+		if len(rejected) != 1 || !strings.Contains(rejected[0].Rejected, test.contains) {
+			t.Errorf("case %d. expected the change to be rejected with '%s', got %v", i+1, test.contains, rejected)
+		}
+	}
+}
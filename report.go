@@ -0,0 +1,107 @@
+package possum
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report is the outcome of a possum run, the changes are grouped by account and region
+type Report struct {
+	Time        time.Time
+	Results     []*Result
+	Unreachable []string // accounts where possum could not assume the role
+	Halted      string   // why possum only planned the changes instead of making them, e.g. the change limit
+}
+
+// Result holds the changes possum made in a single account and region
+type Result struct {
+	Account string
+	Region  string
+	Changes Changes
+}
+
+// Add the changes for an account and region to the report, empty changes are ignored
+func (r *Report) Add(account, region string, changes Changes) {
+	if len(changes) == 0 {
+		return
+	}
+	r.Results = append(r.Results, &Result{Account: account, Region: region, Changes: changes})
+}
+
+// Sort the results by account and region so that notifications are grouped in a stable order
+func (r *Report) Sort() {
+	sort.Slice(r.Results, func(i, j int) bool {
+		if r.Results[i].Account != r.Results[j].Account {
+			return r.Results[i].Account < r.Results[j].Account
+		}
+		return r.Results[i].Region < r.Results[j].Region
+	})
+	sort.Strings(r.Unreachable)
+}
+
+// Empty returns true if there is nothing in the report worth notifying about
+func (r *Report) Empty() bool {
+	return len(r.Results) == 0 && len(r.Unreachable) == 0 && r.Halted == ""
+}
+
+// String returns the report as plain text
+func (r *Report) String() string {
+	return r.format(plainText)
+}
+
+// textStyle is used for formatting a report in the different markup languages that notifiers support
+type textStyle struct {
+	bold string
+	code string
+}
+
+var (
+	plainText = textStyle{}
+	markdown  = textStyle{bold: "**", code: "`"}
+)
+
+func (r *Report) format(style textStyle) string {
+	var str strings.Builder
+	if r.Halted != "" {
+		str.WriteString(fmt.Sprintf("%sPossum didn't make these changes%s: %s\n\n", style.bold, style.bold, r.Halted))
+	}
+	for _, result := range r.Results {
+		str.WriteString(fmt.Sprintf("%s%s%s (%s)\n", style.bold, result.Account, style.bold, result.Region))
+		for _, a := range result.Changes {
+			str.WriteString(fmt.Sprintf(" • %s %s%s%s (%s, %s)", a.Action, style.code, a.Name, style.code, a.Type, *a.ID))
+			if a.Mode != "" && a.Mode != BothMode {
+				str.WriteString(fmt.Sprintf(" [%s]", a.Mode))
+			}
+			if a.Action == WarnAction {
+				str.WriteString(fmt.Sprintf(" stops at %s", a.Due.Format(time.Kitchen+" MST")))
+			}
+			if a.Action == HoldAction {
+				str.WriteString(fmt.Sprintf(" changed by hand, left alone until %s", a.Due.Format(time.RFC1123)))
+			}
+			if a.Action == ApprovalAction && a.Error == "" {
+				id := ApprovalID(result.Account, result.Region, a.Type, *a.ID)
+				str.WriteString(fmt.Sprintf(" waits for approval until %s, approve it with %spossum-cli approve %s%s", a.Due.Format(time.RFC1123), style.code, id, style.code))
+			}
+			if a.Action == NoopAction && a.Error != "" {
+				str.WriteString(fmt.Sprintf(" left alone: %s", a.Error))
+			} else if a.Error != "" {
+				str.WriteString(fmt.Sprintf(" failed: %s", a.Error))
+			}
+			if a.Rejected != "" {
+				str.WriteString(fmt.Sprintf(" rejected by %s", a.Rejected))
+			}
+			str.WriteString("\n")
+		}
+		str.WriteString("\n")
+	}
+
+	if len(r.Unreachable) > 0 {
+		str.WriteString(fmt.Sprintf("%sUnreachable accounts%s\n", style.bold, style.bold))
+		for _, msg := range r.Unreachable {
+			str.WriteString(fmt.Sprintf(" • %s\n", msg))
+		}
+	}
+	return str.String()
+}
@@ -0,0 +1,86 @@
+package possum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestReport_Sort(t *testing.T) {
+	report := &Report{}
+	report.Add("staging", "us-east-1", Changes{{ID: aws.String("i-1")}})
+	report.Add("playpen", "us-east-1", Changes{{ID: aws.String("i-2")}})
+	report.Add("playpen", "ap-southeast-2", Changes{{ID: aws.String("i-3")}})
+	report.Add("playpen", "eu-west-1", nil) // empty changes are not added
+
+	report.Sort()
+
+	expected := []string{"playpen ap-southeast-2", "playpen us-east-1", "staging us-east-1"}
+	if len(report.Results) != len(expected) {
+		t.Errorf("expected %d results, got %d", len(expected), len(report.Results))
+		return
+	}
+	for i, result := range report.Results {
+		if actual := result.Account + " " + result.Region; actual != expected[i] {
+			t.Errorf("expected result %d to be %s, got %s", i, expected[i], actual)
+		}
+	}
+}
+
+func TestReport_String(t *testing.T) {
+	report := newTestReport()
+	report.Unreachable = []string{"staging: could not assume role"}
+
+	expected := "playpen (ap-southeast-2)\n • stop dev-box (instance, i-1)\n\nUnreachable accounts\n • staging: could not assume role\n"
+	if actual := report.String(); actual != expected {
+		t.Errorf("Expected: %q\n Got: %q", expected, actual)
+	}
+
+	if report.Empty() {
+		t.Errorf("expected report to not be empty")
+	}
+	if !(&Report{}).Empty() {
+		t.Errorf("expected new report to be empty")
+	}
+}
+
+func TestReport_Halted(t *testing.T) {
+	report := newTestReport()
+	report.Halted = "1 starts and stops are more than the limit of 0"
+
+	expected := "Possum didn't make these changes: 1 starts and stops are more than the limit of 0\n\nplaypen (ap-southeast-2)\n • stop dev-box (instance, i-1)\n\n"
+	if actual := report.String(); actual != expected {
+		t.Errorf("Expected: %q\n Got: %q", expected, actual)
+	}
+	if records := NewAuditRecords(report, ScheduleActor, "req-1"); len(records) != 0 {
+		t.Errorf("expected the planned changes to be left out of the audit log, got %d records", len(records))
+	}
+	if (&Report{Halted: "halted"}).Empty() {
+		t.Errorf("expected a halted report to not be empty")
+	}
+}
+
+func TestReport_Rejected(t *testing.T) {
+	report := &Report{}
+	report.Add("prod", "ap-southeast-2", Changes{
+		{ID: aws.String("i-1"), Name: "api", Action: StopAction, Type: InstanceResource, Rejected: "prod-window: outside the change window 'Sat 02:00-06:00'"},
+	})
+
+	expected := "prod (ap-southeast-2)\n • stop api (instance, i-1) rejected by prod-window: outside the change window 'Sat 02:00-06:00'\n\n"
+	if actual := report.String(); actual != expected {
+		t.Errorf("Expected: %q\n Got: %q", expected, actual)
+	}
+}
+
+func TestReport_Approval(t *testing.T) {
+	report := &Report{}
+	report.Add("prod", "us-east-1", Changes{
+		{ID: aws.String("reports"), Name: "reports", Action: ApprovalAction, Type: DBInstanceResource, Due: time.Date(2018, 5, 8, 8, 0, 0, 0, time.UTC)},
+	})
+
+	expected := "prod (us-east-1)\n • approval reports (rds, reports) waits for approval until Tue, 08 May 2018 08:00:00 UTC, approve it with possum-cli approve prod/us-east-1/rds/reports\n\n"
+	if actual := report.String(); actual != expected {
+		t.Errorf("Expected: %q\n Got: %q", expected, actual)
+	}
+}
@@ -0,0 +1,206 @@
+package possum
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Resource is a resource that is tagged with a possum schedule
+type Resource struct {
+	ID             string
+	Name           string
+	Type           string
+	Schedule       string
+	State          string    // the state as reported by AWS, e.g. running, stopped or available
+	Running        bool      // true if the resource is running, from the point of view of a schedule
+	OverrideUntil  time.Time // possum leaves the resource alone until then
+	Protected      bool      // possum never starts or stops the resource
+	minSize        int64     // auto scaling groups are started with this min size
+	currentMinSize int64
+	arn            string
+}
+
+// ResourceKey identifies a resource across accounts and regions, e.g. prod/us-east-1/rds/reports-db
+func ResourceKey(account, region, resourceType, id string) string {
+	return strings.Join([]string{account, region, resourceType, id}, "/")
+}
+
+// Overridden returns true if possum should leave the resource alone at ts
+func (r *Resource) Overridden(ts time.Time) bool {
+	return ts.Before(r.OverrideUntil)
+}
+
+// Change returns a change that applies the action to the resource
+func (r *Resource) Change(action ScheduledAction) Change {
+	return Change{
+		ID:             aws.String(r.ID),
+		Name:           r.Name,
+		Action:         action,
+		Type:           r.Type,
+		Schedule:       r.Schedule,
+		minSize:        r.minSize,
+		currentMinSize: r.currentMinSize,
+		arn:            r.arn,
+	}
+}
+
+// ListResources returns every resource that is tagged with a schedule, regardless of its state
+func ListResources(ctx context.Context, clients *Clients, tags *TagKeys) ([]*Resource, error) {
+	tags = tags.resolved()
+	var resources []*Resource
+
+	instances, err := getInstances(ctx, clients.EC2, tags)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range instances {
+		resources = append(resources, &Resource{
+			ID:            *a.resource.InstanceId,
+			Name:          *getInstanceName(a.resource),
+			Type:          InstanceResource,
+			Schedule:      a.schedule,
+			State:         *a.resource.State.Name,
+			Running:       *a.resource.State.Name == ec2.InstanceStateNameRunning,
+			OverrideUntil: parseOverride(getEC2TagValue(a.resource.Tags, tags.OverrideUntil)),
+			Protected:     tagEnabled(getEC2TagValue(a.resource.Tags, tags.Protected)),
+		})
+	}
+
+	groups, err := getAutoScalingGroups(ctx, clients.AutoScaling, tags)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range groups {
+		group := a.resource
+		state := "stopped"
+		if len(group.Instances) > 0 {
+			state = "running"
+		}
+		resources = append(resources, &Resource{
+			ID:             *group.AutoScalingGroupName,
+			Name:           *getASGName(group),
+			Type:           AutoScalingGroupResource,
+			Schedule:       a.schedule,
+			State:          state,
+			Running:        len(group.Instances) > 0,
+			OverrideUntil:  parseOverride(getASGTagValue(group.Tags, tags.OverrideUntil)),
+			Protected:      tagEnabled(getASGTagValue(group.Tags, tags.Protected)),
+			minSize:        getASGTagInt64(group.Tags, tags.MinSize, 1),
+			currentMinSize: *group.MinSize,
+		})
+	}
+
+	dbInstances, err := getDBInstances(ctx, clients.RDS, tags)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range dbInstances {
+		resources = append(resources, &Resource{
+			ID:            *a.resource.DBInstanceIdentifier,
+			Name:          *a.resource.DBInstanceIdentifier,
+			Type:          DBInstanceResource,
+			Schedule:      a.schedule,
+			State:         *a.resource.DBInstanceStatus,
+			Running:       *a.resource.DBInstanceStatus == "available",
+			OverrideUntil: parseOverride(getRDSTagValue(a.tags, tags.OverrideUntil)),
+			Protected:     tagEnabled(getRDSTagValue(a.tags, tags.Protected)),
+			arn:           aws.StringValue(a.resource.DBInstanceArn),
+		})
+	}
+
+	return resources, nil
+}
+
+// Plan returns the changes that possum would make at ts, without making them
+func Plan(ctx context.Context, clients *Clients, ts time.Time, schedules Schedules, tags *TagKeys) (Changes, error) {
+	changes, _, err := PlanResources(ctx, clients, ts, schedules, tags)
+	return changes, err
+}
+
+// PlanResources returns the changes that possum would make at ts and the number of scheduled resources they're out of
+func PlanResources(ctx context.Context, clients *Clients, ts time.Time, schedules Schedules, tags *TagKeys) (Changes, int, error) {
+	tags = tags.resolved()
+	instances, err := getInstances(ctx, clients.EC2, tags)
+	if err != nil {
+		return nil, 0, err
+	}
+	changes := getInstanceChanges(ctx, instances, ts, schedules, tags)
+	resources := len(instances)
+
+	groups, err := getAutoScalingGroups(ctx, clients.AutoScaling, tags)
+	if err != nil {
+		return changes, resources, err
+	}
+	changes = changes.Append(getASGGroupChanges(ctx, groups, ts, schedules, tags))
+	resources += len(groups)
+
+	dbInstances, err := getDBInstances(ctx, clients.RDS, tags)
+	if err != nil {
+		return changes, resources, err
+	}
+	return changes.Append(getDBInstanceChanges(ctx, dbInstances, ts, schedules, tags)), resources + len(dbInstances), nil
+}
+
+// Perform applies changes to resources of any type and tags them with the last action, changes that fail are marked
+// with their error
+func Perform(ctx context.Context, clients *Clients, changes Changes, tags *TagKeys) error {
+	return Apply(ctx, clients, changes, time.Now(), tags)
+}
+
+// Apply makes the changes that were planned at ts, like the Do functions do after planning: it starts and stops the
+// resources, tags them with the last action and holds the ones that were changed by hand
+func Apply(ctx context.Context, clients *Clients, changes Changes, ts time.Time, tags *TagKeys) error {
+	tags = tags.resolved()
+	var firstErr error
+	perform := func(resourceType string, fn func(Changes) error) {
+		var indexes []int
+		var list Changes
+		for i, change := range changes {
+			if change.Type == resourceType {
+				indexes = append(indexes, i)
+				list = append(list, change)
+			}
+		}
+		if len(list) == 0 {
+			return
+		}
+		if err := fn(list); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		for j, i := range indexes {
+			changes[i].Error = list[j].Error
+			logChange(ctx, changes[i])
+		}
+	}
+
+	perform(InstanceResource, func(list Changes) error {
+		return firstError(performInstanceChanges(ctx, clients.EC2, list), tagInstancesLastAction(ctx, clients.EC2, list, ts, tags), holdInstances(ctx, clients.EC2, list, tags))
+	})
+	perform(AutoScalingGroupResource, func(list Changes) error {
+		return firstError(performASGChanges(clients.AutoScaling, list, tags), tagASGLastAction(ctx, clients.AutoScaling, list, ts, tags), holdASGs(ctx, clients.AutoScaling, list, tags))
+	})
+	perform(DBInstanceResource, func(list Changes) error {
+		return firstError(performDBInstanceChanges(clients.RDS, list), tagDBInstancesLastAction(ctx, clients.RDS, list, ts, tags), holdDBInstances(ctx, clients.RDS, list, tags))
+	})
+	return firstErr
+}
+
+// logChange logs a change that was made, or why it failed, with the resource and action as fields
+func logChange(ctx context.Context, change Change) {
+	logger := LoggerFrom(ctx).WithChange(change)
+	switch {
+	case change.Action == NoopAction:
+		// invalid schedules are logged when the changes are planned
+		logger.Debugf("%s '%s': %s", change.Action, change.Name, change.Error)
+	case change.Error != "":
+		logger.Errorf("%s '%s' failed: %s", change.Action, change.Name, change.Error)
+	case change.Action == StartAction || change.Action == StopAction:
+		logger.Infof("%s '%s'", change.Action, change.Name)
+	default:
+		logger.Debugf("%s '%s'", change.Action, change.Name)
+	}
+}
@@ -0,0 +1,198 @@
+package possum
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+func TestListResources(t *testing.T) {
+	until := time.Date(2018, 5, 7, 12, 0, 0, 0, time.UTC)
+
+	instances := makeInstanceSchedule("i-1", "OfficeHours", ec2.InstanceStateNameStopped, false)
+	instances[0].resource.Tags = append(instances[0].resource.Tags,
+		&ec2.Tag{Key: aws.String("Name"), Value: aws.String("web")},
+		&ec2.Tag{Key: aws.String(defaultTags.OverrideUntil), Value: aws.String(until.Format(time.RFC3339))},
+	)
+
+	clients := &Clients{
+		EC2: &mockEC2Client{describeInstanceResult: []*ec2.Instance{instances[0].resource}},
+		AutoScaling: &mockAutoscalingClient{
+			describeTagsResult: []*autoscaling.TagDescription{
+				{ResourceId: aws.String("asg"), Key: aws.String(defaultTags.Schedule), Value: aws.String("Weekdays")},
+			},
+			describeAutoScalingGroupsResult: []*autoscaling.Group{
+				{
+					AutoScalingGroupName: aws.String("asg"),
+					MinSize:              aws.Int64(2),
+					Instances:            []*autoscaling.Instance{{}, {}},
+					Tags: []*autoscaling.TagDescription{
+						{Key: aws.String(defaultTags.MinSize), Value: aws.String("2")},
+					},
+				},
+			},
+		},
+		RDS: &mockRDSClient{
+			describeDBInstancesResult: []*rds.DBInstance{
+				{DBInstanceIdentifier: aws.String("db"), DBInstanceStatus: aws.String("available")},
+			},
+			listTagsForResource: []*rds.Tag{
+				{Key: aws.String(defaultTags.Schedule), Value: aws.String("OfficeHours")},
+			},
+		},
+	}
+
+	resources, err := ListResources(context.Background(), clients, defaultTags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 3 {
+		t.Fatalf("expected 3 resources, got %d", len(resources))
+	}
+
+	tests := []struct {
+		id, name, resourceType, schedule string
+		running, overridden              bool
+	}{
+		{"i-1", "web", InstanceResource, "OfficeHours", false, true},
+		{"asg", "asg", AutoScalingGroupResource, "Weekdays", true, false},
+		{"db", "db", DBInstanceResource, "OfficeHours", true, false},
+	}
+	for i, test := range tests {
+		r := resources[i]
+		if r.ID != test.id || r.Name != test.name || r.Type != test.resourceType || r.Schedule != test.schedule {
+			t.Errorf("case %d. expected %s %s (%s, %s), got %s %s (%s, %s)", i+1, test.resourceType, test.id, test.name, test.schedule, r.Type, r.ID, r.Name, r.Schedule)
+		}
+		if r.Running != test.running {
+			t.Errorf("case %d. expected running to be %t", i+1, test.running)
+		}
+		if r.Overridden(until.Add(-time.Minute)) != test.overridden {
+			t.Errorf("case %d. expected overridden to be %t", i+1, test.overridden)
+		}
+	}
+
+	change := resources[1].Change(StopAction)
+	if change.minSize != 2 || change.currentMinSize != 2 || *change.ID != "asg" {
+		t.Errorf("expected the change to carry the auto scaling group sizes")
+	}
+}
+
+func TestPerform(t *testing.T) {
+	clients := &Clients{
+		EC2:         &mockEC2Client{},
+		AutoScaling: &mockAutoscalingClient{},
+		RDS:         &mockRDSClient{},
+	}
+
+	changes := Changes{
+		(&Resource{ID: "i-1", Type: InstanceResource}).Change(StartAction),
+		(&Resource{ID: "db", Type: DBInstanceResource, arn: "arn:aws:rds:ap-southeast-2:123456789012:db:db"}).Change(StopAction),
+	}
+	if err := Perform(context.Background(), clients, changes, defaultTags); err != nil {
+		t.Fatal(err)
+	}
+
+	if started := clients.EC2.(*mockEC2Client).startInstances; len(started) != 1 || *started[0] != "i-1" {
+		t.Errorf("expected i-1 to be started")
+	}
+	if stopped := clients.RDS.(*mockRDSClient).stoppedInstances; stopped != 1 {
+		t.Errorf("expected 1 db instance to be stopped, got %d", stopped)
+	}
+	if updated := clients.AutoScaling.(*mockAutoscalingClient).updateAutoScalingGroupDesiredInput; len(updated) != 0 {
+		t.Errorf("expected no auto scaling group changes, got %d", len(updated))
+	}
+
+	if tags := clients.EC2.(*mockEC2Client).createTagsInput; len(tags) != 1 || *tags[0].Tags[0].Value != "start" {
+		t.Errorf("expected i-1 to be tagged with the last action")
+	}
+	if tags := clients.RDS.(*mockRDSClient).addTagsToResourceInput; len(tags) != 1 || *tags[0].ResourceName != "arn:aws:rds:ap-southeast-2:123456789012:db:db" {
+		t.Errorf("expected db to be tagged by its ARN")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	office := NewSchedule("OfficeHours")
+	p, _ := NewPeriod("08:00", "18:00", nil)
+	office.AddPeriod(time.Local.String(), p)
+	office.Mode = StopOnlyMode
+
+	running := makeInstanceSchedule("i-1", office.Name, ec2.InstanceStateNameRunning, false)
+	clients := &Clients{
+		EC2:         &mockEC2Client{describeInstanceResult: []*ec2.Instance{running[0].resource}},
+		AutoScaling: &mockAutoscalingClient{},
+		RDS:         &mockRDSClient{},
+	}
+
+	changes, err := Plan(context.Background(), clients, newWeekday(time.Monday, 22, 0), Schedules{office}, defaultTags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Action != StopAction || changes[0].Mode != StopOnlyMode {
+		t.Fatalf("expected a stop-only stop to be planned, got %v", changes)
+	}
+	if stopped := clients.EC2.(*mockEC2Client).stopInstances; len(stopped) != 0 {
+		t.Errorf("expected plan not to stop anything")
+	}
+}
+
+func TestPlanResources_Protected(t *testing.T) {
+	office := NewSchedule("OfficeHours")
+	p, _ := NewPeriod("08:00", "18:00", nil)
+	office.AddPeriod(time.Local.String(), p)
+
+	var instances []*ec2.Instance
+	for _, protected := range []string{"", "true", "false"} {
+		list := makeInstanceSchedule(fmt.Sprintf("i-%d", len(instances)+1), office.Name, ec2.InstanceStateNameRunning, false)
+		if protected != "" {
+			list[0].resource.Tags = append(list[0].resource.Tags, &ec2.Tag{Key: aws.String(defaultTags.Protected), Value: aws.String(protected)})
+		}
+		instances = append(instances, list[0].resource)
+	}
+	clients := &Clients{
+		EC2:         &mockEC2Client{describeInstanceResult: instances},
+		AutoScaling: &mockAutoscalingClient{},
+		RDS:         &mockRDSClient{},
+	}
+
+	changes, resources, err := PlanResources(context.Background(), clients, newWeekday(time.Monday, 22, 0), Schedules{office}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources != 3 {
+		t.Errorf("expected 3 scheduled resources, got %d", resources)
+	}
+	if len(changes) != 2 || *changes[0].ID != "i-1" || *changes[1].ID != "i-3" {
+		t.Fatalf("expected the protected instance to be left alone, got %v", changes)
+	}
+	if changes[0].tags[defaultTags.Schedule] != office.Name {
+		t.Errorf("expected the changes to have the tags of their resource for the policies, got %v", changes[0].tags)
+	}
+}
+
+func TestApply(t *testing.T) {
+	until := time.Date(2018, 5, 7, 8, 0, 0, 0, time.UTC)
+	clients := &Clients{EC2: &mockEC2Client{}, AutoScaling: &mockAutoscalingClient{}, RDS: &mockRDSClient{}}
+	changes := Changes{
+		{ID: aws.String("i-1"), Type: InstanceResource, Action: StopAction},
+		{ID: aws.String("i-2"), Type: InstanceResource, Action: HoldAction, Due: until},
+		{ID: aws.String("i-3"), Type: InstanceResource, Action: WarnAction},
+	}
+
+	if err := Apply(context.Background(), clients, changes, until.Add(-time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	client := clients.EC2.(*mockEC2Client)
+	if len(client.stopInstances) != 1 || *client.stopInstances[0] != "i-1" {
+		t.Errorf("expected i-1 to be stopped, got %v", client.stopInstances)
+	}
+	// the last action of the stop and the override of the hold
+	if len(client.createTagsInput) != 2 || *client.createTagsInput[1].Resources[0] != "i-2" || *client.createTagsInput[1].Tags[0].Key != defaultTags.OverrideUntil {
+		t.Errorf("expected the hold to override the schedule of i-2, got %v", client.createTagsInput)
+	}
+}
@@ -3,18 +3,61 @@ package possum
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
 const (
+	WarnAction  ScheduledAction = -2 // a stop action is coming up soon
 	StopAction  ScheduledAction = -1
 	NoopAction  ScheduledAction = 0
 	StartAction ScheduledAction = 1
+	HoldAction  ScheduledAction = 2 // someone changed the resource by hand, possum leaves it alone for now
+	// a stop of a resource that requires approval, possum stops it once someone approves the stop
+	ApprovalAction ScheduledAction = 3
 )
 
-const scheduleTag = "possum:schedule" // OfficeHours
+// Mode limits the actions of a schedule, so that possum for example only stops resources at night
+type Mode string
+
+const (
+	BothMode      Mode = "both" // the default
+	StartOnlyMode Mode = "start-only"
+	StopOnlyMode  Mode = "stop-only"
+)
+
+// Valid returns true if the mode is known, an empty mode is the same as BothMode
+func (m Mode) Valid() bool {
+	return m == "" || m == BothMode || m == StartOnlyMode || m == StopOnlyMode
+}
+
+// Allows returns true if the mode allows the action, warnings are only sent if stops are allowed
+func (m Mode) Allows(action ScheduledAction) bool {
+	switch m {
+	case StartOnlyMode:
+		return action != StopAction && action != WarnAction
+	case StopOnlyMode:
+		return action != StartAction
+	}
+	return true
+}
+
+// resourceMode returns the mode in the resource tag, or else the mode of the schedule
+func resourceMode(logger *Logger, schedule *Schedule, key string, tag *string, name string) Mode {
+	if tag != nil {
+		if mode := Mode(*tag); mode.Valid() {
+			return mode
+		}
+		logger.Warnf("invalid %s '%s' for '%s', using the mode of schedule '%s'", key, *tag, name, schedule.Name)
+	}
+	return schedule.Mode
+}
+
+// warningWindow is how often possum runs, a warning is sent in the one run that falls within the window after the
+// warning time. This should match the schedule rate of the lambda function.
+const warningWindow = 5 * time.Minute
 
 func AllWeekdays() []time.Weekday {
 	return []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
@@ -28,11 +71,32 @@ func (s ScheduledAction) String() string {
 		return "start"
 	case StopAction:
 		return "stop"
+	case WarnAction:
+		return "warn"
+	case HoldAction:
+		return "hold"
+	case ApprovalAction:
+		return "approval"
 	default:
 		return "noop"
 	}
 }
 
+// MarshalText makes actions readable in JSON, e.g. in webhook notifications
+func (s ScheduledAction) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *ScheduledAction) UnmarshalText(b []byte) error {
+	for _, action := range []ScheduledAction{StartAction, StopAction, NoopAction, WarnAction, HoldAction, ApprovalAction} {
+		if action.String() == string(b) {
+			*s = action
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown action '%s'", b)
+}
+
 func NewSchedule(name string) *Schedule {
 	return &Schedule{
 		Name: name,
@@ -51,81 +115,225 @@ func (s Schedules) Find(name string) *Schedule {
 }
 
 type Schedule struct {
-	Name      string
-	Locations []*time.Location
-	Periods   []*Period
+	Name       string `schema:"required"`
+	Periods    []*Period
+	WarnBefore int `schema:"minimum=0"` // Minutes before a stop to send a warning, no warning is sent if 0
+	// Leave resources that were started or stopped by hand alone until the next start or stop of the schedule
+	RespectManualChanges bool
+	Mode                 Mode // Only start or only stop resources, both if empty
+
+	Extends  string    // The name of a schedule to inherit the periods and unset settings from
+	Timezone string    // The timezone of periods that don't name one, also moves the inherited periods into it
+	Exclude  []*Period // Inherited periods to leave out
+
+	// The stored version, a schedule that someone else changed since it was read isn't stored again
+	Version int `schema:"minimum=0"`
+
+	definition *Schedule // the schedule as it was stored, before it was composed with the schedule it extends
 }
 
+// AddPeriod adds a copy of the period in the timezone
 func (s *Schedule) AddPeriod(timezone string, period *Period) error {
 	loc, err := time.LoadLocation(timezone)
 	if err != nil {
 		return err
 	}
-	s.Locations = append(s.Locations, loc)
-	s.Periods = append(s.Periods, period)
+	p := *period
+	p.Location = loc
+	s.Periods = append(s.Periods, &p)
 	return nil
 }
 
+// Action returns what to do with a resource at t, it should run while any of the periods contains t. A schedule
+// without periods leaves resources alone.
 func (s *Schedule) Action(t time.Time, isRunning bool) ScheduledAction {
-	for i, rule := range s.Periods {
+	if len(s.Periods) == 0 {
+		return NoopAction
+	}
+	inPeriod := false
+	for _, rule := range s.Periods {
 		// convert t into the timeZone
-		convertedTime := t.In(s.Locations[i])
+		if rule.InPeriod(t.In(rule.location())) {
+			inPeriod = true
+			break
+		}
+	}
+
+	if inPeriod && !isRunning {
+		return StartAction
+	}
+	if !inPeriod && isRunning {
+		return StopAction
+	}
+	return NoopAction
+}
+
+// NextStop returns the first time after t at which a running resource will be stopped by the schedule
+func (s *Schedule) NextStop(t time.Time) (time.Time, bool) {
+	return s.nextTransition(t, true, StopAction)
+}
 
-		if rule.InPeriod(convertedTime) && !isRunning {
-			return StartAction
+// NextStart returns the first time after t at which a stopped resource will be started by the schedule
+func (s *Schedule) NextStart(t time.Time) (time.Time, bool) {
+	return s.nextTransition(t, false, StartAction)
+}
+
+// Warn returns the time of the next stop if a warning about it should be sent at t
+func (s *Schedule) Warn(t time.Time) (time.Time, bool) {
+	if s.WarnBefore <= 0 {
+		return time.Time{}, false
+	}
+	stop, ok := s.NextStop(t)
+	if !ok {
+		return time.Time{}, false
+	}
+	warnAt := stop.Add(-time.Duration(s.WarnBefore) * time.Minute)
+	return stop, !t.Before(warnAt) && t.Before(warnAt.Add(warningWindow))
+}
+
+// ManualChange returns true if a resource isn't in the state that possum last left it in, and the schedule hasn't
+// started or stopped anything since. The returned time is the next start or stop, when possum takes over again.
+func (s *Schedule) ManualChange(t time.Time, isRunning bool, lastAction ScheduledAction, lastActionAt time.Time) (time.Time, bool) {
+	if !s.RespectManualChanges || lastActionAt.IsZero() || lastActionAt.After(t) {
+		return time.Time{}, false
+	}
+
+	var next time.Time
+	var ok bool
+	switch lastAction {
+	case StartAction:
+		if isRunning {
+			return time.Time{}, false
 		}
-		if !rule.InPeriod(convertedTime) && isRunning {
-			return StopAction
+		next, ok = s.NextStop(lastActionAt)
+	case StopAction:
+		if !isRunning {
+			return time.Time{}, false
 		}
+		next, ok = s.NextStart(lastActionAt)
 	}
-	return NoopAction
+	return next, ok && next.After(t)
 }
 
-func (s *Schedule) MarshalJSON() ([]byte, error) {
-	type Alias Schedule
+// nextTransition finds the first period boundary after t where the schedule changes from a noop to the action
+func (s *Schedule) nextTransition(t time.Time, isRunning bool, action ScheduledAction) (time.Time, bool) {
+	var candidates []time.Time
+	for _, period := range s.Periods {
+		local := t.In(period.location())
+		for day := -1; day <= 7; day++ {
+			// noon is on the right day, whatever the clocks do at night
+			noon := time.Date(local.Year(), local.Month(), local.Day()+day, 12, 0, 0, 0, local.Location())
+			c := period.start(noon)
+			if action == StopAction {
+				// the stop time is still part of the period, so the stop happens the minute after
+				c = period.end(noon)
+			}
+			if c.After(t) {
+				candidates = append(candidates, c)
+			}
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Before(candidates[j])
+	})
+
+	for _, c := range candidates {
+		if s.Action(c, isRunning) == action && s.Action(c.Add(-time.Minute), isRunning) == NoopAction {
+			return c, true
+		}
+	}
+	return time.Time{}, false
+}
 
-	var locations []string
+// stored returns the schedule as it was defined, before it was composed with the schedule it extends
+func (s *Schedule) stored() *Schedule {
+	if s.definition != nil {
+		return s.definition
+	}
+	return s
+}
 
-	for _, a := range s.Locations {
-		locations = append(locations, a.String())
+func (s *Schedule) MarshalJSON() ([]byte, error) {
+	// a composed schedule is stored as it was defined, so that the inherited periods aren't duplicated
+	if s.definition != nil {
+		return s.definition.MarshalJSON()
 	}
+
 	return json.Marshal(&struct {
-		Name      string
-		Locations []string
-		Periods   []*Period
+		Name                 string
+		Periods              []*Period
+		WarnBefore           int       `json:",omitempty"`
+		RespectManualChanges bool      `json:",omitempty"`
+		Mode                 Mode      `json:",omitempty"`
+		Extends              string    `json:",omitempty"`
+		Timezone             string    `json:",omitempty"`
+		Exclude              []*Period `json:",omitempty"`
+		Version              int       `json:",omitempty"`
 	}{
-		Name:      s.Name,
-		Locations: locations,
-		Periods:   s.Periods,
+		Name:                 s.Name,
+		Periods:              s.Periods,
+		WarnBefore:           s.WarnBefore,
+		RespectManualChanges: s.RespectManualChanges,
+		Mode:                 s.Mode,
+		Extends:              s.Extends,
+		Timezone:             s.Timezone,
+		Exclude:              s.Exclude,
+		Version:              s.Version,
 	})
 }
 
 func (s *Schedule) UnmarshalJSON(b []byte) error {
 	var tmp struct {
-		Name    string
-		Periods []*Period
+		Name                 string
+		Locations            []string // the timezones of the periods, before they were part of the period
+		Periods              []*Period
+		WarnBefore           int
+		RespectManualChanges bool
+		Mode                 Mode
+		Extends              string
+		Timezone             string
+		Exclude              []*Period
+		Version              int
 	}
 	if err := json.Unmarshal(b, &tmp); err != nil {
 		return err
 	}
+	if !tmp.Mode.Valid() {
+		return fmt.Errorf("schedule '%s' has an invalid mode '%s', use one of %s, %s or %s", tmp.Name, tmp.Mode, BothMode, StartOnlyMode, StopOnlyMode)
+	}
 	s.Name = tmp.Name
 	s.Periods = tmp.Periods
-
-	// we need to manually parse the
-	var d map[string]interface{}
-	if err := json.Unmarshal(b, &d); err != nil {
-		return err
+	s.WarnBefore = tmp.WarnBefore
+	s.RespectManualChanges = tmp.RespectManualChanges
+	s.Mode = tmp.Mode
+	s.Extends = tmp.Extends
+	s.Timezone = tmp.Timezone
+	s.Exclude = tmp.Exclude
+	s.Version = tmp.Version
+
+	// migrate the Locations of older configs into the periods, a single location is used for all periods
+	if len(tmp.Locations) > 1 && len(tmp.Locations) != len(tmp.Periods) {
+		return fmt.Errorf("schedule '%s' has %d locations for %d periods, set the Timezone of each period instead", tmp.Name, len(tmp.Locations), len(tmp.Periods))
 	}
-	if locations, ok := d["Locations"].([]interface{}); ok {
-		for _, l := range locations {
-			if name, ok := l.(string); ok {
-				loc, err := time.LoadLocation(name)
-				if err != nil {
-					return err
-				}
-				s.Locations = append(s.Locations, loc)
-			}
+	for i, period := range s.Periods {
+		if period.Location != nil {
+			continue
+		}
+		timezone := s.Timezone
+		if len(tmp.Locations) == 1 {
+			timezone = tmp.Locations[0]
+		} else if len(tmp.Locations) > 1 {
+			timezone = tmp.Locations[i]
+		}
+		if timezone == "" {
+			continue
 		}
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return fmt.Errorf("schedule '%s': %w", tmp.Name, err)
+		}
+		period.Location = loc
 	}
 	return nil
 }
@@ -156,6 +364,14 @@ type Period struct {
 	StartTime *KitchenTime   // The time, in HH:MM format, that the changes will start.
 	StopTime  *KitchenTime   // The time, in HH:MM format, that the changes will stop.
 	Weekdays  []time.Weekday // A list of weekdays that will allow this rule to trigger, if not set, it means all weekdays
+	Location  *time.Location // The timezone of the start and stop times, UTC if not set
+}
+
+func (r *Period) location() *time.Location {
+	if r.Location == nil {
+		return time.UTC
+	}
+	return r.Location
 }
 
 func (r *Period) String() string {
@@ -170,44 +386,25 @@ func (r *Period) String() string {
 	return str
 }
 
+// InPeriod returns true if t is within the period on the local day of t, the stop time is part of the period. See
+// wallClock for how start and stop times are handled around daylight saving changes.
 func (r *Period) InPeriod(t time.Time) bool {
 
 	if !r.inWeekday(t) {
 		return false
 	}
 
-	if r.beforeStart(t) {
-		return false
-	}
-
-	if r.afterStop(t) {
-		return false
-	}
-
-	return true
+	return !t.Before(r.start(t)) && t.Before(r.end(t))
 }
 
-func (r *Period) beforeStart(t time.Time) bool {
-	if r.StartTime.Hour > t.Hour() {
-		return true
-	}
-
-	if r.StopTime.Hour == t.Hour() && r.StartTime.Minute > t.Minute() {
-		return true
-	}
-
-	return false
+// start returns when the period starts on the local day of t
+func (r *Period) start(t time.Time) time.Time {
+	return wallClock(t, r.StartTime.minutes())
 }
 
-func (r *Period) afterStop(t time.Time) bool {
-	if r.StopTime.Hour < t.Hour() {
-		return true
-	}
-	if r.StopTime.Hour == t.Hour() && r.StopTime.Minute < t.Minute() {
-		return true
-	}
-	return false
-
+// end returns the first minute after the period on the local day of t
+func (r *Period) end(t time.Time) time.Time {
+	return wallClock(t, r.StopTime.minutes()+1)
 }
 
 func (r *Period) inWeekday(t time.Time) bool {
@@ -227,15 +424,21 @@ func (r *Period) MarshalJSON() ([]byte, error) {
 	for _, wd := range r.Weekdays {
 		weeksdays = append(weeksdays, wd.String())
 	}
+	var timezone string
+	if r.Location != nil {
+		timezone = r.Location.String()
+	}
 
 	return json.Marshal(&struct {
 		StartTime string
 		StopTime  string
 		Weekdays  []string
+		Timezone  string `json:",omitempty"`
 	}{
 		StartTime: r.StartTime.String(),
 		StopTime:  r.StopTime.String(),
 		Weekdays:  weeksdays,
+		Timezone:  timezone,
 	})
 }
 
@@ -244,6 +447,7 @@ func (r *Period) UnmarshalJSON(b []byte) error {
 		StartTime string
 		StopTime  string
 		Weekdays  []string
+		Timezone  string
 	}{}
 
 	err := json.Unmarshal(b, &alias)
@@ -260,6 +464,12 @@ func (r *Period) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
+	if alias.Timezone != "" {
+		if r.Location, err = time.LoadLocation(alias.Timezone); err != nil {
+			return err
+		}
+	}
+
 	r.Weekdays = []time.Weekday{}
 	for _, sday := range alias.Weekdays {
 		for _, day := range AllWeekdays() {
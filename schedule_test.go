@@ -2,6 +2,7 @@ package possum
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 )
@@ -98,6 +99,35 @@ func TestSchedule_getAction(t *testing.T) {
 	}
 }
 
+func TestSchedule_ActionMultiplePeriods(t *testing.T) {
+	schedule, err := ParseScheduleExpression("Mon-Fri 08:00-18:00 Sat 10:00-14:00 UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2018-05-07 is a Monday and 2018-05-12 a Saturday
+	tests := []struct {
+		t         time.Time
+		isRunning bool
+		expected  ScheduledAction
+	}{
+		{time.Date(2018, 5, 12, 11, 0, 0, 0, time.UTC), true, NoopAction},
+		{time.Date(2018, 5, 12, 11, 0, 0, 0, time.UTC), false, StartAction},
+		{time.Date(2018, 5, 12, 15, 0, 0, 0, time.UTC), true, StopAction},
+		{time.Date(2018, 5, 12, 15, 0, 0, 0, time.UTC), false, NoopAction},
+		{time.Date(2018, 5, 7, 9, 0, 0, 0, time.UTC), true, NoopAction},
+		{time.Date(2018, 5, 7, 9, 0, 0, 0, time.UTC), false, StartAction},
+		{time.Date(2018, 5, 13, 12, 0, 0, 0, time.UTC), true, StopAction},
+		{time.Date(2018, 5, 13, 12, 0, 0, 0, time.UTC), false, NoopAction},
+	}
+
+	for i, test := range tests {
+		if actual := schedule.Action(test.t, test.isRunning); actual != test.expected {
+			t.Errorf("case %d. expected %s, but got %s for %s, isRunning: %t", i+1, test.expected, actual, test.t.Format(time.RFC1123), test.isRunning)
+		}
+	}
+}
+
 func TestPeriod_JSONMarshalling(t *testing.T) {
 	orig, err := NewPeriod("8:00", "9:00", []time.Weekday{time.Monday, time.Saturday})
 	if err != nil {
@@ -158,3 +188,244 @@ func newWeekday(weekday time.Weekday, hour, min int) time.Time {
 	// 2015-5-6 is a sunday
 	return time.Date(2018, 5, 6+int(weekday), hour, min, 0, 0, time.Local)
 }
+
+func TestSchedule_NextStop(t *testing.T) {
+	auckland, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	officeP, _ := NewPeriod("8:00", "19:00", []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday})
+	office := NewSchedule("OfficeHours")
+	office.AddPeriod("Pacific/Auckland", officeP)
+
+	tests := []struct {
+		t             time.Time
+		expectedStop  time.Time
+		expectedStart time.Time
+	}{
+		// 2018-05-07 is a monday
+		{
+			t:             time.Date(2018, 5, 7, 12, 0, 0, 0, auckland),
+			expectedStop:  time.Date(2018, 5, 7, 19, 1, 0, 0, auckland),
+			expectedStart: time.Date(2018, 5, 8, 8, 0, 0, 0, auckland),
+		},
+		{
+			t:             time.Date(2018, 5, 7, 7, 0, 0, 0, auckland),
+			expectedStop:  time.Date(2018, 5, 7, 19, 1, 0, 0, auckland),
+			expectedStart: time.Date(2018, 5, 7, 8, 0, 0, 0, auckland),
+		},
+		{
+			t:             time.Date(2018, 5, 11, 20, 0, 0, 0, auckland), // friday night
+			expectedStop:  time.Date(2018, 5, 14, 19, 1, 0, 0, auckland),
+			expectedStart: time.Date(2018, 5, 14, 8, 0, 0, 0, auckland),
+		},
+	}
+
+	for i, test := range tests {
+		stop, ok := office.NextStop(test.t)
+		if !ok || !stop.Equal(test.expectedStop) {
+			t.Errorf("case %d. expected next stop at %s, got %s", i+1, test.expectedStop, stop)
+		}
+		start, ok := office.NextStart(test.t)
+		if !ok || !start.Equal(test.expectedStart) {
+			t.Errorf("case %d. expected next start at %s, got %s", i+1, test.expectedStart, start)
+		}
+	}
+
+	always, _ := NewPeriod("0:00", "23:59", nil)
+	alwaysSchedule := NewSchedule("Always")
+	alwaysSchedule.AddPeriod("Pacific/Auckland", always)
+	if stop, ok := alwaysSchedule.NextStop(tests[0].t); ok {
+		t.Errorf("expected a schedule that runs around the clock to never stop, got %s", stop)
+	}
+}
+
+func TestSchedule_Warn(t *testing.T) {
+	auckland, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	officeP, _ := NewPeriod("8:00", "19:00", nil)
+	office := NewSchedule("OfficeHours")
+	office.AddPeriod("Pacific/Auckland", officeP)
+	office.WarnBefore = 31
+
+	tests := []struct {
+		t        time.Time
+		expected bool
+	}{
+		{time.Date(2018, 5, 7, 18, 25, 0, 0, auckland), false},
+		{time.Date(2018, 5, 7, 18, 30, 0, 0, auckland), true},
+		{time.Date(2018, 5, 7, 18, 34, 0, 0, auckland), true},
+		{time.Date(2018, 5, 7, 18, 35, 0, 0, auckland), false}, // only warn once
+		{time.Date(2018, 5, 7, 18, 55, 0, 0, auckland), false},
+	}
+
+	for i, test := range tests {
+		stop, actual := office.Warn(test.t)
+		if actual != test.expected {
+			t.Errorf("case %d. expected %t, got %t for %s", i+1, test.expected, actual, test.t)
+		}
+		if actual && !stop.Equal(time.Date(2018, 5, 7, 19, 1, 0, 0, auckland)) {
+			t.Errorf("case %d. expected the warning to be about the 19:01 stop, got %s", i+1, stop)
+		}
+	}
+
+	office.WarnBefore = 0
+	if _, ok := office.Warn(tests[1].t); ok {
+		t.Errorf("expected no warning when WarnBefore is not set")
+	}
+}
+
+func TestSchedule_JSONMarshalling(t *testing.T) {
+	period, _ := NewPeriod("8:00", "19:00", nil)
+	orig := NewSchedule("OfficeHours")
+	orig.AddPeriod("Pacific/Auckland", period)
+	orig.WarnBefore = 15
+	orig.RespectManualChanges = true
+	orig.Mode = StopOnlyMode
+
+	b, err := json.Marshal(orig)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var actual Schedule
+	if err := json.Unmarshal(b, &actual); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if actual.Name != orig.Name || actual.WarnBefore != orig.WarnBefore {
+		t.Errorf("expected %s with WarnBefore %d, got %s with %d", orig.Name, orig.WarnBefore, actual.Name, actual.WarnBefore)
+	}
+	if len(actual.Periods) != 1 || actual.Periods[0].String() != period.String() {
+		t.Errorf("expected period %s, got %v", period, actual.Periods)
+	}
+	if !actual.RespectManualChanges || actual.Mode != StopOnlyMode {
+		t.Errorf("expected RespectManualChanges and mode %s, got %t and %s", StopOnlyMode, actual.RespectManualChanges, actual.Mode)
+	}
+
+	if err := json.Unmarshal([]byte(`{"Name": "OfficeHours", "Mode": "sometimes"}`), &actual); err == nil {
+		t.Errorf("expected an error for an invalid mode")
+	}
+}
+
+func TestSchedule_JSONLocations(t *testing.T) {
+	tests := []struct {
+		json      string
+		timezones []string
+		err       bool
+	}{
+		// configs from before periods had a timezone
+		{`{"Name": "a", "Locations": ["Pacific/Auckland", "Europe/London"], "Periods": [{"StartTime": "08:00", "StopTime": "18:00"}, {"StartTime": "09:00", "StopTime": "17:00"}]}`, []string{"Pacific/Auckland", "Europe/London"}, false},
+		{`{"Name": "a", "Locations": ["Pacific/Auckland"], "Periods": [{"StartTime": "08:00", "StopTime": "18:00"}, {"StartTime": "09:00", "StopTime": "17:00"}]}`, []string{"Pacific/Auckland", "Pacific/Auckland"}, false},
+		{`{"Name": "a", "Locations": ["Pacific/Auckland", "Europe/London"], "Periods": [{"StartTime": "08:00", "StopTime": "18:00"}]}`, nil, true},
+		// the timezone of a period wins over the locations and the schedule timezone
+		{`{"Name": "a", "Timezone": "Europe/London", "Periods": [{"StartTime": "08:00", "StopTime": "18:00", "Timezone": "Pacific/Auckland"}, {"StartTime": "09:00", "StopTime": "17:00"}]}`, []string{"Pacific/Auckland", "Europe/London"}, false},
+		{`{"Name": "a", "Periods": [{"StartTime": "08:00", "StopTime": "18:00"}]}`, []string{"UTC"}, false},
+		{`{"Name": "a", "Periods": [{"StartTime": "08:00", "StopTime": "18:00", "Timezone": "Mars/Olympus"}]}`, nil, true},
+	}
+
+	for i, test := range tests {
+		var actual Schedule
+		err := json.Unmarshal([]byte(test.json), &actual)
+		if test.err {
+			if err == nil {
+				t.Errorf("case %d. expected an error", i+1)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d. %s", i+1, err)
+			continue
+		}
+		for j, period := range actual.Periods {
+			if period.location().String() != test.timezones[j] {
+				t.Errorf("case %d. expected period %d in %s, got %s", i+1, j+1, test.timezones[j], period.location())
+			}
+		}
+
+		// stored schedules keep the timezones in the periods
+		b, err := json.Marshal(&actual)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), "Locations") {
+			t.Errorf("case %d. expected no Locations to be stored, got %s", i+1, b)
+		}
+	}
+}
+
+func TestSchedule_ManualChange(t *testing.T) {
+	auckland, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	officeP, _ := NewPeriod("8:00", "19:00", nil)
+	office := NewSchedule("OfficeHours")
+	office.AddPeriod("Pacific/Auckland", officeP)
+	office.RespectManualChanges = true
+
+	stoppedAt := time.Date(2018, 5, 7, 19, 1, 0, 0, auckland)
+	startedAt := time.Date(2018, 5, 8, 8, 0, 0, 0, auckland)
+
+	tests := []struct {
+		t            time.Time
+		isRunning    bool
+		lastAction   ScheduledAction
+		lastActionAt time.Time
+		expected     bool
+		until        time.Time
+	}{
+		// started by hand in the evening, left alone until the next start
+		{time.Date(2018, 5, 7, 22, 0, 0, 0, auckland), true, StopAction, stoppedAt, true, startedAt},
+		// still stopped like possum left it
+		{time.Date(2018, 5, 7, 22, 0, 0, 0, auckland), false, StopAction, stoppedAt, false, time.Time{}},
+		// the schedule has started and stopped since, so possum is in charge again
+		{time.Date(2018, 5, 8, 22, 0, 0, 0, auckland), true, StopAction, stoppedAt, false, time.Time{}},
+		// stopped by hand during office hours, left alone until the next stop
+		{time.Date(2018, 5, 8, 12, 0, 0, 0, auckland), false, StartAction, startedAt, true, time.Date(2018, 5, 8, 19, 1, 0, 0, auckland)},
+		// possum has never touched the resource
+		{time.Date(2018, 5, 7, 22, 0, 0, 0, auckland), true, NoopAction, time.Time{}, false, time.Time{}},
+	}
+
+	for i, test := range tests {
+		until, actual := office.ManualChange(test.t, test.isRunning, test.lastAction, test.lastActionAt)
+		if actual != test.expected {
+			t.Errorf("case %d. expected %t, got %t", i+1, test.expected, actual)
+		}
+		if actual && !until.Equal(test.until) {
+			t.Errorf("case %d. expected the hold to end at %s, got %s", i+1, test.until, until)
+		}
+	}
+
+	office.RespectManualChanges = false
+	if _, ok := office.ManualChange(tests[0].t, true, StopAction, stoppedAt); ok {
+		t.Errorf("expected manual changes to be ignored unless the schedule opts in")
+	}
+}
+
+func TestMode_Allows(t *testing.T) {
+	tests := []struct {
+		mode                 Mode
+		start, stop, warning bool
+	}{
+		{"", true, true, true},
+		{BothMode, true, true, true},
+		{StartOnlyMode, true, false, false},
+		{StopOnlyMode, false, true, true},
+	}
+	for i, test := range tests {
+		if test.mode.Allows(StartAction) != test.start || test.mode.Allows(StopAction) != test.stop || test.mode.Allows(WarnAction) != test.warning {
+			t.Errorf("case %d. expected %s to allow start %t, stop %t and warnings %t", i+1, test.mode, test.start, test.stop, test.warning)
+		}
+	}
+}
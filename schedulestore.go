@@ -0,0 +1,262 @@
+package possum
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// ScheduleStore keeps the schedules. GetSchedules returns the schedules composed with the schedules they extend, and
+// no schedules if nothing is stored yet. PutSchedules refuses schedules that can't be composed.
+type ScheduleStore interface {
+	GetSchedules(ctx context.Context) (Schedules, error)
+	PutSchedules(ctx context.Context, schedules Schedules) error
+	String() string // where the schedules are stored, for error messages
+}
+
+// NewScheduleStore returns the store at the location, which is one of
+//
+//	dynamodb://table       a DynamoDB table, a location without a scheme is a table name too
+//	s3://bucket/key        an object in an S3 bucket
+//	ssm:///parameter/name  a SSM Parameter Store parameter
+//	file:///path.json      a local file
+func NewScheduleStore(location string, p client.ConfigProvider) (ScheduleStore, error) {
+	if !strings.Contains(location, "://") {
+		location = "dynamodb://" + location
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule store '%s': %w", location, err)
+	}
+
+	switch u.Scheme {
+	case "dynamodb":
+		if u.Host == "" {
+			return nil, fmt.Errorf("schedule store '%s' is missing the table name, e.g. dynamodb://possum-config", location)
+		}
+		return &DynamoDBScheduleStore{Client: dynamodb.New(p), Table: u.Host}, nil
+	case "s3":
+		key := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || key == "" {
+			return nil, fmt.Errorf("schedule store '%s' is missing the bucket or the key, e.g. s3://bucket/schedules.json", location)
+		}
+		return &S3ScheduleStore{Client: s3.New(p), Bucket: u.Host, Key: key}, nil
+	case "ssm":
+		// parameter names with a path start with a slash, ssm:///possum/schedules, those without one don't
+		name := u.Host + u.Path
+		if name == "" {
+			return nil, fmt.Errorf("schedule store '%s' is missing the parameter name, e.g. ssm:///possum/schedules", location)
+		}
+		return &SSMScheduleStore{Client: ssm.New(p), Name: name}, nil
+	case "file":
+		path := u.Host + u.Path
+		if path == "" {
+			return nil, fmt.Errorf("schedule store '%s' is missing the path, e.g. file:///etc/possum/schedules.json", location)
+		}
+		return &FileScheduleStore{Path: path}, nil
+	}
+	return nil, fmt.Errorf("unknown schedule store '%s', use one of dynamodb://, s3://, ssm:// or file://", location)
+}
+
+// ScheduleStoreFromEnv returns the store in the SCHEDULE_STORE env variable, or else the config table in CONFIG_TABLE
+func ScheduleStoreFromEnv(p client.ConfigProvider) (ScheduleStore, error) {
+	location := os.Getenv("SCHEDULE_STORE")
+	if location == "" {
+		location = os.Getenv("CONFIG_TABLE")
+	}
+	if location == "" {
+		return nil, errors.New("env variables SCHEDULE_STORE and CONFIG_TABLE are empty, one of them should say where the schedules are stored, see docs")
+	}
+	return NewScheduleStore(location, p)
+}
+
+// decodeSchedules unmarshals and composes stored schedules in JSON or YAML, no content means no schedules
+func decodeSchedules(b []byte) (Schedules, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, nil
+	}
+	schedules, err := UnmarshalSchedules(b)
+	if err != nil {
+		return nil, err
+	}
+	return schedules.Compose()
+}
+
+// encodeSchedules marshals the schedules as they are defined, so that they can be edited by hand
+func encodeSchedules(schedules Schedules, asYAML bool) ([]byte, error) {
+	if _, err := schedules.Compose(); err != nil {
+		return nil, err
+	}
+	if asYAML {
+		return MarshalSchedulesYAML(schedules)
+	}
+	return json.MarshalIndent(schedules, "", "\t")
+}
+
+// S3ScheduleStore keeps the schedules as JSON in an S3 object, or as YAML if the key ends in .yaml or .yml
+type S3ScheduleStore struct {
+	Client s3iface.S3API
+	Bucket string
+	Key    string
+}
+
+func (s *S3ScheduleStore) GetSchedules(ctx context.Context) (Schedules, error) {
+	out, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Key),
+	})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSchedules(b)
+}
+
+func (s *S3ScheduleStore) PutSchedules(ctx context.Context, schedules Schedules) error {
+	b, err := encodeSchedules(schedules, IsYAMLPath(s.Key))
+	if err != nil {
+		return err
+	}
+	contentType := "application/json"
+	if IsYAMLPath(s.Key) {
+		contentType = "application/yaml"
+	}
+	_, err = s.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(s.Key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+// Revision returns the ETag of the object
+func (s *S3ScheduleStore) Revision(ctx context.Context) (string, error) {
+	out, err := s.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Key),
+	})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && (awsErr.Code() == s3.ErrCodeNoSuchKey || awsErr.Code() == "NotFound") {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.ETag), nil
+}
+
+func (s *S3ScheduleStore) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.Bucket, s.Key)
+}
+
+// SSMScheduleStore keeps the schedules as JSON in a SSM Parameter Store parameter, SecureString parameters are
+// decrypted and YAML parameters are read too
+type SSMScheduleStore struct {
+	Client ssmiface.SSMAPI
+	Name   string
+}
+
+func (s *SSMScheduleStore) GetSchedules(ctx context.Context) (Schedules, error) {
+	out, err := s.Client.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(s.Name),
+		WithDecryption: aws.Bool(true),
+	})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == ssm.ErrCodeParameterNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeSchedules([]byte(aws.StringValue(out.Parameter.Value)))
+}
+
+func (s *SSMScheduleStore) PutSchedules(ctx context.Context, schedules Schedules) error {
+	b, err := encodeSchedules(schedules, false)
+	if err != nil {
+		return err
+	}
+	// standard parameters only hold 4KB, intelligent tiering switches to an advanced parameter for larger schedules
+	_, err = s.Client.PutParameterWithContext(ctx, &ssm.PutParameterInput{
+		Name:      aws.String(s.Name),
+		Value:     aws.String(string(b)),
+		Type:      aws.String(ssm.ParameterTypeString),
+		Tier:      aws.String(ssm.ParameterTierIntelligentTiering),
+		Overwrite: aws.Bool(true),
+	})
+	return err
+}
+
+func (s *SSMScheduleStore) String() string {
+	return "ssm://" + s.Name
+}
+
+// FileScheduleStore keeps the schedules in a local JSON file, or YAML if it ends in .yaml or .yml, e.g. to run possum
+// outside of AWS or to keep the schedules in git
+type FileScheduleStore struct {
+	Path string
+}
+
+func (s *FileScheduleStore) GetSchedules(ctx context.Context) (Schedules, error) {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeSchedules(b)
+}
+
+func (s *FileScheduleStore) PutSchedules(ctx context.Context, schedules Schedules) error {
+	b, err := encodeSchedules(schedules, IsYAMLPath(s.Path))
+	if err != nil {
+		return err
+	}
+	if !IsYAMLPath(s.Path) {
+		b = append(b, '\n')
+	}
+	return os.WriteFile(s.Path, b, 0o644)
+}
+
+// Revision returns the modification time and the size of the file
+func (s *FileScheduleStore) Revision(ctx context.Context) (string, error) {
+	info, err := os.Stat(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %d", info.ModTime().Format(time.RFC3339Nano), info.Size()), nil
+}
+
+func (s *FileScheduleStore) String() string {
+	return "file://" + s.Path
+}
@@ -0,0 +1,183 @@
+package possum
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+func TestNewScheduleStore(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("ap-southeast-2")}))
+
+	tests := []struct {
+		location string
+		expected string // the String() of the store, empty if the location is invalid
+	}{
+		{"possum-config", "dynamodb://possum-config"},
+		{"dynamodb://possum-config", "dynamodb://possum-config"},
+		{"s3://bucket/path/schedules.json", "s3://bucket/path/schedules.json"},
+		{"ssm:///possum/schedules", "ssm:///possum/schedules"},
+		{"ssm://possum-schedules", "ssm://possum-schedules"},
+		{"file:///etc/possum/schedules.json", "file:///etc/possum/schedules.json"},
+		{"s3://bucket", ""},
+		{"ssm://", ""},
+		{"ftp://example.com/schedules.json", ""},
+	}
+
+	for i, test := range tests {
+		store, err := NewScheduleStore(test.location, sess)
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("case %d. expected an error for %s", i+1, test.location)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d. %s", i+1, err)
+			continue
+		}
+		if store.String() != test.expected {
+			t.Errorf("case %d. expected %s, got %s", i+1, test.expected, store)
+		}
+	}
+}
+
+func TestFileScheduleStore(t *testing.T) {
+	store := &FileScheduleStore{Path: filepath.Join(t.TempDir(), "schedules.json")}
+
+	schedules, err := store.GetSchedules(context.Background())
+	if err != nil || len(schedules) != 0 {
+		t.Fatalf("expected no schedules in a missing file, got %v, %v", schedules, err)
+	}
+
+	schedules, err = decodeSchedules([]byte(composeTestSchedules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutSchedules(context.Background(), schedules); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := store.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) != 3 || len(actual.Find("UK Support").Periods) != 2 {
+		t.Errorf("expected the schedules to be read back and composed, got %v", actual)
+	}
+
+	if err := store.PutSchedules(context.Background(), Schedules{{Name: "a", Extends: "b"}}); err == nil {
+		t.Errorf("expected schedules with a missing parent to be refused")
+	}
+}
+
+func TestS3ScheduleStore(t *testing.T) {
+	client := &mockS3Client{objects: make(map[string][]byte)}
+	store := &S3ScheduleStore{Client: client, Bucket: "bucket", Key: "schedules.json"}
+
+	if schedules, err := store.GetSchedules(context.Background()); err != nil || len(schedules) != 0 {
+		t.Fatalf("expected no schedules in a missing object, got %v, %v", schedules, err)
+	}
+	if revision, err := store.Revision(context.Background()); err != nil || revision != "" {
+		t.Errorf("expected no revision of a missing object, got %s, %v", revision, err)
+	}
+
+	if err := store.PutSchedules(context.Background(), Schedules{NewSchedule("OfficeHours")}); err != nil {
+		t.Fatal(err)
+	}
+	if revision, err := store.Revision(context.Background()); err != nil || revision == "" {
+		t.Errorf("expected the ETag of the object, got %s, %v", revision, err)
+	}
+	schedules, err := store.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 1 || schedules[0].Name != "OfficeHours" {
+		t.Errorf("expected the schedules to be read back, got %v", schedules)
+	}
+}
+
+func TestSSMScheduleStore(t *testing.T) {
+	client := &mockSSMClient{parameters: make(map[string]string)}
+	store := &SSMScheduleStore{Client: client, Name: "/possum/schedules"}
+
+	if schedules, err := store.GetSchedules(context.Background()); err != nil || len(schedules) != 0 {
+		t.Fatalf("expected no schedules in a missing parameter, got %v, %v", schedules, err)
+	}
+
+	if err := store.PutSchedules(context.Background(), Schedules{NewSchedule("OfficeHours")}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(client.parameters["/possum/schedules"], `"Name": "OfficeHours"`) {
+		t.Errorf("expected the schedules to be stored in the parameter, got %s", client.parameters["/possum/schedules"])
+	}
+	schedules, err := store.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 1 || schedules[0].Name != "OfficeHours" {
+		t.Errorf("expected the schedules to be read back, got %v", schedules)
+	}
+}
+
+type mockS3Client struct {
+	s3iface.S3API
+	objects map[string][]byte // keyed by bucket/key
+}
+
+func (m *mockS3Client) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, options ...request.Option) (*s3.GetObjectOutput, error) {
+	b, ok := m.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func (m *mockS3Client) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, options ...request.Option) (*s3.HeadObjectOutput, error) {
+	b, ok := m.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+	return &s3.HeadObjectOutput{ETag: aws.String(fmt.Sprintf(`"%x"`, md5.Sum(b)))}, nil
+}
+
+func (m *mockS3Client) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, options ...request.Option) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.objects[*input.Bucket+"/"+*input.Key] = b
+	return &s3.PutObjectOutput{}, nil
+}
+
+type mockSSMClient struct {
+	ssmiface.SSMAPI
+	parameters map[string]string
+}
+
+func (m *mockSSMClient) GetParameterWithContext(ctx aws.Context, input *ssm.GetParameterInput, options ...request.Option) (*ssm.GetParameterOutput, error) {
+	value, ok := m.parameters[*input.Name]
+	if !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "", nil)
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Name: input.Name, Value: aws.String(value)}}, nil
+}
+
+func (m *mockSSMClient) PutParameterWithContext(ctx aws.Context, input *ssm.PutParameterInput, options ...request.Option) (*ssm.PutParameterOutput, error) {
+	m.parameters[*input.Name] = *input.Value
+	return &ssm.PutParameterOutput{}, nil
+}
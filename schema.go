@@ -0,0 +1,338 @@
+package possum
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kitchenTimePattern matches the HH:MM times of periods, 24:00 is the end of the day
+const kitchenTimePattern = `^(([01]?[0-9]|2[0-3]):[0-5][0-9]|24:00)$`
+
+// JSONSchema is the part of JSON Schema that is needed to describe schedules
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 schemaTypes            `json:"type,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Minimum              *int                   `json:"minimum,omitempty"`
+	Deprecated           bool                   `json:"deprecated,omitempty"`
+	Defs                 map[string]*JSONSchema `json:"$defs,omitempty"`
+}
+
+// schemaTypes is written as a single type, or as a list if a value can have more than one, e.g. an array or null
+type schemaTypes []string
+
+func (t schemaTypes) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// schemaOverrides are the types that are stored as something other than their fields
+var schemaOverrides = map[reflect.Type]func() *JSONSchema{
+	reflect.TypeOf(KitchenTime{}): func() *JSONSchema {
+		return &JSONSchema{Type: schemaTypes{"string"}, Pattern: kitchenTimePattern}
+	},
+	reflect.TypeOf(time.Location{}): func() *JSONSchema {
+		return &JSONSchema{Type: schemaTypes{"string"}, Description: "an IANA timezone, e.g. Pacific/Auckland"}
+	},
+	reflect.TypeOf(time.Sunday): func() *JSONSchema {
+		var weekdays []string
+		for _, day := range AllWeekdays() {
+			weekdays = append(weekdays, day.String())
+		}
+		return &JSONSchema{Type: schemaTypes{"string"}, Enum: weekdays}
+	},
+	reflect.TypeOf(BothMode): func() *JSONSchema {
+		return &JSONSchema{Type: schemaTypes{"string"}, Enum: []string{string(BothMode), string(StartOnlyMode), string(StopOnlyMode)}}
+	},
+}
+
+// SchedulesSchema returns the JSON Schema of a list of schedules, it's generated from the Schedule type and the
+// definition of the Period
+func SchedulesSchema() *JSONSchema {
+	defs := make(map[string]*JSONSchema)
+	schema := &JSONSchema{
+		Schema: "https://json-schema.org/draft/2020-12/schema",
+		Title:  "possum schedules",
+		Type:   schemaTypes{"array"},
+		Items:  typeSchema(reflect.TypeOf(Schedule{}), defs),
+		Defs:   defs,
+	}
+
+	// older configs have the timezones of the periods in a list, they're still read
+	defs["Schedule"].Properties["Locations"] = &JSONSchema{
+		Type:        schemaTypes{"array", "null"},
+		Items:       &JSONSchema{Type: schemaTypes{"string"}},
+		Description: "the timezones of the periods, set the Timezone of the schedule or of each period instead",
+		Deprecated:  true,
+	}
+	return schema
+}
+
+// typeSchema returns the schema of a type, structs are added to the definitions and referenced
+func typeSchema(t reflect.Type, defs map[string]*JSONSchema) *JSONSchema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if override, ok := schemaOverrides[t]; ok {
+		return override()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: schemaTypes{"string"}}
+	case reflect.Bool:
+		return &JSONSchema{Type: schemaTypes{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &JSONSchema{Type: schemaTypes{"integer"}}
+	case reflect.Slice:
+		return &JSONSchema{Type: schemaTypes{"array", "null"}, Items: typeSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil // a placeholder, in case the struct refers to itself
+			if t == reflect.TypeOf(Period{}) {
+				defs[t.Name()] = periodSchema(defs)
+			} else {
+				defs[t.Name()] = structSchema(t, defs)
+			}
+		}
+		return &JSONSchema{Ref: "#/$defs/" + t.Name()}
+	}
+	panic(fmt.Sprintf("no JSON schema for %s", t))
+}
+
+// periodSchema returns the schema of a period, which is written by its MarshalJSON rather than as its fields, with the
+// location as the name of its timezone
+func periodSchema(defs map[string]*JSONSchema) *JSONSchema {
+	additional := false
+	return &JSONSchema{
+		Type: schemaTypes{"object"},
+		Properties: map[string]*JSONSchema{
+			"StartTime": typeSchema(reflect.TypeOf(KitchenTime{}), defs),
+			"StopTime":  typeSchema(reflect.TypeOf(KitchenTime{}), defs),
+			"Weekdays":  typeSchema(reflect.TypeOf([]time.Weekday{}), defs),
+			"Timezone":  typeSchema(reflect.TypeOf(time.Location{}), defs),
+		},
+		Required:             []string{"StartTime", "StopTime"},
+		AdditionalProperties: &additional,
+	}
+}
+
+// structSchema returns the schema of the exported fields of a struct. The json tag renames a field and the schema tag
+// can mark it as required or set its minimum.
+func structSchema(t reflect.Type, defs map[string]*JSONSchema) *JSONSchema {
+	additional := false
+	schema := &JSONSchema{
+		Type:                 schemaTypes{"object"},
+		Properties:           make(map[string]*JSONSchema),
+		AdditionalProperties: &additional,
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Tag.Get("json") == "-" {
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag != "" {
+			name = tag
+		}
+
+		property := typeSchema(field.Type, defs)
+		for _, option := range strings.Split(field.Tag.Get("schema"), ",") {
+			switch {
+			case option == "required":
+				schema.Required = append(schema.Required, name)
+			case strings.HasPrefix(option, "minimum="):
+				var min int
+				if _, err := fmt.Sscanf(option, "minimum=%d", &min); err != nil {
+					panic(fmt.Sprintf("invalid schema tag on %s.%s: %s", t.Name(), field.Name, option))
+				}
+				property.Minimum = &min
+			}
+		}
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+// SchemaError is a value that doesn't match the schema, with the line and column it's on
+type SchemaError struct {
+	Line    int
+	Column  int
+	Path    string // e.g. [0].Periods[1].StartTime
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("line %d: %s %s", e.Line, e.Path, e.Message)
+}
+
+// SchemaErrors are all the values in a document that don't match the schema
+type SchemaErrors []*SchemaError
+
+func (e SchemaErrors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ValidateSchedules checks schedules in JSON or YAML against the schema, and then that they can be read and composed.
+// Values that don't match the schema are returned as SchemaErrors.
+func ValidateSchedules(b []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	schema := SchedulesSchema()
+	var errs SchemaErrors
+	schema.validate(doc.Content[0], "", schema.Defs, &errs)
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Line < errs[j].Line
+		})
+		return errs
+	}
+
+	schedules, err := UnmarshalSchedules(b)
+	if err != nil {
+		return err
+	}
+	_, err = schedules.Compose()
+	return err
+}
+
+// validate adds an error for each value in the node, or in its children, that doesn't match the schema
+func (s *JSONSchema) validate(node *yaml.Node, path string, defs map[string]*JSONSchema, errs *SchemaErrors) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if s.Ref != "" {
+		defs[strings.TrimPrefix(s.Ref, "#/$defs/")].validate(node, path, defs, errs)
+		return
+	}
+	fail := func(node *yaml.Node, path, format string, args ...interface{}) {
+		*errs = append(*errs, &SchemaError{Line: node.Line, Column: node.Column, Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	actual := nodeType(node)
+	if len(s.Type) > 0 && !s.hasType(actual) {
+		fail(node, path, "should be %s, not %s", strings.Join(s.Type, " or "), actual)
+		return
+	}
+
+	switch actual {
+	case "array":
+		for i, item := range node.Content {
+			s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), defs, errs)
+		}
+	case "object":
+		found := make(map[string]bool)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			found[key.Value] = true
+			property, ok := s.Properties[key.Value]
+			if !ok {
+				if s.AdditionalProperties == nil || *s.AdditionalProperties {
+					continue
+				}
+				fail(key, path, "has an unknown field '%s', use one of %s", key.Value, strings.Join(s.propertyNames(), ", "))
+				continue
+			}
+			property.validate(value, strings.TrimPrefix(path+"."+key.Value, "."), defs, errs)
+		}
+		for _, name := range s.Required {
+			if !found[name] {
+				fail(node, path, "is missing the required field '%s'", name)
+			}
+		}
+	case "string":
+		if len(s.Enum) > 0 && !contains(s.Enum, node.Value) {
+			fail(node, path, "is '%s', it should be one of %s", node.Value, strings.Join(s.Enum, ", "))
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(node.Value) {
+			fail(node, path, "is '%s', it should match %s", node.Value, s.Pattern)
+		}
+	case "integer":
+		var n int
+		if s.Minimum != nil && node.Decode(&n) == nil && n < *s.Minimum {
+			fail(node, path, "is %d, it should be at least %d", n, *s.Minimum)
+		}
+	}
+}
+
+func (s *JSONSchema) hasType(t string) bool {
+	for _, allowed := range s.Type {
+		if allowed == t || (allowed == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *JSONSchema) propertyNames() []string {
+	var names []string
+	for name, property := range s.Properties {
+		if !property.Deprecated {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// nodeType returns the JSON type of a YAML node
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return "array"
+	case yaml.MappingNode:
+		return "object"
+	}
+	switch node.ShortTag() {
+	case "!!str":
+		return "string"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	case "!!null":
+		return "null"
+	}
+	return node.ShortTag()
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
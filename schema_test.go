@@ -0,0 +1,80 @@
+package possum
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestSchedulesSchema_Published(t *testing.T) {
+	b, err := json.MarshalIndent(SchedulesSchema(), "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := os.ReadFile("schedules.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(published) != string(b)+"\n" {
+		t.Errorf("schedules.schema.json is out of date, run go run ./cmd/possum-cli schema > schedules.schema.json")
+	}
+}
+
+func TestValidateSchedules(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected []string // the schema errors, nil if the schedules are valid
+	}{
+		{composeTestSchedules, nil},
+		{"- Name: a\n  Periods:\n    - StartTime: '08:00'\n      StopTime: '18:00'\n", nil},
+		{
+			"- Name: a\n  WarnBefore: -5\n  Periods:\n    - StartTime: 8am\n      Weekdays: [Mon]\n  Foo: 1\n",
+			[]string{
+				"line 2: [0].WarnBefore is -5, it should be at least 0",
+				"line 4: [0].Periods[0].StartTime is '8am', it should match " + kitchenTimePattern,
+				"line 4: [0].Periods[0] is missing the required field 'StopTime'",
+				"line 5: [0].Periods[0].Weekdays[0] is 'Mon', it should be one of Monday, Tuesday, Wednesday, Thursday, Friday, Saturday, Sunday",
+				"line 6: [0] has an unknown field 'Foo', use one of Exclude, Extends, Mode, Name, Periods, RespectManualChanges, Timezone, Version, WarnBefore",
+			},
+		},
+		{
+			"[\n\t{\"Name\": \"a\", \"WarnBefore\": \"15\"},\n\t{\"Periods\": []}\n]",
+			[]string{
+				"line 2: [0].WarnBefore should be integer, not string",
+				"line 3: [1] is missing the required field 'Name'",
+			},
+		},
+		{"Name: a\n", []string{"line 1: should be array, not object"}},
+	}
+
+	for i, test := range tests {
+		err := ValidateSchedules([]byte(test.content))
+		if test.expected == nil {
+			if err != nil {
+				t.Errorf("case %d. %s", i+1, err)
+			}
+			continue
+		}
+
+		var errs SchemaErrors
+		if !errors.As(err, &errs) {
+			t.Errorf("case %d. expected schema errors, got %v", i+1, err)
+			continue
+		}
+		if len(errs) != len(test.expected) {
+			t.Errorf("case %d. expected %d errors, got\n%s", i+1, len(test.expected), errs)
+			continue
+		}
+		for j, expected := range test.expected {
+			if errs[j].Error() != expected {
+				t.Errorf("case %d. expected %s, got %s", i+1, expected, errs[j])
+			}
+		}
+	}
+
+	// schedules that match the schema can still be invalid
+	if err := ValidateSchedules([]byte("- Name: a\n  Extends: b\n")); err == nil {
+		t.Errorf("expected an error for a schedule that extends a missing one")
+	}
+}
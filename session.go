@@ -0,0 +1,82 @@
+package possum
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/organizations"
+)
+
+// DefaultHomeRegion is the region of the config table if neither CONFIG_REGION nor AWS_REGION is set
+const DefaultHomeRegion = "ap-southeast-2"
+
+// HomeRegion returns the region that holds the config table
+func HomeRegion() string {
+	if region := os.Getenv("CONFIG_REGION"); region != "" {
+		return region
+	}
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return DefaultHomeRegion
+}
+
+// GetAccounts returns the configured accounts and the accounts discovered from the AWS Organization, if neither is
+// configured it returns the deployed account
+func GetAccounts(ctx context.Context, sess *session.Session, config *Config, deployedAccountID string) ([]*Account, error) {
+	accounts := config.Accounts
+
+	if config.Organization != nil {
+		discovered, err := DiscoverAccounts(ctx, organizations.New(sess), config.Organization, deployedAccountID)
+		if err != nil {
+			return nil, fmt.Errorf("could not discover organization accounts: %w", err)
+		}
+		return MergeAccounts(accounts, discovered), nil
+	}
+
+	// without any configured accounts possum only schedules resources in the deployed account
+	if len(accounts) == 0 {
+		accounts = []*Account{{ID: deployedAccountID}}
+	}
+	return accounts, nil
+}
+
+// AccountSession returns a session that uses the credentials of the role configured for the account, accounts without
+// a role use the credentials of the deployed account
+func AccountSession(sess *session.Session, account *Account) *session.Session {
+	if account.RoleARN == "" {
+		return sess
+	}
+	creds := stscreds.NewCredentials(sess, account.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = "possum"
+		if account.ExternalID != "" {
+			p.ExternalID = aws.String(account.ExternalID)
+		}
+	})
+	return sess.Copy(&aws.Config{Credentials: creds})
+}
+
+// GetRegions returns the regions listed in the config, or every enabled region if the config asks for auto discovery
+func GetRegions(ctx context.Context, sess *session.Session, config *Config) ([]*string, error) {
+
+	if !config.AutoDiscoverRegions() {
+		return aws.StringSlice(config.Regions), nil
+	}
+
+	client := ec2.New(sess)
+
+	res, err := client.DescribeRegionsWithContext(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, err
+	}
+	var regions []*string
+	for _, reg := range res.Regions {
+		regions = append(regions, reg.RegionName)
+	}
+	return regions, err
+}
@@ -0,0 +1,276 @@
+package possum
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Slack limits the number of blocks in a message and the length of the text in a section
+const (
+	slackMaxBlocks      = 50
+	slackMaxSectionText = 3000
+)
+
+// SlackSnoozeActionID prefixes the action IDs of the snooze buttons, the button value is a JSON encoded Snooze
+const SlackSnoozeActionID = "snooze"
+
+// SlackApproveActionID is the action ID of the approve buttons, the button value is the approval ID
+const SlackApproveActionID = "approve"
+
+// the snooze durations offered for warnings
+var slackSnoozeDurations = []string{"1h", "2h", "4h"}
+
+// the order and titles of the resource types in slack messages
+var slackResourceTypes = []struct {
+	Type  string
+	Title string
+}{
+	{InstanceResource, "EC2 instances"},
+	{AutoScalingGroupResource, "Auto scaling groups"},
+	{DBInstanceResource, "RDS instances"},
+}
+
+// Slack posts the report to a slack channel as Block Kit messages, large reports are split over several messages
+type Slack struct {
+	Token   string
+	Channel string
+}
+
+func (s *Slack) Notify(ctx context.Context, report *Report) error {
+	api := slack.New(s.Token)
+	summary := slackSummary(report)
+	for _, blocks := range slackMessages(report) {
+		_, _, err := api.PostMessageContext(ctx, s.Channel, slack.MsgOptionBlocks(blocks...), slack.MsgOptionText(summary, false), slack.MsgOptionAsUser(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// slackMessages converts the report into blocks, split into messages that stay within the slack block limits
+func slackMessages(report *Report) [][]slack.Block {
+	// a group of blocks is kept in the same message, e.g. a warning and its buttons
+	var groups [][]slack.Block
+	add := func(group ...slack.Block) {
+		groups = append(groups, group)
+	}
+	if report.Halted != "" {
+		add(slackSection(fmt.Sprintf(":rotating_light: *possum didn't make these changes*: %s", slackEscape(report.Halted))))
+	}
+
+	for i, result := range report.Results {
+		if i > 0 {
+			add(slack.NewDividerBlock())
+		}
+		add(slackSection(fmt.Sprintf(":cloud: *%s* · %s", slackEscape(result.Account), result.Region)))
+
+		for _, resourceType := range slackResourceTypes {
+			var lines []string
+			for _, change := range result.Changes {
+				if change.Type == resourceType.Type && change.Action != WarnAction && change.Action != ApprovalAction {
+					lines = append(lines, slackChangeLine(result.Region, change))
+				}
+			}
+			for _, text := range slackChunks(fmt.Sprintf("*%s*", resourceType.Title), lines) {
+				add(slackSection(text))
+			}
+		}
+
+		// every warning gets its own snooze buttons, and every stop that waits for approval an approve button
+		for _, change := range result.Changes {
+			if change.Action == WarnAction {
+				add(slackSection(slackChangeLine(result.Region, change)), slackSnoozeActions(result, change))
+			}
+			if change.Action == ApprovalAction && change.Error == "" {
+				add(slackSection(slackChangeLine(result.Region, change)), slackApproveAction(result, change))
+			}
+		}
+	}
+
+	if len(report.Unreachable) > 0 {
+		var lines []string
+		for _, msg := range report.Unreachable {
+			lines = append(lines, fmt.Sprintf(":warning: %s", slackEscape(msg)))
+		}
+		for _, text := range slackChunks("*Unreachable accounts*", lines) {
+			add(slackSection(text))
+		}
+	}
+
+	// messages are only split between groups, leaving room for the footer in the last message
+	var messages [][]slack.Block
+	var blocks []slack.Block
+	for _, group := range groups {
+		if len(blocks)+len(group) > slackMaxBlocks-1 {
+			messages = append(messages, blocks)
+			blocks = nil
+		}
+		blocks = append(blocks, group...)
+	}
+	footer := slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, slackSummary(report), false, false))
+	messages = append(messages, append(blocks, footer))
+	return messages
+}
+
+// slackChunks joins the lines below the title into texts that fit in a section block
+func slackChunks(title string, lines []string) []string {
+	if len(lines) == 0 {
+		return nil
+	}
+	var chunks []string
+	text := title
+	for _, line := range lines {
+		if len(text)+len(line)+1 > slackMaxSectionText {
+			chunks = append(chunks, text)
+			text = title + " (continued)"
+		}
+		text += "\n" + line
+	}
+	return append(chunks, text)
+}
+
+func slackChangeLine(region string, change Change) string {
+	icon := ":grey_question:"
+	switch change.Action {
+	case StartAction:
+		icon = ":arrow_forward:"
+	case StopAction:
+		icon = ":stop_button:"
+	case WarnAction:
+		icon = ":warning:"
+	case HoldAction:
+		icon = ":raised_hand:"
+	case ApprovalAction:
+		icon = ":hourglass_flowing_sand:"
+	}
+
+	id := fmt.Sprintf("`%s`", slackEscape(*change.ID))
+	if url := consoleURL(region, change.Type, *change.ID); url != "" {
+		id = fmt.Sprintf("<%s|%s>", url, slackEscape(*change.ID))
+	}
+
+	line := fmt.Sprintf("%s %s *%s* %s", icon, change.Action, slackEscape(change.Name), id)
+	if change.Schedule != "" {
+		line += fmt.Sprintf(" · _%s_", slackEscape(change.Schedule))
+	}
+	if change.Mode != "" && change.Mode != BothMode {
+		line += fmt.Sprintf(" · %s", change.Mode)
+	}
+	if change.Action == WarnAction {
+		line += fmt.Sprintf(" · stops <!date^%d^{time}|at %s>", change.Due.Unix(), change.Due.UTC().Format(time.Kitchen+" MST"))
+	}
+	if change.Action == HoldAction {
+		line += fmt.Sprintf(" · changed by hand, left alone until <!date^%d^{date_short_pretty} {time}|%s>", change.Due.Unix(), change.Due.UTC().Format(time.RFC1123))
+	}
+	if change.Action == ApprovalAction && change.Error == "" {
+		line += fmt.Sprintf(" · the stop waits for approval until <!date^%d^{date_short_pretty} {time}|%s>", change.Due.Unix(), change.Due.UTC().Format(time.RFC1123))
+	}
+	if change.Error != "" {
+		line += fmt.Sprintf(" · :x: failed: %s", slackEscape(change.Error))
+	}
+	if change.Rejected != "" {
+		line += fmt.Sprintf(" · :no_entry: rejected by %s", slackEscape(change.Rejected))
+	}
+	return line
+}
+
+func slackSnoozeActions(result *Result, change Change) *slack.ActionBlock {
+	var buttons []slack.BlockElement
+	for _, duration := range slackSnoozeDurations {
+		value, _ := json.Marshal(&Snooze{
+			Account:  result.Account,
+			Region:   result.Region,
+			Type:     change.Type,
+			ID:       *change.ID,
+			Duration: duration,
+		})
+		text := slack.NewTextBlockObject(slack.PlainTextType, "Snooze "+duration, false, false)
+		buttons = append(buttons, slack.NewButtonBlockElement(SlackSnoozeActionID+":"+duration, string(value), text))
+	}
+	return slack.NewActionBlock("", buttons...)
+}
+
+func slackApproveAction(result *Result, change Change) *slack.ActionBlock {
+	id := ApprovalID(result.Account, result.Region, change.Type, *change.ID)
+	text := slack.NewTextBlockObject(slack.PlainTextType, "Approve stop", false, false)
+	button := slack.NewButtonBlockElement(SlackApproveActionID, id, text)
+	button.Style = slack.StyleDanger
+	return slack.NewActionBlock("", button)
+}
+
+// slackSummary counts the changes in the report, it's used as the message footer and the notification text
+func slackSummary(report *Report) string {
+	var started, stopped, warned, held, approvals, rejected int
+	accounts := make(map[string]bool)
+	regions := make(map[string]bool)
+	for _, result := range report.Results {
+		accounts[result.Account] = true
+		regions[result.Region] = true
+		for _, change := range result.Changes {
+			if change.Rejected != "" {
+				rejected++
+				continue
+			}
+			switch change.Action {
+			case StartAction:
+				started++
+			case StopAction:
+				stopped++
+			case WarnAction:
+				warned++
+			case HoldAction:
+				held++
+			case ApprovalAction:
+				approvals++
+			}
+		}
+	}
+	summary := fmt.Sprintf("possum: %d started · %d stopped in %d accounts and %d regions", started, stopped, len(accounts), len(regions))
+	if report.Halted != "" {
+		summary = fmt.Sprintf("possum halted: %d starts · %d stops planned in %d accounts and %d regions", started, stopped, len(accounts), len(regions))
+	}
+	if warned > 0 {
+		summary += fmt.Sprintf(" · %d stopping soon", warned)
+	}
+	if held > 0 {
+		summary += fmt.Sprintf(" · %d changed by hand", held)
+	}
+	if approvals > 0 {
+		summary += fmt.Sprintf(" · %d waiting for approval", approvals)
+	}
+	if rejected > 0 {
+		summary += fmt.Sprintf(" · %d rejected by policies", rejected)
+	}
+	if len(report.Unreachable) > 0 {
+		summary += fmt.Sprintf(" · %d unreachable accounts", len(report.Unreachable))
+	}
+	return summary
+}
+
+func slackSection(text string) *slack.SectionBlock {
+	return slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)
+}
+
+// slackEscape escapes the characters that slack uses for markup
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// consoleURL returns a link to the resource in the AWS console
+func consoleURL(region, resourceType, id string) string {
+	switch resourceType {
+	case InstanceResource:
+		return fmt.Sprintf("https://%s.console.aws.amazon.com/ec2/home?region=%s#InstanceDetails:instanceId=%s", region, region, id)
+	case AutoScalingGroupResource:
+		return fmt.Sprintf("https://%s.console.aws.amazon.com/ec2/home?region=%s#AutoScalingGroupDetails:id=%s", region, region, id)
+	case DBInstanceResource:
+		return fmt.Sprintf("https://%s.console.aws.amazon.com/rds/home?region=%s#database:id=%s", region, region, id)
+	}
+	return ""
+}
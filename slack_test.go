@@ -0,0 +1,192 @@
+package possum
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/slack-go/slack"
+)
+
+func TestSlackMessages(t *testing.T) {
+	report := &Report{}
+	report.Add("playpen", "ap-southeast-2", Changes{
+		{ID: aws.String("i-1"), Name: "dev-box", Action: StopAction, Type: InstanceResource, Schedule: "OfficeHours"},
+		{ID: aws.String("db-1"), Name: "db-1", Action: StartAction, Type: DBInstanceResource, Schedule: "OfficeHours"},
+	})
+	report.Unreachable = []string{"staging: could not assume role"}
+
+	messages := slackMessages(report)
+	if len(messages) != 1 {
+		t.Errorf("expected 1 message, got %d", len(messages))
+		return
+	}
+
+	// account header, instances, databases, unreachable accounts and the footer
+	blocks := messages[0]
+	if len(blocks) != 5 {
+		t.Errorf("expected 5 blocks, got %d", len(blocks))
+		return
+	}
+
+	instances := blocks[1].(*slack.SectionBlock).Text.Text
+	expected := "*EC2 instances*\n:stop_button: stop *dev-box* <https://ap-southeast-2.console.aws.amazon.com/ec2/home?region=ap-southeast-2#InstanceDetails:instanceId=i-1|i-1> · _OfficeHours_"
+	if instances != expected {
+		t.Errorf("Expected: %s\n Got: %s", expected, instances)
+	}
+
+	footer := blocks[4].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject).Text
+	expected = "possum: 1 started · 1 stopped in 1 accounts and 1 regions · 1 unreachable accounts"
+	if footer != expected {
+		t.Errorf("Expected: %s\n Got: %s", expected, footer)
+	}
+}
+
+func TestSlackSummary_Regions(t *testing.T) {
+	report := &Report{}
+	report.Add("dev", "ap-southeast-2", Changes{{ID: aws.String("i-1"), Action: StopAction, Type: InstanceResource}})
+	report.Add("prod", "ap-southeast-2", Changes{{ID: aws.String("i-2"), Action: StopAction, Type: InstanceResource}})
+	report.Add("prod", "us-east-1", Changes{{ID: aws.String("i-3"), Action: StartAction, Type: InstanceResource}})
+
+	expected := "possum: 1 started · 2 stopped in 2 accounts and 2 regions"
+	if summary := slackSummary(report); summary != expected {
+		t.Errorf("Expected: %s\n Got: %s", expected, summary)
+	}
+}
+
+func TestSlackMessages_Halted(t *testing.T) {
+	report := &Report{Halted: "2 starts and stops are more than the limit of 1"}
+	report.Add("playpen", "ap-southeast-2", Changes{
+		{ID: aws.String("i-1"), Name: "dev-box", Action: StopAction, Type: InstanceResource},
+		{ID: aws.String("i-2"), Name: "web", Action: StopAction, Type: InstanceResource},
+	})
+
+	blocks := slackMessages(report)[0]
+	header := blocks[0].(*slack.SectionBlock).Text.Text
+	if !strings.Contains(header, "didn't make these changes") || !strings.Contains(header, "limit of 1") {
+		t.Errorf("expected the message to start with why nothing changed, got %s", header)
+	}
+
+	footer := blocks[len(blocks)-1].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject).Text
+	expected := "possum halted: 0 starts · 2 stops planned in 1 accounts and 1 regions"
+	if footer != expected {
+		t.Errorf("Expected: %s\n Got: %s", expected, footer)
+	}
+}
+
+func TestSlackMessages_Approval(t *testing.T) {
+	report := &Report{}
+	report.Add("prod", "us-east-1", Changes{
+		{ID: aws.String("reports"), Name: "reports", Action: ApprovalAction, Type: DBInstanceResource},
+	})
+
+	// the account header, the stop that waits for approval, its approve button and the footer
+	blocks := slackMessages(report)[0]
+	if len(blocks) != 4 {
+		t.Fatalf("expected 4 blocks, got %d", len(blocks))
+	}
+	button := blocks[2].(*slack.ActionBlock).Elements.ElementSet[0].(*slack.ButtonBlockElement)
+	if button.ActionID != SlackApproveActionID || button.Value != "prod/us-east-1/rds/reports" {
+		t.Errorf("expected an approve button for the approval, got %s %s", button.ActionID, button.Value)
+	}
+	footer := blocks[3].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject).Text
+	if !strings.HasSuffix(footer, "· 1 waiting for approval") {
+		t.Errorf("unexpected footer %s", footer)
+	}
+}
+
+func TestSlackMessages_Split(t *testing.T) {
+	report := &Report{}
+	for i := 0; i < 60; i++ {
+		report.Add(fmt.Sprintf("account-%02d", i), "ap-southeast-2", Changes{
+			{ID: aws.String("i-1"), Name: "dev-box", Action: StopAction, Type: InstanceResource},
+		})
+	}
+
+	messages := slackMessages(report)
+	if len(messages) < 2 {
+		t.Errorf("expected the report to be split into several messages, got %d", len(messages))
+	}
+
+	for i, blocks := range messages {
+		if len(blocks) > slackMaxBlocks {
+			t.Errorf("message %d has %d blocks, slack only allows %d", i, len(blocks), slackMaxBlocks)
+		}
+	}
+
+	last := messages[len(messages)-1]
+	if _, ok := last[len(last)-1].(*slack.ContextBlock); !ok {
+		t.Errorf("expected the last message to end with the footer")
+	}
+}
+
+func TestSlackMessages_SplitWarnings(t *testing.T) {
+	// the account header and the stops take 2 blocks and every warning 2 more, so the 49th block is a warning whose
+	// buttons don't fit in the first message
+	changes := Changes{{ID: aws.String("i-0"), Name: "dev-box", Action: StopAction, Type: InstanceResource}}
+	for i := 1; i <= 30; i++ {
+		changes = append(changes, Change{ID: aws.String(fmt.Sprintf("i-%d", i)), Name: "web", Action: WarnAction, Type: InstanceResource})
+	}
+	report := &Report{}
+	report.Add("playpen", "ap-southeast-2", changes)
+
+	messages := slackMessages(report)
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(messages))
+	}
+	if len(messages[0]) != 48 {
+		t.Errorf("expected the first message to end before the warning at the boundary, got %d blocks", len(messages[0]))
+	}
+	for i, blocks := range messages {
+		for j, block := range blocks {
+			if _, ok := block.(*slack.ActionBlock); !ok {
+				continue
+			}
+			if j == 0 {
+				t.Errorf("message %d starts with the buttons of a warning in the message before", i)
+			} else if _, ok := blocks[j-1].(*slack.SectionBlock); !ok {
+				t.Errorf("message %d block %d. expected the buttons to follow their warning", i, j)
+			}
+		}
+	}
+}
+
+func TestSlackChunks(t *testing.T) {
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, strings.Repeat("x", 99))
+	}
+
+	chunks := slackChunks("*title*", lines)
+	if len(chunks) < 4 {
+		t.Errorf("expected the lines to be split into at least 4 chunks, got %d", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > slackMaxSectionText {
+			t.Errorf("chunk %d is %d characters, slack only allows %d", i, len(chunk), slackMaxSectionText)
+		}
+	}
+
+	if chunks := slackChunks("*title*", nil); len(chunks) != 0 {
+		t.Errorf("expected no chunks without lines, got %d", len(chunks))
+	}
+}
+
+func TestConsoleURL(t *testing.T) {
+	tests := []struct {
+		resourceType string
+		expected     string
+	}{
+		{InstanceResource, "https://us-east-1.console.aws.amazon.com/ec2/home?region=us-east-1#InstanceDetails:instanceId=x"},
+		{AutoScalingGroupResource, "https://us-east-1.console.aws.amazon.com/ec2/home?region=us-east-1#AutoScalingGroupDetails:id=x"},
+		{DBInstanceResource, "https://us-east-1.console.aws.amazon.com/rds/home?region=us-east-1#database:id=x"},
+		{"unknown", ""},
+	}
+
+	for _, test := range tests {
+		if actual := consoleURL("us-east-1", test.resourceType, "x"); actual != test.expected {
+			t.Errorf("Expected: %s\n Got: %s", test.expected, actual)
+		}
+	}
+}
@@ -1,50 +1,428 @@
 package possum
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
+const (
+	schedulesItemID = "schedules" // the item that held all schedules, before each schedule had its own item
+	configItemID    = "config"
+)
+
+// scheduleItemPrefix is the prefix of the ids of the schedule items, followed by the schedule name
+const scheduleItemPrefix = "schedule#"
+
+// maxTransactItems is the most items DynamoDB writes in one transaction
+const maxTransactItems = 100
+
+// ScheduleConflictError is returned when schedules were changed by someone else since they were read
+type ScheduleConflictError struct {
+	Names []string
+}
+
+func (e *ScheduleConflictError) Error() string {
+	if len(e.Names) == 0 {
+		return "the schedules were changed by someone else at the same time, get the schedules again and reapply the changes"
+	}
+	return fmt.Sprintf("schedule '%s' was changed by someone else since it was read, get the schedules again and reapply the changes", strings.Join(e.Names, "', '"))
+}
+
+// PutSchedules stores the schedules as they are defined, it refuses schedules that can't be composed
 func PutSchedules(client dynamodbiface.DynamoDBAPI, tableName string, schedules Schedules) error {
-	b, err := json.Marshal(schedules)
+	store := &DynamoDBScheduleStore{Client: client, Table: tableName}
+	return store.PutSchedules(context.Background(), schedules)
+}
+
+// GetSchedules returns the stored schedules, composed with the schedules they extend
+func GetSchedules(client dynamodbiface.DynamoDBAPI, tableName string) (Schedules, error) {
+	store := &DynamoDBScheduleStore{Client: client, Table: tableName}
+	return store.GetSchedules(context.Background())
+}
+
+// DynamoDBScheduleStore keeps every schedule in its own item of the config table, next to the config. Each item has a
+// version that is checked when it's written, so that people editing different schedules don't overwrite each other.
+type DynamoDBScheduleStore struct {
+	Client dynamodbiface.DynamoDBAPI
+	Table  string
+	Author string // who stores the schedules, recorded with the new version
+}
+
+// GetSchedules returns the schedules in the schedule items, or in the legacy schedules item if they haven't been
+// migrated yet
+func (s *DynamoDBScheduleStore) GetSchedules(ctx context.Context) (Schedules, error) {
+	items, _, err := s.items(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var schedules Schedules
+	for _, item := range items {
+		schedules = append(schedules, item.schedule)
+	}
+	if len(schedules) == 0 {
+		if _, err := getContent(s.Client, s.Table, schedulesItemID, &schedules); err != nil {
+			return nil, err
+		}
+	}
+	return schedules.Compose()
+}
+
+// PutSchedules stores the changed schedules, all or nothing. Schedules with a version are only stored if that's still
+// the stored version, schedules without one only if there's no schedule with that name yet, otherwise a
+// *ScheduleConflictError is returned. The versions of the schedules are updated after they are stored, and the
+// schedules are recorded as a new version, see Versions.
+//
+// Stored schedules that aren't in the list are kept, they may have been added by someone else since the list was
+// read, see DeleteSchedule. The legacy schedules item is removed, so the first put migrates all schedules, including
+// the ones that aren't in the list.
+func (s *DynamoDBScheduleStore) PutSchedules(ctx context.Context, schedules Schedules) error {
+	if _, err := schedules.Compose(); err != nil {
+		return err
+	}
+	return s.put(ctx, schedules, nil)
+}
+
+// DeleteSchedule removes the schedule if the version is still the stored version, it refuses to remove a schedule that
+// other schedules extend
+func (s *DynamoDBScheduleStore) DeleteSchedule(ctx context.Context, name string, version int) error {
+	items, _, err := s.items(ctx)
+	if err != nil {
+		return err
+	}
+	var rest Schedules
+	for _, item := range items {
+		if item.schedule.Name != name {
+			rest = append(rest, item.schedule)
+		}
+	}
+	if len(items) == 0 {
+		return errors.New("the schedules are still in the legacy schedules item, migrate them first")
+	}
+	if len(rest) == len(items) {
+		return fmt.Errorf("there is no schedule named '%s'", name)
+	}
+	if _, err := rest.Compose(); err != nil {
+		return fmt.Errorf("can't delete schedule '%s': %w", name, err)
+	}
+
+	return s.put(ctx, nil, []*scheduleItem{{schedule: &Schedule{Name: name, Version: version}}})
+}
+
+// put writes the changed schedules and removes the items in one transaction, and records the result as a new version
+func (s *DynamoDBScheduleStore) put(ctx context.Context, schedules Schedules, remove []*scheduleItem) error {
+	items, index, err := s.items(ctx)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]*scheduleItem)
+	previous := make(map[string]string) // the content of each schedule before and after the put
+	for _, item := range items {
+		current[item.schedule.Name] = item
+		previous[item.schedule.Name] = item.content
+	}
+
+	var legacy Schedules
+	hasLegacy, err := getContent(s.Client, s.Table, schedulesItemID, &legacy)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		listed := make(map[string]bool)
+		for _, schedule := range schedules {
+			listed[schedule.stored().Name] = true
+		}
+		schedules = append(Schedules(nil), schedules...)
+		for _, schedule := range legacy {
+			if previous[schedule.Name], err = scheduleContent(schedule); err != nil {
+				return err
+			}
+			// the legacy item is removed below, so the schedules that aren't in the list are moved into their own items
+			if !listed[schedule.Name] {
+				schedules = append(schedules, schedule)
+			}
+		}
+	}
+	next := make(map[string]string)
+	for name, content := range previous {
+		next[name] = content
+	}
+
+	var writes []*dynamodb.TransactWriteItem
+	var names []string // the schedule name of each write, to report conflicts
+	updated := make(map[*Schedule]int)
+	for _, schedule := range schedules {
+		def := schedule.stored()
+		content, err := scheduleContent(def)
+		if err != nil {
+			return err
+		}
+
+		// unchanged schedules aren't written, so that they don't conflict with changes to other schedules
+		c, exists := current[def.Name]
+		if exists && c.content == content {
+			updated[schedule] = c.schedule.Version
+			continue
+		}
+
+		condition := aws.String("attribute_not_exists(id)")
+		var values map[string]*dynamodb.AttributeValue
+		if def.Version > 0 {
+			condition = aws.String("version = :version")
+			values = map[string]*dynamodb.AttributeValue{":version": {N: aws.String(strconv.Itoa(def.Version))}}
+		}
+		writes = append(writes, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+			TableName: aws.String(s.Table),
+			Item: map[string]*dynamodb.AttributeValue{
+				"id":      {S: aws.String(scheduleItemPrefix + def.Name)},
+				"content": {S: aws.String(content)},
+				"version": {N: aws.String(strconv.Itoa(def.Version + 1))},
+			},
+			ConditionExpression:       condition,
+			ExpressionAttributeValues: values,
+		}})
+		names = append(names, def.Name)
+		updated[schedule] = def.Version + 1
+		next[def.Name] = content
+	}
+
+	for _, item := range remove {
+		name := item.schedule.Name
+		writes = append(writes, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			TableName:                 aws.String(s.Table),
+			Key:                       map[string]*dynamodb.AttributeValue{"id": {S: aws.String(scheduleItemPrefix + name)}},
+			ConditionExpression:       aws.String("version = :version"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":version": {N: aws.String(strconv.Itoa(item.schedule.Version))}},
+		}})
+		names = append(names, name)
+		delete(next, name)
+	}
+
+	if len(writes) == 0 {
+		s.setVersions(updated)
+		return nil
+	}
+
+	if hasLegacy {
+		writes = append(writes, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			TableName: aws.String(s.Table),
+			Key:       map[string]*dynamodb.AttributeValue{"id": {S: aws.String(schedulesItemID)}},
+		}})
+	}
+
+	writes = append(writes, s.versionWrites(index.latest, previous, next)...)
+
+	if len(writes) > maxTransactItems {
+		return fmt.Errorf("can't store %d changed schedules at once, DynamoDB only writes %d items in a transaction", len(writes), maxTransactItems)
+	}
+
+	_, err = s.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: writes})
+	var canceled *dynamodb.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		var conflict *ScheduleConflictError
+		for i, reason := range canceled.CancellationReasons {
+			if aws.StringValue(reason.Code) != "ConditionalCheckFailed" {
+				continue
+			}
+			if conflict == nil {
+				conflict = &ScheduleConflictError{}
+			}
+			// the version items come after the schedules, if only they failed someone else stored schedules at the
+			// same time
+			if i < len(names) {
+				conflict.Names = append(conflict.Names, names[i])
+			}
+		}
+		if conflict != nil {
+			return conflict
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	s.setVersions(updated)
+	return nil
+}
+
+func (s *DynamoDBScheduleStore) setVersions(versions map[*Schedule]int) {
+	for schedule, version := range versions {
+		schedule.Version = version
+		schedule.stored().Version = version
+	}
+}
+
+// Migrate moves the schedules from the legacy schedules item into an item per schedule, it returns the number of
+// schedules that were moved
+func (s *DynamoDBScheduleStore) Migrate(ctx context.Context) (int, error) {
+	items, _, err := s.items(ctx)
+	if err != nil || len(items) > 0 {
+		return 0, err
+	}
+
+	var legacy Schedules
+	if _, err := getContent(s.Client, s.Table, schedulesItemID, &legacy); err != nil || len(legacy) == 0 {
+		return 0, err
+	}
+	return len(legacy), s.PutSchedules(ctx, legacy)
+}
+
+func (s *DynamoDBScheduleStore) String() string {
+	return "dynamodb://" + s.Table
+}
+
+// scheduleItem is a schedule as it's stored in the config table
+type scheduleItem struct {
+	schedule *Schedule
+	content  string
+}
+
+// DynamoDB reads up to 100 items in a batch get
+const batchGetSize = 100
+
+// items returns the schedule items, sorted by schedule name, and the versions item they were read with. The versions
+// item holds the names of the schedules, so that they're read by their keys instead of scanning the config table,
+// which holds the versions as well.
+func (s *DynamoDBScheduleStore) items(ctx context.Context) ([]*scheduleItem, *scheduleIndex, error) {
+	index, err := s.index(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var items []*scheduleItem
+	for start := 0; start < len(index.names); start += batchGetSize {
+		end := start + batchGetSize
+		if end > len(index.names) {
+			end = len(index.names)
+		}
+		var keys []map[string]*dynamodb.AttributeValue
+		for _, name := range index.names[start:end] {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{"id": {S: aws.String(scheduleItemPrefix + name)}})
+		}
+
+		// dynamodb returns the keys it didn't read when the table is throttled, so retry those a few times
+		pending := map[string]*dynamodb.KeysAndAttributes{s.Table: {Keys: keys, ConsistentRead: aws.Bool(true)}}
+		for i := 0; i < 3 && len(pending) > 0; i++ {
+			if i > 0 {
+				time.Sleep(time.Duration(i) * 100 * time.Millisecond)
+			}
+			out, err := s.Client.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{RequestItems: pending})
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, attrs := range out.Responses[s.Table] {
+				item, err := newScheduleItem(attrs)
+				if err != nil {
+					return nil, nil, fmt.Errorf("item %s: %w", aws.StringValue(attrs["id"].S), err)
+				}
+				items = append(items, item)
+			}
+			pending = out.UnprocessedKeys
+		}
+		if unread, ok := pending[s.Table]; ok && len(unread.Keys) > 0 {
+			return nil, nil, fmt.Errorf("could not read %d schedules from %s", len(unread.Keys), s.Table)
+		}
+	}
+	if len(items) != len(index.names) {
+		return nil, nil, &ScheduleConflictError{}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].schedule.Name < items[j].schedule.Name
+	})
+	return items, index, nil
+}
+
+func newScheduleItem(attrs map[string]*dynamodb.AttributeValue) (*scheduleItem, error) {
+	item := &scheduleItem{schedule: &Schedule{}}
+	if attr, ok := attrs["content"]; ok && attr.S != nil {
+		item.content = *attr.S
+	}
+	if err := json.Unmarshal([]byte(item.content), item.schedule); err != nil {
+		return nil, err
+	}
+	if attr, ok := attrs["version"]; ok && attr.N != nil {
+		version, err := strconv.Atoi(*attr.N)
+		if err != nil {
+			return nil, err
+		}
+		item.schedule.Version = version
+	}
+	return item, nil
+}
+
+// scheduleContent returns the JSON of the schedule without its version, the version is stored in its own attribute
+func scheduleContent(schedule *Schedule) (string, error) {
+	c := *schedule
+	c.Version = 0
+	b, err := json.Marshal(&c)
+	return string(b), err
+}
+
+func PutConfig(client dynamodbiface.DynamoDBAPI, tableName string, config *Config) error {
+	return putContent(client, tableName, configItemID, config)
+}
+
+// GetConfig returns the stored config, if there is no config stored, an empty Config will be returned
+func GetConfig(client dynamodbiface.DynamoDBAPI, tableName string) (*Config, error) {
+	v := &Config{}
+	if _, err := getContent(client, tableName, configItemID, v); err != nil {
+		return nil, err
+	}
+	if err := v.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return v, nil
+}
+
+// putContent stores v as json in the content attribute of the item with the id
+func putContent(client dynamodbiface.DynamoDBAPI, tableName, id string, v interface{}) error {
+	b, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
 	item := make(map[string]*dynamodb.AttributeValue)
-	item["id"] = &dynamodb.AttributeValue{S: aws.String("schedules")}
+	item["id"] = &dynamodb.AttributeValue{S: aws.String(id)}
 	item["content"] = &dynamodb.AttributeValue{S: aws.String(string(b))}
 	_, err = client.PutItem(&dynamodb.PutItemInput{
-		TableName: aws.String(tableName),
-		Item:      item,
+		TableName:              aws.String(tableName),
+		Item:                   item,
 		ReturnConsumedCapacity: aws.String("TOTAL"),
 	})
 	return err
 }
 
-func GetSchedules(client dynamodbiface.DynamoDBAPI, tableName string) (Schedules, error) {
+// getContent unmarshals the json in the content attribute of the item with the id into v, returns false if the item
+// or the content doesn't exist
+func getContent(client dynamodbiface.DynamoDBAPI, tableName, id string, v interface{}) (bool, error) {
 
 	key := make(map[string]*dynamodb.AttributeValue)
-	key["id"] = &dynamodb.AttributeValue{S: aws.String("schedules")}
+	key["id"] = &dynamodb.AttributeValue{S: aws.String(id)}
 
 	res, err := client.GetItem(&dynamodb.GetItemInput{
-		ProjectionExpression: aws.String("content"),
-		TableName:            aws.String(tableName),
-		Key:                  key,
+		ProjectionExpression:   aws.String("content"),
+		TableName:              aws.String(tableName),
+		Key:                    key,
 		ReturnConsumedCapacity: aws.String("TOTAL"),
 	})
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	content := res.Item["content"].S
-	if content == nil {
-		return nil, nil
+	attr, ok := res.Item["content"]
+	if !ok || attr.S == nil {
+		return false, nil
 	}
 
-	var v Schedules
-	err = json.Unmarshal([]byte(*content), &v)
-	return v, err
+	return true, json.Unmarshal([]byte(*attr.S), v)
 }
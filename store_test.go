@@ -0,0 +1,290 @@
+package possum
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func newLegacyConfigTable(content string) *mockDynamoDBClient {
+	return &mockDynamoDBClient{config: map[string]map[string]*dynamodb.AttributeValue{
+		schedulesItemID: {"id": {S: aws.String(schedulesItemID)}, "content": {S: aws.String(content)}},
+	}}
+}
+
+func TestDynamoDBScheduleStore_Migrate(t *testing.T) {
+	client := newLegacyConfigTable(composeTestSchedules)
+	store := &DynamoDBScheduleStore{Client: client, Table: "config"}
+
+	// the legacy item is read until the schedules are migrated
+	schedules, err := store.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := schedules.Find("UK Support"); s == nil || len(s.Periods) != 2 || s.Version != 0 {
+		t.Fatalf("expected the legacy schedules to be composed, got %v", schedules)
+	}
+
+	n, err := store.Migrate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 schedules to be migrated, got %d", n)
+	}
+	if _, ok := client.config[schedulesItemID]; ok {
+		t.Errorf("expected the legacy item to be removed")
+	}
+	if len(client.config) != 5 || client.config[scheduleItemPrefix+"UK Support"] == nil {
+		t.Errorf("expected an item per schedule and a version, got %d items", len(client.config))
+	}
+	if content := *client.config[scheduleItemPrefix+"UK Support"]["content"].S; strings.Contains(content, "StartTime") || strings.Contains(content, "Version") {
+		t.Errorf("expected the schedule to be stored as it was defined, without the version, got %s", content)
+	}
+
+	// the migrated schedules are read by their names in the versions item, not by scanning the table
+	client.scans, client.unprocessed = 0, 1
+	schedules, err = store.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := schedules.Find("UK Support"); s == nil || len(s.Periods) != 2 || s.Version != 1 || len(schedules) != 3 {
+		t.Errorf("expected the migrated schedules to be composed at version 1, got %v", schedules)
+	}
+	if client.scans != 0 {
+		t.Errorf("expected the schedules to be read without a scan, got %d scans", client.scans)
+	}
+
+	if n, err := store.Migrate(context.Background()); err != nil || n != 0 {
+		t.Errorf("expected nothing to migrate twice, got %d, %v", n, err)
+	}
+}
+
+func TestDynamoDBScheduleStore_PutSchedulesOverLegacy(t *testing.T) {
+	client := newLegacyConfigTable(`[
+		{"Name": "Office", "Periods": [{"StartTime": "08:00", "StopTime": "18:00"}]},
+		{"Name": "Support", "Periods": [{"StartTime": "06:00", "StopTime": "22:00"}]}
+	]`)
+	store := &DynamoDBScheduleStore{Client: client, Table: "config"}
+
+	office, err := ParseScheduleExpression("07:00-19:00 UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	office.Name = "Office"
+	if err := store.PutSchedules(context.Background(), Schedules{office}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.config[schedulesItemID]; ok {
+		t.Errorf("expected the legacy item to be removed")
+	}
+
+	schedules, err := store.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 2 {
+		t.Fatalf("expected the schedule that wasn't put to be kept, got %v", schedules)
+	}
+	if s := schedules.Find("Office"); s == nil || s.Periods[0].String() != "07:00-19:00" {
+		t.Errorf("expected the schedule that was put to be stored, got %v", s)
+	}
+	if s := schedules.Find("Support"); s == nil || s.Periods[0].String() != "06:00-22:00" || s.Version != 1 {
+		t.Errorf("expected the legacy schedule to be moved into its own item, got %v", s)
+	}
+}
+
+func TestDynamoDBScheduleStore_PutSchedules(t *testing.T) {
+	client := newLegacyConfigTable(composeTestSchedules)
+	store := &DynamoDBScheduleStore{Client: client, Table: "config"}
+	if _, err := store.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	alice, err := store.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := store.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// changes to different schedules don't conflict, unchanged schedules aren't written
+	alice.Find("NZ Office").stored().WarnBefore = 5
+	if err := store.PutSchedules(context.Background(), alice); err != nil {
+		t.Fatal(err)
+	}
+	if v := alice.Find("NZ Office").Version; v != 2 {
+		t.Errorf("expected the version to be updated to 2, got %d", v)
+	}
+	if v := *client.config[scheduleItemPrefix+"UK Office"]["version"].N; v != "1" {
+		t.Errorf("expected the unchanged schedule to stay at version 1, got %s", v)
+	}
+
+	bob = append(bob, NewSchedule("Weekends"))
+	bob.Find("UK Office").stored().WarnBefore = 45
+	bob.Find("NZ Office").stored().WarnBefore = 60
+	err = store.PutSchedules(context.Background(), bob)
+	var conflict *ScheduleConflictError
+	if !errors.As(err, &conflict) || len(conflict.Names) != 1 || conflict.Names[0] != "NZ Office" {
+		t.Fatalf("expected a conflict on NZ Office, got %v", err)
+	}
+	if client.config[scheduleItemPrefix+"Weekends"] != nil || *client.config[scheduleItemPrefix+"UK Office"]["version"].N != "1" {
+		t.Errorf("expected nothing to be stored when there is a conflict")
+	}
+
+	// a new schedule with a name that's taken conflicts too
+	err = store.PutSchedules(context.Background(), Schedules{NewSchedule("NZ Office")})
+	if !errors.As(err, &conflict) {
+		t.Errorf("expected a conflict for a new schedule with a taken name, got %v", err)
+	}
+
+	// schedules that aren't in the list are kept
+	if err := store.PutSchedules(context.Background(), Schedules{NewSchedule("Weekends")}); err != nil {
+		t.Fatal(err)
+	}
+	schedules, err := store.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 4 || schedules.Find("NZ Office").WarnBefore != 5 {
+		t.Errorf("expected alice's change and the new schedule to be stored, got %v", schedules)
+	}
+}
+
+func TestDynamoDBScheduleStore_DeleteSchedule(t *testing.T) {
+	client := newLegacyConfigTable(composeTestSchedules)
+	store := &DynamoDBScheduleStore{Client: client, Table: "config"}
+	if err := store.DeleteSchedule(context.Background(), "UK Support", 0); err == nil {
+		t.Errorf("expected an error before the schedules are migrated")
+	}
+	if _, err := store.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.DeleteSchedule(context.Background(), "UK Office", 1); err == nil || !strings.Contains(err.Error(), "extends 'UK Office'") {
+		t.Errorf("expected an error for deleting a schedule that is extended, got %v", err)
+	}
+	var conflict *ScheduleConflictError
+	if err := store.DeleteSchedule(context.Background(), "UK Support", 2); !errors.As(err, &conflict) {
+		t.Errorf("expected a conflict for an old version, got %v", err)
+	}
+	if err := store.DeleteSchedule(context.Background(), "UK Support", 1); err != nil {
+		t.Fatal(err)
+	}
+	if client.config[scheduleItemPrefix+"UK Support"] != nil {
+		t.Errorf("expected the schedule to be deleted")
+	}
+}
+
+func (m *mockDynamoDBClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: m.config[*input.Key["id"].S]}, nil
+}
+
+func (m *mockDynamoDBClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.config[*input.Item["id"].S] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *mockDynamoDBClient) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, options ...request.Option) (*dynamodb.GetItemOutput, error) {
+	return m.GetItem(input)
+}
+
+func (m *mockDynamoDBClient) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, options ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	out := &dynamodb.BatchGetItemOutput{Responses: make(map[string][]map[string]*dynamodb.AttributeValue)}
+	for table, keys := range input.RequestItems {
+		list := keys.Keys
+		if m.unprocessed > 0 && len(list) > m.unprocessed {
+			out.UnprocessedKeys = map[string]*dynamodb.KeysAndAttributes{table: {Keys: list[:m.unprocessed], ConsistentRead: keys.ConsistentRead}}
+			list = list[m.unprocessed:]
+			m.unprocessed = 0
+		}
+		for _, key := range list {
+			if item, ok := m.config[*key["id"].S]; ok {
+				out.Responses[table] = append(out.Responses[table], item)
+			}
+		}
+	}
+	return out, nil
+}
+
+// ScanPagesWithContext returns a page per item, so that paging is tested
+func (m *mockDynamoDBClient) ScanPagesWithContext(ctx aws.Context, input *dynamodb.ScanInput, fnc func(*dynamodb.ScanOutput, bool) bool, options ...request.Option) error {
+	m.scans++
+	var items []map[string]*dynamodb.AttributeValue
+	if id, ok := input.ExpressionAttributeValues[":id"]; ok {
+		// the audit log, filtered by the resource ID
+		for _, records := range m.items {
+			for _, item := range records {
+				if *item["id"].S == *id.S {
+					items = append(items, item)
+				}
+			}
+		}
+	} else {
+		prefix := *input.ExpressionAttributeValues[":prefix"].S
+		for id, item := range m.config {
+			if strings.HasPrefix(id, prefix) {
+				items = append(items, item)
+			}
+		}
+	}
+	for i, item := range items {
+		if !fnc(&dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{item}}, i == len(items)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockDynamoDBClient) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, options ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	var failed bool
+	var reasons []*dynamodb.CancellationReason
+	for _, item := range input.TransactItems {
+		reason := &dynamodb.CancellationReason{Code: aws.String("None")}
+		switch {
+		case item.Put != nil && !m.check(*item.Put.Item["id"].S, item.Put.ConditionExpression, item.Put.ExpressionAttributeValues),
+			item.Delete != nil && !m.check(*item.Delete.Key["id"].S, item.Delete.ConditionExpression, item.Delete.ExpressionAttributeValues):
+			reason.Code = aws.String("ConditionalCheckFailed")
+			failed = true
+		}
+		reasons = append(reasons, reason)
+	}
+	if failed {
+		return nil, &dynamodb.TransactionCanceledException{Message_: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+
+	for _, item := range input.TransactItems {
+		if item.Put != nil {
+			m.config[*item.Put.Item["id"].S] = item.Put.Item
+		}
+		if item.Delete != nil {
+			delete(m.config, *item.Delete.Key["id"].S)
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// check evaluates the few condition expressions the schedule and approval stores use
+func (m *mockDynamoDBClient) check(id string, condition *string, values map[string]*dynamodb.AttributeValue) bool {
+	item, exists := m.config[id]
+	switch aws.StringValue(condition) {
+	case "":
+		return true
+	case "attribute_not_exists(id)":
+		return !exists
+	case "version = :version":
+		return exists && *item["version"].N == *values[":version"].N
+	case "latest = :latest":
+		return exists && *item["latest"].N == *values[":latest"].N
+	case "requested = :requested":
+		return exists && *item["requested"].S == *values[":requested"].S
+	}
+	panic("unknown condition " + *condition)
+}
@@ -0,0 +1,61 @@
+package possum
+
+import "strings"
+
+// DefaultTagPrefix is the prefix of the tags possum reads and writes, unless the config sets another one
+const DefaultTagPrefix = "possum:"
+
+// TagKeys are the keys of the tags possum reads and writes on resources. Keys that aren't set are the prefix followed
+// by the default name, e.g. a prefix of acme:scheduler/ gives acme:scheduler/schedule. A nil TagKeys uses the defaults.
+type TagKeys struct {
+	Prefix        string // defaults to possum:
+	Schedule      string // the schedule of the resource, e.g. OfficeHours
+	Mode          string // overrides the mode of the schedule, e.g. stop-only
+	MinSize       string // the min size of an auto scaling group before possum stopped it
+	OverrideUntil string // a RFC3339 timestamp, possum leaves the resource alone until then
+	LastAction    string // the last action possum applied, start or stop, so that the console shows why it's stopped
+	LastActionAt  string // when possum applied the last action, a RFC3339 timestamp
+	Protected     string // possum never starts or stops the resource, unless the value is false
+	// possum only stops the resource once someone approves the stop, unless the value is false
+	RequireApproval string
+
+	// AutoScalingGroup is the tag AWS puts on the instances of an auto scaling group, possum leaves them to the group
+	AutoScalingGroup string
+}
+
+// resolved returns a copy of the keys with the defaults filled in
+func (k *TagKeys) resolved() *TagKeys {
+	var r TagKeys
+	if k != nil {
+		r = *k
+	}
+	if r.Prefix == "" {
+		r.Prefix = DefaultTagPrefix
+	}
+	for _, key := range []struct {
+		value *string
+		name  string
+	}{
+		{&r.Schedule, "schedule"},
+		{&r.Mode, "mode"},
+		{&r.MinSize, "min_size"},
+		{&r.OverrideUntil, "override_until"},
+		{&r.LastAction, "last_action"},
+		{&r.LastActionAt, "last_action_at"},
+		{&r.Protected, "protected"},
+		{&r.RequireApproval, "require_approval"},
+	} {
+		if *key.value == "" {
+			*key.value = r.Prefix + key.name
+		}
+	}
+	if r.AutoScalingGroup == "" {
+		r.AutoScalingGroup = "aws:autoscaling:groupName"
+	}
+	return &r
+}
+
+// tagEnabled returns true if the resource has the tag with any value other than false, e.g. the protected tag
+func tagEnabled(value *string) bool {
+	return value != nil && !strings.EqualFold(strings.TrimSpace(*value), "false")
+}
@@ -0,0 +1,64 @@
+package possum
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// defaultTags are the tag keys possum uses unless it's configured otherwise
+var defaultTags = (*TagKeys)(nil).resolved()
+
+func TestTagKeys(t *testing.T) {
+	tests := []struct {
+		keys     *TagKeys
+		expected TagKeys
+	}{
+		{nil, TagKeys{"possum:", "possum:schedule", "possum:mode", "possum:min_size", "possum:override_until", "possum:last_action", "possum:last_action_at", "possum:protected", "possum:require_approval", "aws:autoscaling:groupName"}},
+		{&TagKeys{Prefix: "acme:scheduler/"}, TagKeys{"acme:scheduler/", "acme:scheduler/schedule", "acme:scheduler/mode", "acme:scheduler/min_size", "acme:scheduler/override_until", "acme:scheduler/last_action", "acme:scheduler/last_action_at", "acme:scheduler/protected", "acme:scheduler/require_approval", "aws:autoscaling:groupName"}},
+		{&TagKeys{Schedule: "Schedule"}, TagKeys{"possum:", "Schedule", "possum:mode", "possum:min_size", "possum:override_until", "possum:last_action", "possum:last_action_at", "possum:protected", "possum:require_approval", "aws:autoscaling:groupName"}},
+	}
+
+	for i, test := range tests {
+		if actual := test.keys.resolved(); *actual != test.expected {
+			t.Errorf("case %d. expected %+v, got %+v", i+1, test.expected, *actual)
+		}
+	}
+}
+
+func TestDoInstances_TagPrefix(t *testing.T) {
+	office := NewSchedule("OfficeHours")
+	p, _ := NewPeriod("08:00", "18:00", nil)
+	office.AddPeriod(time.Local.String(), p)
+
+	instance := func(id, key string) *ec2.Instance {
+		return &ec2.Instance{
+			InstanceId: aws.String(id),
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			Tags:       []*ec2.Tag{{Key: aws.String(key), Value: aws.String(office.Name)}},
+		}
+	}
+	client := &mockEC2Client{describeInstanceResult: []*ec2.Instance{
+		instance("i-acme", "acme:scheduler/schedule"),
+		instance("i-possum", "possum:schedule"),
+	}}
+
+	tags := &TagKeys{Prefix: "acme:scheduler/"}
+	changes, err := DoInstances(context.Background(), client, newWeekday(time.Monday, 22, 0), Schedules{office}, tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || *changes[0].ID != "i-acme" || changes[0].Action != StopAction {
+		t.Fatalf("expected only the instance with the prefixed tag to be stopped, got %v", changes)
+	}
+	created := client.createTagsInput
+	if len(created) != 1 || *created[0].Tags[0].Key != "acme:scheduler/last_action" || *created[0].Tags[1].Key != "acme:scheduler/last_action_at" {
+		t.Errorf("expected the last action tags to have the prefix, got %v", created)
+	}
+	if tags.Schedule != "" {
+		t.Errorf("expected the keys that were passed in to be left alone")
+	}
+}
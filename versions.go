@@ -0,0 +1,466 @@
+package possum
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	versionItemPrefix = "version#" // followed by the version number
+	versionsItemID    = "versions" // holds the number of the latest version
+)
+
+// maxScheduleVersions is the number of versions that are kept, the oldest one is removed when a new one is recorded
+const maxScheduleVersions = 100
+
+// ScheduleVersion is the set of schedules after a change, every change to the schedules in the DynamoDB store is kept
+// as a numbered version, so that it can be compared with other versions and rolled back to
+type ScheduleVersion struct {
+	Number    int
+	Author    string // empty for the schedules that were stored before versions were kept
+	Time      time.Time
+	Schedules Schedules // as they were defined, without the versions of the schedules
+}
+
+// scheduleChange is a schedule that a version changed, as it was stored before and after, nil if it didn't exist. A
+// version only records the schedules it changed, the schedules of older versions are found by undoing the changes of
+// the newer ones.
+type scheduleChange struct {
+	Name   string
+	Before json.RawMessage `json:",omitempty"`
+	After  json.RawMessage `json:",omitempty"`
+}
+
+// versionWrites returns the writes that record the change from the previous to the next schedules as a new version
+// after latest, and remove the versions that are no longer kept. The first time, the previous schedules are recorded
+// as a version without changes if they changed, so that there is something to roll back to.
+func (s *DynamoDBScheduleStore) versionWrites(latest int, previous, next map[string]string) []*dynamodb.TransactWriteItem {
+	now := time.Now()
+	var writes []*dynamodb.TransactWriteItem
+	number := latest
+	if latest == 0 && len(previous) > 0 && !reflect.DeepEqual(previous, next) {
+		number++
+		writes = append(writes, s.versionItem(number, "", now, nil))
+	}
+	number++
+	writes = append(writes, s.versionItem(number, s.Author, now, changedSchedules(previous, next)))
+
+	for n := latest + 1; n <= number; n++ {
+		if n > maxScheduleVersions {
+			writes = append(writes, &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+				TableName: aws.String(s.Table),
+				Key:       map[string]*dynamodb.AttributeValue{"id": {S: aws.String(versionItemPrefix + strconv.Itoa(n-maxScheduleVersions))}},
+			}})
+		}
+	}
+
+	// the versions item also holds the names of the next schedules, so that they can be read without a scan
+	var names []*dynamodb.AttributeValue
+	for _, name := range sortedNames(next) {
+		names = append(names, &dynamodb.AttributeValue{S: aws.String(name)})
+	}
+
+	// the counter makes sure that two puts at the same time don't record the same version, or list other schedules
+	condition := aws.String("attribute_not_exists(id)")
+	var values map[string]*dynamodb.AttributeValue
+	if latest > 0 {
+		condition = aws.String("latest = :latest")
+		values = map[string]*dynamodb.AttributeValue{":latest": {N: aws.String(strconv.Itoa(latest))}}
+	}
+	writes = append(writes, &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+		TableName: aws.String(s.Table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id":        {S: aws.String(versionsItemID)},
+			"latest":    {N: aws.String(strconv.Itoa(number))},
+			"schedules": {L: append([]*dynamodb.AttributeValue{}, names...)},
+		},
+		ConditionExpression:       condition,
+		ExpressionAttributeValues: values,
+	}})
+	return writes
+}
+
+// sortedNames returns the names of the schedules in the contents, sorted
+func sortedNames(contents map[string]string) []string {
+	var names []string
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// changedSchedules returns the schedules whose content differs between previous and next, sorted by name
+func changedSchedules(previous, next map[string]string) []*scheduleChange {
+	var names []string
+	for name := range previous {
+		names = append(names, name)
+	}
+	for name := range next {
+		if _, ok := previous[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []*scheduleChange
+	for _, name := range names {
+		before, inPrevious := previous[name]
+		after, inNext := next[name]
+		if inPrevious && inNext && before == after {
+			continue
+		}
+		change := &scheduleChange{Name: name}
+		if inPrevious {
+			change.Before = json.RawMessage(before)
+		}
+		if inNext {
+			change.After = json.RawMessage(after)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// versionItem stores the changed schedules of the version as a JSON list
+func (s *DynamoDBScheduleStore) versionItem(number int, author string, ts time.Time, changes []*scheduleChange) *dynamodb.TransactWriteItem {
+	if changes == nil {
+		changes = []*scheduleChange{}
+	}
+	// the contents are schedules that were marshalled before, so they marshal again
+	b, _ := json.Marshal(changes)
+
+	item := map[string]*dynamodb.AttributeValue{
+		"id":      {S: aws.String(versionItemPrefix + strconv.Itoa(number))},
+		"number":  {N: aws.String(strconv.Itoa(number))},
+		"time":    {S: aws.String(ts.UTC().Format(time.RFC3339))},
+		"changes": {S: aws.String(string(b))},
+	}
+	if author != "" {
+		item["author"] = &dynamodb.AttributeValue{S: aws.String(author)}
+	}
+	return &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+		TableName:           aws.String(s.Table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	}}
+}
+
+// Revision returns the number of the latest version, every change to the schedules records a new one. It's empty for
+// tables from before versions were kept.
+func (s *DynamoDBScheduleStore) Revision(ctx context.Context) (string, error) {
+	latest, err := s.latestVersion(ctx)
+	if err != nil || latest == 0 {
+		return "", err
+	}
+	return strconv.Itoa(latest), nil
+}
+
+func (s *DynamoDBScheduleStore) latestVersion(ctx context.Context) (int, error) {
+	index, err := s.index(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return index.latest, nil
+}
+
+// scheduleIndex is the versions item: the latest version and the names of the schedules at that version
+type scheduleIndex struct {
+	latest int
+	names  []string
+}
+
+func (s *DynamoDBScheduleStore) index(ctx context.Context) (*scheduleIndex, error) {
+	out, err := s.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.Table),
+		Key:            map[string]*dynamodb.AttributeValue{"id": {S: aws.String(versionsItemID)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	index := &scheduleIndex{}
+	if attr, ok := out.Item["latest"]; ok && attr.N != nil {
+		if index.latest, err = strconv.Atoi(*attr.N); err != nil {
+			return nil, err
+		}
+	}
+	if attr, ok := out.Item["schedules"]; ok {
+		for _, name := range attr.L {
+			index.names = append(index.names, aws.StringValue(name.S))
+		}
+	}
+	return index, nil
+}
+
+// Versions returns the versions of the schedules without their schedules, oldest first
+func (s *DynamoDBScheduleStore) Versions(ctx context.Context) ([]*ScheduleVersion, error) {
+	var versions []*ScheduleVersion
+	var parseErr error
+	err := s.Client.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:                 aws.String(s.Table),
+		FilterExpression:          aws.String("begins_with(id, :prefix)"),
+		ProjectionExpression:      aws.String("#number, author, #time"),
+		ExpressionAttributeNames:  map[string]*string{"#number": aws.String("number"), "#time": aws.String("time")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":prefix": {S: aws.String(versionItemPrefix)}},
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			v, err := newScheduleVersion(item)
+			if err != nil {
+				parseErr = err
+				return false
+			}
+			versions = append(versions, v)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Number < versions[j].Number
+	})
+	return versions, nil
+}
+
+// Version returns the version with the number and its schedules. They are the stored schedules with the changes of
+// the newer versions undone.
+func (s *DynamoDBScheduleStore) Version(ctx context.Context, number int) (*ScheduleVersion, error) {
+	item, err := s.versionItemByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	v, err := newScheduleVersion(item)
+	if err != nil {
+		return nil, err
+	}
+
+	items, index, err := s.items(ctx)
+	if err != nil {
+		return nil, err
+	}
+	contents := make(map[string]string)
+	for _, item := range items {
+		contents[item.schedule.Name] = item.content
+	}
+	for n := index.latest; n > number; n-- {
+		newer, err := s.versionItemByNumber(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+		attr, ok := newer["changes"]
+		if !ok || attr.S == nil {
+			return nil, fmt.Errorf("version %d doesn't record its changes", n)
+		}
+		var changes []*scheduleChange
+		if err := json.Unmarshal([]byte(*attr.S), &changes); err != nil {
+			return nil, fmt.Errorf("version %d: %w", n, err)
+		}
+		for _, change := range changes {
+			if change.Before == nil {
+				delete(contents, change.Name)
+			} else {
+				contents[change.Name] = string(change.Before)
+			}
+		}
+	}
+
+	for _, name := range sortedNames(contents) {
+		schedule := &Schedule{}
+		if err := json.Unmarshal([]byte(contents[name]), schedule); err != nil {
+			return nil, fmt.Errorf("version %d, schedule '%s': %w", number, name, err)
+		}
+		v.Schedules = append(v.Schedules, schedule)
+	}
+	return v, nil
+}
+
+func (s *DynamoDBScheduleStore) versionItemByNumber(ctx context.Context, number int) (map[string]*dynamodb.AttributeValue, error) {
+	out, err := s.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.Table),
+		Key:            map[string]*dynamodb.AttributeValue{"id": {S: aws.String(versionItemPrefix + strconv.Itoa(number))}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Item) == 0 {
+		return nil, fmt.Errorf("there is no version %d of the schedules, only the latest %d versions are kept", number, maxScheduleVersions)
+	}
+	return out.Item, nil
+}
+
+// Rollback stores the schedules of the version, schedules that have been added since are removed. The rollback is
+// recorded as a new version.
+func (s *DynamoDBScheduleStore) Rollback(ctx context.Context, number int) error {
+	v, err := s.Version(ctx, number)
+	if err != nil {
+		return err
+	}
+
+	items, _, err := s.items(ctx)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]*scheduleItem)
+	for _, item := range items {
+		current[item.schedule.Name] = item
+	}
+
+	// the schedules replace whatever is stored now
+	for _, schedule := range v.Schedules {
+		schedule.Version = 0
+		if item, ok := current[schedule.Name]; ok {
+			schedule.Version = item.schedule.Version
+			delete(current, schedule.Name)
+		}
+	}
+	var remove []*scheduleItem
+	for _, item := range items {
+		if _, ok := current[item.schedule.Name]; ok {
+			remove = append(remove, item)
+		}
+	}
+
+	if _, err := v.Schedules.Compose(); err != nil {
+		return fmt.Errorf("can't roll back to version %d: %w", number, err)
+	}
+	return s.put(ctx, v.Schedules, remove)
+}
+
+func newScheduleVersion(item map[string]*dynamodb.AttributeValue) (*ScheduleVersion, error) {
+	v := &ScheduleVersion{}
+	var err error
+	if attr, ok := item["number"]; ok && attr.N != nil {
+		if v.Number, err = strconv.Atoi(*attr.N); err != nil {
+			return nil, err
+		}
+	}
+	if attr, ok := item["author"]; ok && attr.S != nil {
+		v.Author = *attr.S
+	}
+	if attr, ok := item["time"]; ok && attr.S != nil {
+		if v.Time, err = time.Parse(time.RFC3339, *attr.S); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// DiffSchedules returns the differences between two sets of schedules, schedule by schedule, as lines of their JSON
+// prefixed with "-" for lines that were removed and "+" for lines that were added. It's empty if there are none.
+func DiffSchedules(from, to Schedules) (string, error) {
+	before, err := indentedSchedules(from)
+	if err != nil {
+		return "", err
+	}
+	after, err := indentedSchedules(to)
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		a, inFrom := before[name]
+		c, inTo := after[name]
+		switch {
+		case !inFrom:
+			fmt.Fprintf(&b, "added schedule '%s'\n", name)
+		case !inTo:
+			fmt.Fprintf(&b, "removed schedule '%s'\n", name)
+		case a == c:
+			continue
+		default:
+			fmt.Fprintf(&b, "changed schedule '%s'\n", name)
+		}
+		for _, line := range diffLines(splitLines(a), splitLines(c)) {
+			fmt.Fprintln(&b, line)
+		}
+	}
+	return b.String(), nil
+}
+
+// indentedSchedules returns the indented JSON of the schedules as they were defined, keyed by name
+func indentedSchedules(schedules Schedules) (map[string]string, error) {
+	indented := make(map[string]string)
+	for _, schedule := range schedules {
+		c := *schedule.stored()
+		c.Version = 0
+		b, err := json.MarshalIndent(&c, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		indented[c.Name] = string(b)
+	}
+	return indented, nil
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffLines returns the lines of a and b, lines that are only in a are prefixed with "-" and lines that are only in b
+// with "+". It uses the longest common subsequence, schedules are small enough for that.
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+	return lines
+}
@@ -0,0 +1,198 @@
+package possum
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestDynamoDBScheduleStore_Versions(t *testing.T) {
+	client := newLegacyConfigTable(composeTestSchedules)
+	alice := &DynamoDBScheduleStore{Client: client, Table: "config", Author: "alice"}
+
+	schedules, err := alice.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedules.Find("UK Office").definition.WarnBefore = 45
+	if err := alice.PutSchedules(context.Background(), schedules); err != nil {
+		t.Fatal(err)
+	}
+
+	bob := &DynamoDBScheduleStore{Client: client, Table: "config", Author: "bob"}
+	if err := bob.PutSchedules(context.Background(), Schedules{NewSchedule("OfficeHours")}); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := bob.Versions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		author    string
+		schedules int
+	}{
+		{"", 3},      // the schedules as they were before versions were kept
+		{"alice", 3}, // UK Office warns 45 minutes before
+		{"bob", 4},   // OfficeHours is added
+	}
+	if len(versions) != len(tests) {
+		t.Fatalf("expected %d versions, got %d", len(tests), len(versions))
+	}
+	for i, test := range tests {
+		if versions[i].Number != i+1 || versions[i].Author != test.author || versions[i].Time.IsZero() {
+			t.Errorf("case %d. expected version %d by '%s', got %d by '%s' at %s", i+1, i+1, test.author, versions[i].Number, versions[i].Author, versions[i].Time)
+		}
+		v, err := bob.Version(context.Background(), i+1)
+		if err != nil {
+			t.Errorf("case %d. %s", i+1, err)
+			continue
+		}
+		if len(v.Schedules) != test.schedules {
+			t.Errorf("case %d. expected %d schedules, got %d", i+1, test.schedules, len(v.Schedules))
+		}
+	}
+
+	// a version only records the schedules it changed
+	if changes := *client.config[versionItemPrefix+"3"]["changes"].S; !strings.Contains(changes, "OfficeHours") || strings.Contains(changes, "UK Office") {
+		t.Errorf("expected version 3 to only record OfficeHours, got %s", changes)
+	}
+	if changes := *client.config[versionItemPrefix+"1"]["changes"].S; changes != "[]" {
+		t.Errorf("expected the first version to record no changes, got %s", changes)
+	}
+
+	if revision, err := bob.Revision(context.Background()); err != nil || revision != "3" {
+		t.Errorf("expected the latest version as the revision, got %s, %v", revision, err)
+	}
+
+	if _, err := bob.Version(context.Background(), 4); err == nil {
+		t.Errorf("expected an error for a version that doesn't exist")
+	}
+
+	// a put that started before another one was recorded doesn't record the same version
+	writes := alice.versionWrites(2, nil, map[string]string{"a": "{}"})
+	_, err = client.TransactWriteItemsWithContext(context.Background(), &dynamodb.TransactWriteItemsInput{TransactItems: writes})
+	if err == nil {
+		t.Errorf("expected the version counter to conflict")
+	}
+}
+
+func TestDynamoDBScheduleStore_PruneVersions(t *testing.T) {
+	client := &mockDynamoDBClient{config: make(map[string]map[string]*dynamodb.AttributeValue)}
+	store := &DynamoDBScheduleStore{Client: client, Table: "config"}
+
+	schedule := NewSchedule("Office")
+	for i := 0; i < maxScheduleVersions+2; i++ {
+		schedule.WarnBefore = i
+		if err := store.PutSchedules(context.Background(), Schedules{schedule}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := store.Versions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != maxScheduleVersions || versions[0].Number != 3 {
+		t.Fatalf("expected the latest %d versions from version 3, got %d from version %d", maxScheduleVersions, len(versions), versions[0].Number)
+	}
+	if _, err := store.Version(context.Background(), 2); err == nil {
+		t.Errorf("expected an error for a removed version")
+	}
+
+	v, err := store.Version(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Schedules) != 1 || v.Schedules[0].WarnBefore != 2 {
+		t.Errorf("expected the oldest kept version to be restored from the changes, got %v", v.Schedules)
+	}
+}
+
+func TestDynamoDBScheduleStore_Rollback(t *testing.T) {
+	client := newLegacyConfigTable(composeTestSchedules)
+	store := &DynamoDBScheduleStore{Client: client, Table: "config", Author: "alice"}
+
+	schedules, err := store.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedules.Find("NZ Office").definition.WarnBefore = 5
+	schedules = append(schedules, NewSchedule("OfficeHours"))
+	if err := store.PutSchedules(context.Background(), schedules); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Rollback(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	schedules, err = store.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 3 || schedules.Find("OfficeHours") != nil {
+		t.Errorf("expected the added schedule to be removed, got %v", schedules)
+	}
+	if s := schedules.Find("NZ Office"); s == nil || s.WarnBefore != 15 || s.Version != 2 {
+		t.Errorf("expected NZ Office to be restored as a new version, got %v", s)
+	}
+	if s := schedules.Find("UK Support"); s == nil || s.WarnBefore != 30 || s.Version != 1 {
+		t.Errorf("expected UK Support to be untouched, got %v", s)
+	}
+
+	versions, err := store.Versions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 {
+		t.Errorf("expected the rollback to be recorded as version 3, got %d versions", len(versions))
+	}
+
+	if err := store.Rollback(context.Background(), 9); err == nil {
+		t.Errorf("expected an error rolling back to a version that doesn't exist")
+	}
+}
+
+func TestDiffSchedules(t *testing.T) {
+	from, err := decodeSchedules([]byte(composeTestSchedules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := decodeSchedules([]byte(composeTestSchedules))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff, err := DiffSchedules(from, to); err != nil || diff != "" {
+		t.Errorf("expected no differences, got %q, %v", diff, err)
+	}
+
+	for _, schedule := range to {
+		if schedule.Name == "NZ Office" {
+			schedule.definition.WarnBefore = 5
+		}
+	}
+	to = append(to, NewSchedule("OfficeHours"))
+
+	diff, err := DiffSchedules(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"changed schedule 'NZ Office'",
+		`-   "WarnBefore": 15,`,
+		`+   "WarnBefore": 5,`,
+		"added schedule 'OfficeHours'",
+		`+   "Name": "OfficeHours",`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(diff, line) {
+			t.Errorf("expected the diff to contain %q, got\n%s", line, diff)
+		}
+	}
+	if strings.Contains(diff, "UK Office") {
+		t.Errorf("expected unchanged schedules to be left out, got\n%s", diff)
+	}
+}
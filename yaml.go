@@ -0,0 +1,90 @@
+package possum
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UnmarshalSchedules reads schedules in JSON or YAML. YAML is converted to JSON first, so that both formats are read
+// by the same code and mean the same thing.
+func UnmarshalSchedules(b []byte) (Schedules, error) {
+	if !isJSON(b) {
+		var err error
+		if b, err = yamlToJSON(b); err != nil {
+			return nil, err
+		}
+	}
+	var schedules Schedules
+	if err := json.Unmarshal(b, &schedules); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// MarshalSchedulesYAML writes the schedules as YAML, with the same fields as their JSON
+func MarshalSchedulesYAML(schedules Schedules) ([]byte, error) {
+	b, err := json.Marshal(schedules)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML, so it's read into a node that keeps the order of the fields and written back out in block style
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+	blockStyle(&node)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// IsYAMLPath returns true if the file extension of the path is a YAML one
+func IsYAMLPath(path string) bool {
+	return strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml")
+}
+
+// isJSON returns true if the content looks like a JSON array or object rather than a YAML document
+func isJSON(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	return len(b) > 0 && (b[0] == '[' || b[0] == '{')
+}
+
+func yamlToJSON(b []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// blockStyle clears the flow style of the JSON and leaves out null fields. Times stay quoted so that YAML 1.1 parsers
+// don't read 18:00 as a number in base 60.
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" && strings.Contains(node.Value, ":") {
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	if node.Kind == yaml.MappingNode {
+		var content []*yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i+1].Tag != "!!null" {
+				content = append(content, node.Content[i], node.Content[i+1])
+			}
+		}
+		node.Content = content
+	}
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
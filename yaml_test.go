@@ -0,0 +1,70 @@
+package possum
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMarshalSchedulesYAML(t *testing.T) {
+	schedules, err := decodeSchedules([]byte(composeTestSchedules))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := MarshalSchedulesYAML(schedules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"- Name: NZ Office\n", `    - StartTime: "08:00"` + "\n", "  Extends: NZ Office\n"} {
+		if !strings.Contains(string(b), line) {
+			t.Errorf("expected the YAML to contain %q, got\n%s", line, b)
+		}
+	}
+	if strings.Contains(string(b), "null") {
+		t.Errorf("expected empty fields to be left out, got\n%s", b)
+	}
+
+	// the YAML is read back as the same schedules
+	actual, err := UnmarshalSchedules(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := json.Marshal(schedules)
+	if b, _ := json.Marshal(actual); string(b) != string(expected) {
+		t.Errorf("expected %s, got %s", expected, b)
+	}
+}
+
+func TestUnmarshalSchedules(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected string // the JSON of the schedules, empty if the content is invalid
+	}{
+		{`[{"Name": "a"}]`, `[{"Name":"a","Periods":null}]`},
+		{"- Name: a\n", `[{"Name":"a","Periods":null}]`},
+		{
+			"- Name: a\n  Timezone: Europe/London\n  Periods:\n    - StartTime: 8:00\n      StopTime: '18:00'\n      Weekdays: [Monday]\n",
+			`[{"Name":"a","Periods":[{"StartTime":"08:00","StopTime":"18:00","Weekdays":["Monday"],"Timezone":"Europe/London"}],"Timezone":"Europe/London"}]`,
+		},
+		{"- Name: a\n  Mode: sometimes\n", ""},
+		{"- Name: [a\n", ""},
+	}
+
+	for i, test := range tests {
+		schedules, err := UnmarshalSchedules([]byte(test.content))
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("case %d. expected an error", i+1)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d. %s", i+1, err)
+			continue
+		}
+		if b, _ := json.Marshal(schedules); string(b) != test.expected {
+			t.Errorf("case %d. expected %s, got %s", i+1, test.expected, b)
+		}
+	}
+}